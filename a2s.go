@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// a2sHeader starts every single-packet A2S request and response.
+var a2sHeader = []byte{0xff, 0xff, 0xff, 0xff}
+
+// queryA2SInfo asks the Source engine server at addr for its status with
+// A2S_INFO, answering the challenge newer servers send first.
+func queryA2SInfo(addr string) (*serverStatus, error) {
+	conn, err := net.DialTimeout("udp", addr, queryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	req := append(append([]byte{}, a2sHeader...), 'T')
+	req = append(req, "Source Engine Query\x00"...)
+
+	buf := make([]byte, 1400)
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp := buf[:n]
+		if n < 5 || !bytes.Equal(resp[:4], a2sHeader) {
+			return nil, errors.New("malformed A2S response")
+		}
+
+		switch resp[4] {
+		case 'A':
+			if n < 9 {
+				return nil, errors.New("malformed A2S challenge")
+			}
+			req = append(req[:25], resp[5:9]...)
+		case 'I':
+			return parseA2SInfo(resp[5:])
+		default:
+			return nil, fmt.Errorf("unexpected A2S response %#x", resp[4])
+		}
+	}
+	return nil, errors.New("A2S challenge was not accepted")
+}
+
+// parseA2SInfo reads the name, players and version from an A2S_INFO
+// response following its header.
+func parseA2SInfo(b []byte) (*serverStatus, error) {
+	r := bytes.NewReader(b)
+	str := func() string {
+		var s []byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil || c == 0 {
+				return string(s)
+			}
+			s = append(s, c)
+		}
+	}
+
+	r.ReadByte() // protocol
+	name := str()
+	str() // map
+	str() // folder
+	str() // game
+
+	var counts struct {
+		ID         uint16
+		Players    byte
+		MaxPlayers byte
+		Bots       byte
+		Type       byte
+		Env        byte
+		Visibility byte
+		VAC        byte
+	}
+	err := binary.Read(r, binary.LittleEndian, &counts)
+	if err != nil {
+		return nil, fmt.Errorf("malformed A2S_INFO response: %w", err)
+	}
+
+	return &serverStatus{
+		MOTD:       name,
+		Version:    str(),
+		Players:    int(counts.Players),
+		MaxPlayers: int(counts.MaxPlayers),
+	}, nil
+}
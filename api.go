@@ -0,0 +1,434 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// eventBufferSize is how many events a stream may fall behind before events
+// are dropped for it.
+const eventBufferSize = 256
+
+// eventHub fans the events of jobs out to HTTP streams.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[string][]chan Event
+}
+
+var hub = &eventHub{subs: map[string][]chan Event{}}
+
+func (h *eventHub) Handle(j *job, e *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, ch := range h.subs[j.ID] {
+		// Streams that cannot keep up miss events rather than holding
+		// up the job; they are still closed when it finishes.
+		select {
+		case ch <- *e:
+		default:
+		}
+
+		if e.Type == EventFinished {
+			close(ch)
+		}
+	}
+
+	if e.Type == EventFinished {
+		delete(h.subs, j.ID)
+	}
+}
+
+// subscribe returns a channel receiving the events of j until it finishes,
+// or nil if it already has.
+func (h *eventHub) subscribe(j *job) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	j.mu.Lock()
+	done := j.Done
+	j.mu.Unlock()
+	if done {
+		return nil, func() {}
+	}
+
+	ch := make(chan Event, eventBufferSize)
+	h.subs[j.ID] = append(h.subs[j.ID], ch)
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		subs := h.subs[j.ID]
+		for i, sub := range subs {
+			if sub == ch {
+				h.subs[j.ID] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// jobStatus is a job as returned by the HTTP API.
+type jobStatus struct {
+	ID         string   `json:"id"`
+	Profile    string   `json:"profile"`
+	Phase      string   `json:"phase"`
+	Priority   string   `json:"priority"`
+	Files      int      `json:"files"`
+	TotalFiles int      `json:"total_files"`
+	Bytes      int64    `json:"bytes"`
+	TotalBytes int64    `json:"total_bytes"`
+	Warnings   []string `json:"warnings"`
+	Done       bool     `json:"done"`
+	Error      string   `json:"error,omitempty"`
+	ErrorCode  string   `json:"error_code,omitempty"`
+	Note       string   `json:"note,omitempty"`
+	MessageURL string   `json:"message_url,omitempty"`
+	ChannelURL string   `json:"channel_url,omitempty"`
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := jobStatus{
+		ID:         j.ID,
+		Profile:    j.Profile.Name,
+		Phase:      j.Phase,
+		Priority:   j.Priority,
+		Files:      j.Files,
+		TotalFiles: j.TotalFiles,
+		Bytes:      j.Bytes,
+		TotalBytes: j.TotalBytes,
+		Warnings:   append([]string{}, j.Warnings...),
+		Done:       j.Done,
+		Note:       j.Note,
+		MessageURL: j.MessageURL,
+		ChannelURL: j.ChannelURL,
+	}
+	sort.Strings(s.Warnings)
+	if j.Done && j.Err != nil {
+		s.Error = j.Err.Error()
+		s.ErrorCode = errorCodeOf(j.Err).Code
+	}
+	return s
+}
+
+//go:embed web
+var webFiles embed.FS
+
+// serveAPI serves the HTTP API and the dashboard on addr.
+func serveAPI(addr string) {
+	web, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		log.Fatalf("Error loading dashboard: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(web)))
+	mux.HandleFunc("/profiles", readOnly(handleProfiles))
+	mux.HandleFunc("/history", readOnly(handleHistory))
+	mux.HandleFunc("/jobs", handleJobList)
+	mux.HandleFunc("/jobs/", handleJobs)
+	mux.HandleFunc("/deploy", handleDeploy)
+	if linkOAuth != nil {
+		mux.HandleFunc("/link", linkOAuth.handleStart)
+		mux.HandleFunc("/link/callback", linkOAuth.handleCallback)
+		mux.HandleFunc("/link/key", linkOAuth.handleKey)
+	}
+
+	err = http.ListenAndServe(addr, mux)
+	if err != nil {
+		log.Fatalf("Error serving HTTP API: %s", err)
+	}
+}
+
+// readOnly wraps a handler that requires the read scope.
+func readOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r, scopeRead) {
+			h(w, r)
+		}
+	}
+}
+
+// profileInfo is a profile as returned by the HTTP API.
+type profileInfo struct {
+	Name        string   `json:"name"`
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Preset      string   `json:"preset,omitempty"`
+	Keep        []string `json:"keep"`
+	Exclude     []string `json:"exclude"`
+	Merge       []string `json:"merge"`
+}
+
+func handleProfiles(w http.ResponseWriter, r *http.Request) {
+	infos := []profileInfo{}
+	for _, p := range allProfiles() {
+		infos = append(infos, profileInfo{
+			Name:        p.Name,
+			Source:      p.Source,
+			Destination: p.Destination,
+			Preset:      p.Preset,
+			Keep:        p.keep,
+			Exclude:     p.exclude,
+			Merge:       p.merge,
+		})
+	}
+	writeJSON(w, infos)
+}
+
+// historyLimit is the number of most recent job records served.
+const historyLimit = 50
+
+// recentHistory returns the most recent job records, oldest first.
+func recentHistory() ([]jobRecord, error) {
+	records, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) > historyLimit {
+		records = records[len(records)-historyLimit:]
+	}
+	return records, nil
+}
+
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	records, err := recentHistory()
+	if err != nil {
+		log.Printf("Error loading job history: %s", err)
+		http.Error(w, "failed to load job history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, records)
+}
+
+// listJobs returns the jobs in the registry, most recent first.
+func listJobs() []*job {
+	jobsMu.Lock()
+	list := []*job{}
+	for _, j := range jobs {
+		list = append(list, j)
+	}
+	jobsMu.Unlock()
+
+	sort.Slice(list, func(a, b int) bool { return list[a].started().After(list[b].started()) })
+	return list
+}
+
+// handleJobList lists jobs on GET and starts one on POST.
+func handleJobList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !authorized(w, r, scopeRead) {
+			return
+		}
+
+		statuses := []jobStatus{}
+		for _, j := range listJobs() {
+			statuses = append(statuses, j.status())
+		}
+		writeJSON(w, statuses)
+	case http.MethodPost:
+		if authorized(w, r, scopeCopy) {
+			startJob(w, r)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// startJobRequest is the body of POST /jobs.
+type startJobRequest struct {
+	Profile string `json:"profile"`
+	Force   bool   `json:"force"`
+	Note    string `json:"note"`
+
+	// Priority is "interactive", the default, or "background" for
+	// scheduled syncs.
+	Priority string `json:"priority"`
+
+	// DryRun only reports what the release would do. Sandbox profiles
+	// default to it.
+	DryRun *bool `json:"dry_run"`
+
+	// WipeDestination confirms releasing a profile without keep rules,
+	// which deletes all destination files.
+	WipeDestination bool `json:"wipe_destination"`
+}
+
+func startJob(w http.ResponseWriter, r *http.Request) {
+	var req startJobRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := parsePriority(req.Priority); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := launchJob(&req, nil)
+	if errors.Is(err, errUnknownProfile) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, j.status())
+}
+
+var errUnknownProfile = errors.New("profile not found")
+
+// launchJob starts a copy of the named profile in the background and
+// notifies the notifiers about it. setup, if given, can set options of the
+// job before it starts.
+func launchJob(req *startJobRequest, setup func(j *job)) (*job, error) {
+	p := findProfile(req.Profile)
+	if p == nil {
+		return nil, errUnknownProfile
+	}
+	p = p.target()
+
+	priority, err := parsePriority(req.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := p.Sandbox
+	if req.DryRun != nil {
+		dryRun = *req.DryRun
+	}
+
+	if reason := detectRunningServer(p.dstDir); reason != "" && !req.Force && !dryRun {
+		return nil, fmt.Errorf("destination server appears to be running: %s", reason)
+	} else if p.keepsNothing() && !req.WipeDestination && !dryRun {
+		return nil, fmt.Errorf("profile %s has no keep rules, so ALL destination files would be deleted; set wipe_destination to confirm", p.Name)
+	}
+
+	j := newJob(p, true)
+	j.DryRun = dryRun
+	j.Note = req.Note
+	j.Priority = priority
+	if setup != nil {
+		setup(j)
+	}
+	ch := make(chan bool)
+	go copy(j, ch)
+
+	notifyAll(notifiers, startedNotification(j))
+	go func() {
+		success := <-ch
+		notifyAll(notifiers, resultNotification(j, success))
+	}()
+
+	return j, nil
+}
+
+// handleJobs serves /jobs/{id}, /jobs/{id}/events, /jobs/{id}/cancel and
+// /jobs/{id}/report.
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if !authorized(w, r, scopeRead) {
+		return
+	}
+
+	// Reports outlive the jobs they describe.
+	if rest == "report" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, reportFile(id))
+		return
+	}
+
+	j := findJob(id)
+	if j == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		writeJSON(w, j.status())
+	case rest == "events" && r.Method == http.MethodGet:
+		streamEvents(w, r, j)
+	case rest == "cancel" && r.Method == http.MethodPost:
+		if authorized(w, r, scopeCopy) {
+			j.Cancel()
+			writeJSON(w, j.status())
+		}
+	case rest == "" || rest == "events" || rest == "cancel" || rest == "report":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// streamEvents sends the events of j as server-sent events, starting with
+// its current status.
+func streamEvents(w http.ResponseWriter, r *http.Request, j *job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := hub.subscribe(j)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	writeEvent(w, "status", j.status())
+	flusher.Flush()
+	if events == nil {
+		return
+	}
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, string(e.Type), e)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding event: %s", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Error writing response: %s", err)
+	}
+}
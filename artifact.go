@@ -0,0 +1,259 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// artifactSource is an archive, such as a CI build artifact, released
+// instead of a source server. The URL may contain {build}, which is replaced
+// by the build option of /copy.
+type artifactSource struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+
+	// Format is zip or tar.gz; it is guessed from the URL if empty.
+	Format string `yaml:"format"`
+
+	// StripComponents removes leading path elements from archive entries,
+	// like tar --strip-components.
+	StripComponents int `yaml:"strip_components"`
+}
+
+// artifactMu serializes downloads, as jobs of the same profile share an
+// extraction directory.
+var artifactMu sync.Mutex
+
+func (a *artifactSource) init() error {
+	if a.URL == "" {
+		return fmt.Errorf("artifact.url: no URL found")
+	}
+
+	if a.Format == "" {
+		switch {
+		case strings.HasSuffix(a.URL, ".zip"):
+			a.Format = "zip"
+		case strings.HasSuffix(a.URL, ".tar.gz"), strings.HasSuffix(a.URL, ".tgz"):
+			a.Format = "tar.gz"
+		case strings.Contains(a.URL, "{build}"):
+			return fmt.Errorf("artifact.format: required when the URL contains {build}")
+		default:
+			return fmt.Errorf("artifact.format: cannot guess format of %s", a.URL)
+		}
+	} else if a.Format != "zip" && a.Format != "tar.gz" {
+		return fmt.Errorf("artifact.format: unknown format %q", a.Format)
+	}
+
+	return nil
+}
+
+func (a *artifactSource) String() string {
+	return a.URL
+}
+
+// artifactDir is where the artifact of a profile is extracted to.
+func artifactDir(name string) string {
+	return filepath.Join(dataDir, "artifacts", name)
+}
+
+// fetch downloads the artifact for build, verifies its checksum and
+// extracts it into dir, replacing what was there. url and checksum override
+// the configured URL and SHA-256 if set.
+func (a *artifactSource) fetch(dir string, url string, build string, checksum string) error {
+	artifactMu.Lock()
+	defer artifactMu.Unlock()
+
+	if url == "" {
+		url = a.URL
+	}
+	if strings.Contains(url, "{build}") {
+		if build == "" {
+			return fmt.Errorf("a build is required for %s", url)
+		}
+		url = strings.ReplaceAll(url, "{build}", build)
+	}
+
+	if checksum == "" {
+		checksum = a.SHA256
+	}
+
+	err := os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return err
+	}
+
+	archive, err := os.CreateTemp(filepath.Dir(dir), "download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	err = download(url, archive, checksum)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+
+	done, err := useCPU(context.Background())
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if a.Format == "zip" {
+		return a.extractZip(archive, dir)
+	}
+	return a.extractTarGz(archive, dir)
+}
+
+func download(url string, f *os.File, checksum string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("downloading %s: unexpected status %s", url, resp.Status)
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); checksum != "" && !strings.EqualFold(sum, checksum) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", checksum, sum)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// entryPath returns where the archive entry name is extracted to below dir,
+// or an empty string if it is stripped entirely.
+func (a *artifactSource) entryPath(dir string, name string) (string, error) {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(name), "/"), "/")
+	if len(parts) <= a.StripComponents {
+		return "", nil
+	}
+
+	path := filepath.Join(dir, filepath.Join(parts[a.StripComponents:]...))
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %s is outside of the archive", name)
+	}
+
+	return path, nil
+}
+
+func (a *artifactSource) extractZip(f *os.File, dir string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, file := range r.File {
+		path, err := a.entryPath(dir, file.Name)
+		if err != nil {
+			return err
+		} else if path == "" {
+			continue
+		}
+
+		if file.FileInfo().IsDir() {
+			err := os.MkdirAll(path, 0755)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(path, rc, file.Mode())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *artifactSource) extractTarGz(f *os.File, dir string) error {
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	r := tar.NewReader(gz)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path, err := a.entryPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		} else if path == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = extractFile(path, r, hdr.FileInfo().Mode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(path string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
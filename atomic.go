@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// atomicWrites replaces every copied file through a rename, so that even a
+// job killed mid-write never leaves a partially written file behind. Files
+// are then never updated in place, which turns off delta syncing and the
+// large file settings.
+var atomicWrites bool
+
+// archiveExtensions are the files a running JVM may have memory-mapped.
+// Truncating one of them in place crashes the JVM when it next reads from
+// it, so they are always replaced through a rename.
+var archiveExtensions = []string{".jar", ".zip"}
+
+// writeAtomically reports whether path is replaced through a rename.
+func writeAtomically(path string) bool {
+	return atomicWrites || isArchive(path)
+}
+
+func isArchive(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range archiveExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceFile copies srcPath to a temporary file next to dstPath and renames
+// it over dstPath. Readers that have the old file open keep seeing it, and
+// nobody ever sees a partially written file. It returns the number of bytes
+// copied.
+func replaceFile(srcPath string, dstPath string, mode os.FileMode) (int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".releaser-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Chmod(mode.Perm())
+	}
+	if err == nil && durability == durabilityFile {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return n, os.Rename(tmp.Name(), dstPath)
+}
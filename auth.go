@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes granted to API clients. The copy scope includes read.
+const (
+	scopeRead = "read"
+	scopeCopy = "copy"
+)
+
+// jwksRefreshInterval is how often the OIDC signing keys may be fetched
+// again when a token is signed with an unknown key.
+const jwksRefreshInterval = time.Minute
+
+// apiAuth configures who may use the HTTP API. Without any tokens or OIDC
+// the API can be read by anyone and nothing can be started over it.
+type apiAuth struct {
+	Tokens []*apiToken   `yaml:"tokens"`
+	OIDC   *oidcVerifier `yaml:"oidc"`
+}
+
+// apiToken is a static bearer token.
+type apiToken struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Scopes []string `yaml:"scopes"`
+}
+
+var auth = &apiAuth{}
+
+func (a *apiAuth) init() error {
+	for i, t := range a.Tokens {
+		if t.Name == "" {
+			return fmt.Errorf("api.tokens[%d]: no name", i)
+		} else if t.Token == "" {
+			return fmt.Errorf("api.tokens[%d]: no token", i)
+		}
+
+		err := checkScopes(t.Scopes)
+		if err != nil {
+			return fmt.Errorf("api.tokens[%d]: %w", i, err)
+		}
+	}
+
+	if a.OIDC != nil {
+		err := a.OIDC.init()
+		if err != nil {
+			return fmt.Errorf("api.oidc: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func checkScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("no scopes")
+	}
+
+	for _, scope := range scopes {
+		if scope != scopeRead && scope != scopeCopy {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	return nil
+}
+
+// addToken adds API_TOKEN, which is granted every scope.
+func (a *apiAuth) addToken(token string) {
+	if token == "" {
+		return
+	}
+
+	a.Tokens = append(a.Tokens, &apiToken{Name: "API_TOKEN", Token: token, Scopes: []string{scopeCopy}})
+}
+
+func (a *apiAuth) enabled() bool {
+	return len(a.Tokens) > 0 || a.OIDC != nil
+}
+
+// errUnauthenticated is returned for requests without a valid token.
+var errUnauthenticated = errors.New("unauthorized")
+
+// allow checks that token grants scope. It returns errUnauthenticated if
+// the token is missing or invalid, and another error if it lacks the scope.
+func (a *apiAuth) allow(token, scope string) error {
+	if !a.enabled() {
+		if scope == scopeRead {
+			return nil
+		}
+		return fmt.Errorf("no API tokens configured")
+	}
+
+	scopes, err := a.scopes(token)
+	if err != nil {
+		return errUnauthenticated
+	}
+
+	if !slices.Contains(scopes, scope) && !slices.Contains(scopes, scopeCopy) {
+		return fmt.Errorf("missing scope %q", scope)
+	}
+
+	return nil
+}
+
+// scopes returns the scopes granted to a bearer token.
+func (a *apiAuth) scopes(token string) ([]string, error) {
+	if token == "" {
+		return nil, fmt.Errorf("no bearer token")
+	}
+
+	for _, t := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return t.Scopes, nil
+		}
+	}
+
+	if a.OIDC != nil && strings.Count(token, ".") == 2 {
+		return a.OIDC.verify(token)
+	}
+
+	return nil, fmt.Errorf("unknown token")
+}
+
+// authorized reports whether r may use an endpoint requiring scope,
+// replying with an error if it may not. The token is taken from the
+// Authorization header, or from the access_token parameter for clients such
+// as EventSource that cannot set headers.
+func authorized(w http.ResponseWriter, r *http.Request, scope string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("access_token")
+	}
+
+	err := auth.allow(token, scope)
+	if errors.Is(err, errUnauthenticated) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="releaser"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// oidcVerifier accepts ID or access tokens issued by an OpenID Connect
+// provider. Claim lists the values in the token that are mapped to scopes
+// through Scopes; values without a mapping are used as scopes themselves.
+type oidcVerifier struct {
+	Issuer   string              `yaml:"issuer"`
+	Audience string              `yaml:"audience"`
+	Claim    string              `yaml:"claim"`
+	Scopes   map[string][]string `yaml:"scopes"`
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func (v *oidcVerifier) init() error {
+	if v.Issuer == "" {
+		return fmt.Errorf("no issuer")
+	} else if v.Audience == "" {
+		return fmt.Errorf("no audience")
+	}
+
+	if v.Claim == "" {
+		v.Claim = "scope"
+	}
+
+	for value, scopes := range v.Scopes {
+		err := checkScopes(scopes)
+		if err != nil {
+			return fmt.Errorf("scopes.%s: %w", value, err)
+		}
+	}
+
+	return nil
+}
+
+// verify checks the signature and claims of a JWT and returns the scopes it
+// grants.
+func (v *oidcVerifier) verify(token string) ([]string, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+		}
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig)
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, hash[:], r, s) {
+			err = errors.New("verification failed")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+
+	var claims map[string]any
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+
+	if claims["iss"] != v.Issuer {
+		return nil, fmt.Errorf("wrong issuer %v", claims["iss"])
+	} else if !slices.Contains(claimValues(claims["aud"]), v.Audience) {
+		return nil, fmt.Errorf("wrong audience %v", claims["aud"])
+	}
+
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); !ok || now >= exp {
+		return nil, fmt.Errorf("token expired")
+	} else if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, fmt.Errorf("token not yet valid")
+	}
+
+	var scopes []string
+	for _, value := range claimValues(claims[v.Claim]) {
+		if mapped, ok := v.Scopes[value]; ok {
+			scopes = append(scopes, mapped...)
+		} else {
+			scopes = append(scopes, value)
+		}
+	}
+
+	return scopes, nil
+}
+
+// claimValues returns a claim that is either a space-separated string or a
+// list of strings as a list.
+func claimValues(claim any) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []any:
+		values := []string{}
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// key returns the signing key with the given ID, fetching the keys of the
+// provider if it is not known yet.
+func (v *oidcVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, ok := v.keys[kid]
+	if !ok && time.Since(v.fetched) > jwksRefreshInterval {
+		v.fetched = time.Now()
+		keys, err := fetchKeys(v.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("fetching signing keys: %w", err)
+		}
+
+		v.keys = keys
+		key, ok = keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// fetchKeys reads the RSA and P-256 signing keys of an OpenID Connect
+// provider through its discovery document.
+func fetchKeys(issuer string) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	err := getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	err = getJSON(discovery.JWKSURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	return keys, nil
+}
+
+func getJSON(url string, v any) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
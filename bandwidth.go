@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bandwidth limits how fast all jobs together copy, depending on the time
+// of day, so that syncs do not lag the servers during peak hours.
+type bandwidth struct {
+	// Limit is the rate outside of the schedule, such as 50MiB. Without it,
+	// copies are unlimited outside of the schedule.
+	Limit string `yaml:"limit"`
+
+	// Schedule overrides Limit during windows of the day. The first window
+	// containing the current time applies.
+	Schedule []*bandwidthWindow `yaml:"schedule"`
+
+	limit int64
+}
+
+// bandwidthWindow is a window of the day, in local time, with its own limit.
+// Windows ending before they start span midnight.
+type bandwidthWindow struct {
+	From  string `yaml:"from"`
+	To    string `yaml:"to"`
+	Limit string `yaml:"limit"`
+
+	from  time.Duration
+	to    time.Duration
+	limit int64
+}
+
+func (b *bandwidth) init() error {
+	var err error
+	b.limit, err = parseRate(b.Limit)
+	if err != nil {
+		return fmt.Errorf("bandwidth.limit: %w", err)
+	}
+
+	for i, w := range b.Schedule {
+		err := w.init()
+		if err != nil {
+			return fmt.Errorf("bandwidth.schedule[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (w *bandwidthWindow) init() error {
+	var err error
+	w.from, err = parseTimeOfDay(w.From)
+	if err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	w.to, err = parseTimeOfDay(w.To)
+	if err != nil {
+		return fmt.Errorf("to: %w", err)
+	} else if w.from == w.to {
+		return fmt.Errorf("from and to must differ")
+	}
+
+	w.limit, err = parseRate(w.Limit)
+	if err != nil {
+		return fmt.Errorf("limit: %w", err)
+	}
+
+	return nil
+}
+
+// parseRate parses a rate in bytes per second such as 50MiB or 50MiB/s.
+// Empty and "unlimited" rates are 0.
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	if s == "" || s == "unlimited" {
+		return 0, nil
+	}
+	return parseSize(s)
+}
+
+// parseTimeOfDay parses a time such as 02:00 into the time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w *bandwidthWindow) contains(now time.Time) bool {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	t := now.Sub(midnight)
+	if w.from < w.to {
+		return t >= w.from && t < w.to
+	}
+	return t >= w.from || t < w.to
+}
+
+// rate returns the limit in bytes per second at now, or 0 if unlimited.
+func (b *bandwidth) rate(now time.Time) int64 {
+	for _, w := range b.Schedule {
+		if w.contains(now) {
+			return w.limit
+		}
+	}
+	return b.limit
+}
+
+// bandwidthLimits is nil unless bandwidth is configured.
+var bandwidthLimits *bandwidth
+
+// limiter paces writes to a rate shared by everything using it.
+type limiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// copyLimiter is shared by all jobs.
+var copyLimiter limiter
+
+// wait blocks until n more bytes may be written at rate bytes per second.
+func (l *limiter) wait(ctx context.Context, n int64, rate int64) error {
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(time.Duration(n * int64(time.Second) / rate))
+	l.mu.Unlock()
+
+	if d := start.Sub(now); d > 0 {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(d):
+		}
+	}
+	return nil
+}
+
+// throttle waits until j may write n more bytes under the bandwidth limits
+// and, for background jobs, the background limit. It also holds paused jobs.
+func (j *job) throttle(n int64) error {
+	if err := j.checkpoint(); err != nil {
+		return err
+	}
+
+	if j.Priority == priorityBackground && background != nil && background.limit > 0 {
+		err := backgroundLimiter.wait(j.ctx, n, background.limit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if bandwidthLimits == nil {
+		return nil
+	}
+
+	rate := bandwidthLimits.rate(time.Now())
+	if rate == 0 {
+		return nil
+	}
+	return copyLimiter.wait(j.ctx, n, rate)
+}
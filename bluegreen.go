@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// panelApp talks to the Pterodactyl application API, which is needed to move
+// allocations between servers. It is nil unless PANEL_APPLICATION_KEY is set.
+var panelApp *panelClient
+
+var blueGreenMu sync.Mutex
+
+func blueGreenPath() string {
+	return filepath.Join(dataDir, "bluegreen.json")
+}
+
+// readLiveServers returns the server currently serving players for each
+// blue/green profile.
+func readLiveServers() (map[string]string, error) {
+	live := map[string]string{}
+
+	data, err := os.ReadFile(blueGreenPath())
+	if os.IsNotExist(err) {
+		return live, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &live)
+	return live, err
+}
+
+// liveServer returns the server of p that currently serves players.
+func liveServer(p *profile) string {
+	blueGreenMu.Lock()
+	defer blueGreenMu.Unlock()
+
+	live, err := readLiveServers()
+	if err != nil {
+		return p.Destination
+	}
+
+	if server, ok := live[p.Name]; ok && (server == p.Destination || server == p.Standby) {
+		return server
+	}
+	return p.Destination
+}
+
+func setLiveServer(p *profile, server string) error {
+	blueGreenMu.Lock()
+	defer blueGreenMu.Unlock()
+
+	live, err := readLiveServers()
+	if err != nil {
+		return err
+	}
+	live[p.Name] = server
+
+	data, err := json.MarshalIndent(live, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dataDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := blueGreenPath() + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, blueGreenPath())
+}
+
+// target returns the profile a release of p copies into. For blue/green
+// profiles this is a copy of p whose destination is the standby server.
+func (p *profile) target() *profile {
+	if p.Standby == "" {
+		return p
+	}
+
+	live := liveServer(p)
+	standby := p.Standby
+	if live == p.Standby {
+		standby = p.Destination
+	}
+
+	t := *p
+	t.Destination = standby
+	t.dstDir = serverDir(standby)
+	t.live = live
+	return &t
+}
+
+// released returns p pointed at the server that serves players, which is
+// the one released to last for blue/green profiles.
+func (p *profile) released() *profile {
+	if p.Standby == "" {
+		return p
+	}
+
+	live := liveServer(p)
+	t := *p
+	t.Destination = live
+	t.dstDir = serverDir(live)
+	return &t
+}
+
+// switchTraffic moves the primary allocation of the live server to the
+// standby server that has just been released to, stops the old live server
+// and restarts the new one on its new port.
+func switchTraffic(ctx context.Context, j *job) error {
+	p := j.Profile
+
+	_, span := j.startPhase(ctx, "switch")
+	err := func() error {
+		err := swapAllocations(p.live, p.Destination)
+		if err != nil {
+			return fmt.Errorf("swapping allocations: %w", err)
+		}
+
+		err = setLiveServer(p, p.Destination)
+		if err != nil {
+			j.logf("Error saving live server: %s", err)
+		}
+
+		err = j.panel().power(p.live, "stop")
+		if err != nil {
+			return fmt.Errorf("stopping old live server: %w", err)
+		}
+
+		err = j.panel().waitForState(p.live, "offline", stopTimeout)
+		if err != nil {
+			return fmt.Errorf("stopping old live server: %w", err)
+		}
+
+		err = j.panel().power(p.Destination, "restart")
+		if err != nil {
+			return fmt.Errorf("restarting new live server: %w", err)
+		}
+
+		return j.panel().waitForState(p.Destination, "running", p.SmokeCheck.Timeout)
+	}()
+	endSpan(span, err)
+
+	return err
+}
+
+// serverBuild is the part of an application API server needed to change its
+// allocations.
+type serverBuild struct {
+	ID            int            `json:"id"`
+	Node          int            `json:"node"`
+	Allocation    int            `json:"allocation"`
+	Limits        map[string]any `json:"limits"`
+	FeatureLimits map[string]any `json:"feature_limits"`
+}
+
+func (c *panelClient) serverBuild(server string) (*serverBuild, error) {
+	id, err := internalID(server)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Attributes serverBuild `json:"attributes"`
+	}
+	err = c.do(http.MethodGet, fmt.Sprintf("/api/application/servers/%d", id), nil, &resp)
+	return &resp.Attributes, err
+}
+
+// internalID returns the ID the application API knows server by.
+func internalID(server string) (int, error) {
+	var resp struct {
+		Attributes struct {
+			InternalID int `json:"internal_id"`
+		} `json:"attributes"`
+	}
+	err := panel.do(http.MethodGet, fmt.Sprintf("/api/client/servers/%s", server), nil, &resp)
+	return resp.Attributes.InternalID, err
+}
+
+// moveAllocation makes add the primary allocation of s in place of remove.
+func (c *panelClient) moveAllocation(s *serverBuild, add int, remove int) error {
+	body := map[string]any{}
+	for k, v := range s.Limits {
+		body[k] = v
+	}
+	body["feature_limits"] = s.FeatureLimits
+	body["allocation"] = add
+	body["add_allocations"] = []int{add}
+	body["remove_allocations"] = []int{remove}
+
+	err := c.do(http.MethodPatch, fmt.Sprintf("/api/application/servers/%d/build", s.ID), body, nil)
+	if err == nil {
+		s.Allocation = add
+	}
+	return err
+}
+
+// freeAllocation returns an allocation on node that no server uses.
+func (c *panelClient) freeAllocation(node int) (int, error) {
+	for page := 1; ; page++ {
+		var resp struct {
+			Data []struct {
+				Attributes struct {
+					ID       int  `json:"id"`
+					Assigned bool `json:"assigned"`
+				} `json:"attributes"`
+			} `json:"data"`
+			Meta struct {
+				Pagination struct {
+					TotalPages int `json:"total_pages"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+		err := c.do(http.MethodGet, fmt.Sprintf("/api/application/nodes/%d/allocations?per_page=100&page=%d", node, page), nil, &resp)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, a := range resp.Data {
+			if !a.Attributes.Assigned {
+				return a.Attributes.ID, nil
+			}
+		}
+
+		if page >= resp.Meta.Pagination.TotalPages {
+			return 0, fmt.Errorf("no free allocation on node %d", node)
+		}
+	}
+}
+
+// swapAllocations exchanges the primary allocations of live and standby.
+// An allocation can only belong to one server, so the standby is parked on a
+// free allocation of its node while the live server moves over.
+func swapAllocations(live string, standby string) error {
+	a, err := panelApp.serverBuild(live)
+	if err != nil {
+		return err
+	}
+
+	b, err := panelApp.serverBuild(standby)
+	if err != nil {
+		return err
+	}
+
+	if a.Node != b.Node {
+		return fmt.Errorf("servers are on different nodes")
+	}
+
+	x, y := a.Allocation, b.Allocation
+	z, err := panelApp.freeAllocation(b.Node)
+	if err != nil {
+		return err
+	}
+
+	err = panelApp.moveAllocation(b, z, y)
+	if err != nil {
+		return err
+	}
+
+	err = panelApp.moveAllocation(a, y, x)
+	if err != nil {
+		return err
+	}
+
+	return panelApp.moveAllocation(b, x, z)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profileEntry is how a profile created from chat is written to the config
+// file.
+type profileEntry struct {
+	Name        string    `yaml:"name"`
+	Source      string    `yaml:"source"`
+	Destination string    `yaml:"destination"`
+	Preset      string    `yaml:"preset,omitempty"`
+	Restart     bool      `yaml:"restart,omitempty"`
+	SmokeCheck  *struct{} `yaml:"smoke_check,omitempty"`
+	Rollback    bool      `yaml:"rollback,omitempty"`
+}
+
+// entry returns how p is written to the config file. Only the options that
+// can be chosen from chat are kept, and the smoke check uses the defaults.
+func (p *profile) entry() *profileEntry {
+	e := &profileEntry{
+		Name:        p.Name,
+		Source:      p.Source,
+		Destination: p.Destination,
+		Preset:      p.Preset,
+		Restart:     p.Restart,
+		Rollback:    p.Rollback,
+	}
+	if p.SmokeCheck != nil {
+		e.SmokeCheck = &struct{}{}
+	}
+	return e
+}
+
+// isEmptyDir reports whether dir has no entries. A missing directory is
+// empty.
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// newBootstrapProfile sets up the profile copying template to destination.
+func newBootstrapProfile(name string, template string, destination string, preset string) (*profile, error) {
+	if findProfile(name) != nil {
+		return nil, fmt.Errorf("profile `%s` already exists", name)
+	}
+
+	p := &profile{Name: name, Source: template, Destination: destination, Preset: preset}
+	err := p.init(defaultRules)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.mount()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(p.srcDir); err != nil {
+		return nil, fmt.Errorf("template server: %w", err)
+	}
+	return p, nil
+}
+
+// configFileMu serializes the edits of the config file by saveProfile.
+var configFileMu sync.Mutex
+
+// saveProfile adds p to the config file, so it is still there after a
+// restart.
+func saveProfile(p *profile) error {
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+
+	path, explicit := configPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return errors.New("profiles are configured from the environment, so the new profile is lost on restart")
+	} else if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	} else if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a mapping", path)
+	}
+
+	root := doc.Content[0]
+	var list, next *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "profiles" {
+			list = root.Content[i+1]
+			if i+2 < len(root.Content) {
+				next = root.Content[i+2]
+			}
+		}
+	}
+	if list == nil || list.Kind != yaml.SequenceNode || len(list.Content) == 0 || list.Style&yaml.FlowStyle != 0 {
+		return fmt.Errorf("%s has no block list of profiles to add to", path)
+	}
+
+	entry, err := p.marshal()
+	if err != nil {
+		return err
+	}
+
+	// The entry is inserted as text after the last profile, so that the
+	// rest of the file keeps its comments and layout.
+	lines := strings.SplitAfter(string(data), "\n")
+	at := len(lines)
+	if next != nil {
+		at = next.Line - 1
+		if next.HeadComment != "" {
+			at -= strings.Count(next.HeadComment, "\n") + 1
+		}
+	}
+	for at > 0 && strings.TrimSpace(lines[at-1]) == "" {
+		at--
+	}
+	if at > 0 && !strings.HasSuffix(lines[at-1], "\n") {
+		lines[at-1] += "\n"
+	}
+
+	indent := strings.Repeat(" ", max(list.Content[0].Column-3, 0))
+	var b strings.Builder
+	first := true
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(string(entry), "\n"), "\n") {
+		switch {
+		case first && strings.HasPrefix(line, "#"):
+			// Comments above the profile stay above the list item.
+			b.WriteString(indent + line)
+		case first:
+			b.WriteString(indent + "- " + line)
+			first = false
+		default:
+			b.WriteString(indent + "  " + line)
+		}
+	}
+	b.WriteString("\n")
+	lines = append(lines[:at], append([]string{b.String()}, lines[at:]...)...)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	err = os.WriteFile(tmp, []byte(strings.Join(lines, "")), info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func handleBootstrap(ctx CommandContext) {
+	name := ctx.Option("name")
+	p, err := newBootstrapProfile(name, ctx.Option("template"), ctx.Option("destination"), ctx.Option("preset"))
+	if err == nil {
+		var empty bool
+		empty, err = isEmptyDir(p.dstDir)
+		if err == nil && !empty {
+			err = fmt.Errorf("destination %s is not empty", p.dstDir)
+		} else if err == nil {
+			err = os.MkdirAll(p.dstDir, 0755)
+		}
+	}
+	if err != nil {
+		log.Printf("Error bootstrapping %s: %s", name, err)
+		replyError(ctx, "Cannot bootstrap `%s`: %s", name, err)
+		return
+	}
+
+	// The destination is new, so there is nothing to delete.
+	bootstrap(ctx, nil, p, false)
+}
+
+// bootstrap copies the template server of p into its destination and
+// registers p once done. The progress is posted in reply if given, or in a
+// new reply to ctx.
+func bootstrap(ctx CommandContext, reply Reply, p *profile, delete bool) {
+	j := newJob(p, delete)
+	j.attribute(ctx.User())
+	j.Note = fmt.Sprintf("Bootstrap of %s from template %s", p.Destination, p.Source)
+	targets := append([]Notifier{ctx.Notifier()}, notifiers...)
+	j.notifiers = targets
+	ch := make(chan bool)
+	go copy(j, ch)
+
+	started := startedNotification(j)
+	started.Title = fmt.Sprintf("Bootstrapping %s...", p.Name)
+	var err error
+	if reply == nil {
+		reply, err = ctx.Reply(started)
+	} else {
+		err = reply.Edit(started)
+	}
+	if err != nil {
+		j.logf("Error replying to command: %s", err)
+		reply = nil
+	} else {
+		j.addSink(newReplySink(reply, started))
+		j.link(reply.Links())
+	}
+	notifyAll(notifiers, started)
+
+	success := <-ch
+	n := resultNotification(j, success)
+	if success {
+		n.Title = fmt.Sprintf("Bootstrapped %s", p.Name)
+		registered := fmt.Sprintf("Registered profile `%s`.", p.Name)
+		if err := addProfile(p); err != nil {
+			j.logf("Error registering profile %s: %s", p.Name, err)
+			registered = fmt.Sprintf(":x: Cannot register the profile: %s", err)
+		} else if err := saveProfile(p); err != nil {
+			j.logf("Error saving profile %s: %s", p.Name, err)
+			registered = fmt.Sprintf(":warning: Profile `%s` is available until the bot restarts: %s", p.Name, err)
+		}
+		n.Fields = append(n.Fields, NotificationField{Name: "Profile", Value: registered})
+	}
+	notifyAll(targets, n)
+
+	started.Description = ""
+	if success {
+		started.Color = 0x00ff00
+		started.Title = fmt.Sprintf("Bootstrapped %s", p.Name)
+	} else {
+		started.Color = 0xff0000
+		started.Title = fmt.Sprintf("Failed to bootstrap %s", p.Name)
+	}
+	if reply != nil {
+		err = reply.Edit(started)
+		if err != nil {
+			j.logf("Error editing reply: %s", err)
+		}
+	}
+}
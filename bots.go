@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// bots are the Discord bots run next to the one of DISCORD_BOT_TOKEN.
+var bots []*bot
+
+// bot is an additional Discord bot identity run by the same process, such as
+// a staging bot next to the production one. Each bot registers its commands
+// in its own guild with its own dispatcher and only sees its own profiles.
+type bot struct {
+	Name string `yaml:"name"`
+
+	// TokenEnv names the environment variable holding the token of the bot,
+	// which may also be read from a file or Vault like DISCORD_BOT_TOKEN.
+	TokenEnv string `yaml:"token_env"`
+
+	// GuildID is the guild the commands of the bot are registered in.
+	// Without it, the first guild the bot is a member of is used.
+	GuildID string `yaml:"guild_id"`
+
+	// Profiles are the names of the profiles the bot can release. It can
+	// release all of them if empty.
+	Profiles []string `yaml:"profiles"`
+}
+
+func (b *bot) init(names map[string]bool) error {
+	if b.Name == "" {
+		return fmt.Errorf("no name")
+	} else if b.TokenEnv == "" {
+		return fmt.Errorf("token_env is required")
+	}
+
+	for _, name := range b.Profiles {
+		if !names[name] {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+	}
+
+	return nil
+}
+
+// allows reports whether the bot can see p.
+func (b *bot) allows(p *profile) bool {
+	return len(b.Profiles) == 0 || slices.Contains(b.Profiles, p.Name)
+}
+
+// frontend connects the bot to Discord with a dispatcher of its own, so its
+// interactions are scoped to its profiles.
+func (b *bot) frontend() (*discordFrontend, error) {
+	token := envSecret(b.TokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("%s is not set", b.TokenEnv)
+	}
+
+	intents, err := parseIntents(os.Getenv("DISCORD_INTENTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newDiscordFrontend(token, discordGateway{Intents: intents, GuildID: b.GuildID})
+	if err != nil {
+		return nil, err
+	}
+
+	d.interactions = newDispatcher(recoverPanics, logInteractions, authorizeUsers, b.scope, checkPermissions)
+	d.interactions.register(commands, components)
+	d.allows = b.allows
+	return d, nil
+}
+
+// scope is the middleware limiting the interactions with the bot to its
+// profiles.
+func (b *bot) scope(route string, next HandlerFunc) HandlerFunc {
+	return func(ctx CommandContext) {
+		next(&botContext{CommandContext: ctx, bot: b})
+	}
+}
+
+// botContext is an interaction with an additional bot.
+type botContext struct {
+	CommandContext
+	bot *bot
+}
+
+func (c *botContext) Profiles() []*profile {
+	visible := []*profile{}
+	for _, p := range allProfiles() {
+		if c.bot.allows(p) {
+			visible = append(visible, p)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sizeBudgetFactor is how many times its expected size a source may be
+// before the release is reported as over budget.
+const sizeBudgetFactor = 3
+
+// minBudgetHistory is the number of successful releases of a profile needed
+// to work out its usual size when no size budget is given.
+const minBudgetHistory = 3
+
+// budget is what a release of a profile is expected to take. Releases going
+// far beyond it usually mean a misconfigured profile or a runaway directory
+// such as logs, so they are reported while the job is still running.
+type budget struct {
+	// Size is the expected size of the source, such as 20GiB. Without it,
+	// the median size of the recent releases of the profile is used.
+	Size string `yaml:"size"`
+
+	// Duration is how long the copy phase is expected to take at most.
+	Duration time.Duration `yaml:"duration"`
+
+	size int64
+}
+
+func (b *budget) init() error {
+	if b.Size != "" {
+		var err error
+		b.size, err = parseSize(b.Size)
+		if err != nil {
+			return fmt.Errorf("budget.size: %w", err)
+		}
+	}
+
+	if b.Duration < 0 {
+		return fmt.Errorf("budget.duration must not be negative")
+	}
+
+	return nil
+}
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+	"T":   1 << 40,
+	"TB":  1 << 40,
+	"TIB": 1 << 40,
+}
+
+// parseSize parses a size such as 512MiB or 20G. Units are powers of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in size %q", s)
+	}
+
+	return int64(n * float64(unit)), nil
+}
+
+// expectedSize returns the size budget of p, or the median size of its
+// recent successful releases if none is set. It returns 0 if neither is
+// known.
+func (b *budget) expectedSize(p *profile) int64 {
+	if b.size > 0 {
+		return b.size
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		return 0
+	}
+
+	sizes := []int64{}
+	for i := len(records) - 1; i >= 0 && len(sizes) < 10; i-- {
+		r := records[i]
+		if r.Profile == p.Name && r.Success && r.TotalBytes > 0 {
+			sizes = append(sizes, r.TotalBytes)
+		}
+	}
+	if len(sizes) < minBudgetHistory {
+		return 0
+	}
+
+	sort.Slice(sizes, func(a, b int) bool {
+		return sizes[a] < sizes[b]
+	})
+	return sizes[len(sizes)/2]
+}
+
+// checkSizeBudget warns if the scan found far more data than the profile of
+// j usually releases.
+func (j *job) checkSizeBudget() {
+	b := j.Profile.Budget
+	if b == nil {
+		return
+	}
+
+	expected := b.expectedSize(j.Profile)
+	if expected == 0 || j.TotalBytes <= sizeBudgetFactor*expected {
+		return
+	}
+
+	j.overBudget(fmt.Sprintf("Source is %s, more than %d times the expected %s", formatBytes(j.TotalBytes), sizeBudgetFactor, formatBytes(expected)))
+}
+
+// watchCopyBudget warns once the copy phase of j runs longer than its
+// budget. The returned function stops watching.
+func (j *job) watchCopyBudget() func() {
+	b := j.Profile.Budget
+	if b == nil || b.Duration == 0 {
+		return func() {}
+	}
+
+	t := time.AfterFunc(b.Duration, func() {
+		j.overBudget(fmt.Sprintf("Copy has been running for more than %s\n%s", b.Duration, j.progress()))
+	})
+	return func() { t.Stop() }
+}
+
+// overBudget records msg as a warning and posts it right away rather than
+// with the result of the job.
+func (j *job) overBudget(msg string) {
+	j.warnf("Over budget: %s", strings.ReplaceAll(msg, "\n", "; "))
+
+	targets := j.notifiers
+	if targets == nil {
+		targets = notifiers
+	}
+	notifyAll(targets, &Notification{
+		Color:       0xffa500,
+		Title:       ":warning: Release is over budget",
+		Description: msg + "\nThe profile may be misconfigured or a directory such as logs may be growing out of control.",
+		Fields: []NotificationField{
+			{
+				Name:  "Job ID",
+				Value: fmt.Sprintf("`%s`", j.ID),
+			},
+			{
+				Name:  "Profile",
+				Value: fmt.Sprintf("`%s`", j.Profile.Name),
+			},
+		},
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// caseInsensitive reports whether the filesystem of dir treats names that
+// differ only by case as the same file, by creating a probe file in it.
+func caseInsensitive(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, ".releaser-case-probe-*")
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	upper := filepath.Join(dir, strings.ToUpper(filepath.Base(f.Name())))
+	_, err = os.Lstat(upper)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// caseCollision returns the name of an earlier entry of the same source
+// directory that name differs from only by case, remembering name in seen.
+func caseCollision(seen map[string]string, name string) (string, bool) {
+	key := strings.ToLower(name)
+	if other, ok := seen[key]; ok {
+		return other, true
+	}
+	seen[key] = name
+	return "", false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultChangelogs are looked for in the source if a profile does not
+// name its changelog.
+var defaultChangelogs = []string{"CHANGELOG.md", "RELEASE_NOTES.md"}
+
+// maxChangelogSize is the largest changelog that is attached to the
+// completion notification.
+const maxChangelogSize = 1 << 20
+
+// changelog is the changelog file found in the source of a release.
+type changelog struct {
+	Name string
+	Data []byte
+}
+
+// readChangelog loads the changelog of the profile from the source, if
+// there is one.
+func readChangelog(j *job, srcDir string) (*changelog, error) {
+	paths := defaultChangelogs
+	if j.Profile.Changelog != "" {
+		paths = []string{j.Profile.Changelog}
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(filepath.Join(srcDir, path))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(io.LimitReader(f, maxChangelogSize))
+		if err != nil {
+			return nil, err
+		}
+
+		return &changelog{Name: filepath.Base(path), Data: data}, nil
+	}
+
+	return nil, nil
+}
+
+// topSection returns the first section of a Markdown changelog: the first
+// heading and everything up to the next heading of the same or a higher
+// level. A leading "# Changelog" style title is skipped. Changelogs without
+// headings are returned as is.
+func (c *changelog) topSection() string {
+	lines := strings.Split(string(c.Data), "\n")
+
+	headings := []int{}
+	for i, line := range lines {
+		if headingLevel(line) > 0 {
+			headings = append(headings, i)
+		}
+	}
+	if len(headings) == 0 {
+		return strings.TrimSpace(string(c.Data))
+	}
+
+	if len(headings) > 1 && headingLevel(lines[headings[0]]) == 1 && headingLevel(lines[headings[1]]) > 1 {
+		headings = headings[1:]
+	}
+
+	start := headings[0]
+	level := headingLevel(lines[start])
+	for _, i := range headings[1:] {
+		if headingLevel(lines[i]) <= level {
+			return strings.TrimSpace(strings.Join(lines[start:i], "\n"))
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines[start:], "\n"))
+}
+
+// headingLevel returns the level of a Markdown ATX heading, or 0 if line is
+// not one.
+func headingLevel(line string) int {
+	n := len(line) - len(strings.TrimLeft(line, "#"))
+	if n == 0 || n > 6 || (len(line) > n && line[n] != ' ') {
+		return 0
+	}
+	return n
+}
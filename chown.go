@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// chownAuto takes the owner of the destination directory.
+const chownAuto = "auto"
+
+// parseOwner parses a chown option of the form uid:gid.
+func parseOwner(s string) (uid int, gid int, err error) {
+	u, g, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("chown: expected uid:gid or %s, got %q", chownAuto, s)
+	}
+
+	uid, err = strconv.Atoi(u)
+	if err == nil {
+		gid, err = strconv.Atoi(g)
+	}
+	if err != nil || uid < 0 || gid < 0 {
+		return 0, 0, fmt.Errorf("chown: invalid uid:gid %q", s)
+	}
+
+	return uid, gid, nil
+}
+
+// owner returns who copied files are owned by, or ok false if ownership is
+// left alone.
+func (p *profile) owner() (uid int, gid int, ok bool, err error) {
+	switch p.Chown {
+	case "":
+		return 0, 0, false, nil
+	case chownAuto:
+		info, err := os.Stat(p.dstDir)
+		if err != nil {
+			return 0, 0, false, err
+		}
+
+		uid, gid, ok := fileOwner(info)
+		if !ok {
+			return 0, 0, false, fmt.Errorf("cannot detect the owner of %s", p.dstDir)
+		}
+		return uid, gid, true, nil
+	default:
+		uid, gid, err := parseOwner(p.Chown)
+		return uid, gid, err == nil, err
+	}
+}
+
+// chown hands path over to the owner of the job's destination, if the
+// profile asks for it.
+func (j *job) chown(path string) error {
+	if !j.chownFiles {
+		return nil
+	}
+	return os.Lchown(path, j.uid, j.gid)
+}
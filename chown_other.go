@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "io/fs"
+
+func fileOwner(info fs.FileInfo) (uid int, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,789 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var profileOption = &CommandOption{
+	Name:        "profile",
+	Description: "Profile to use, if more than one is configured",
+	Type:        OptionString,
+}
+
+// dryRunOption only reports what a release would do. It is on by default for
+// sandbox profiles.
+var dryRunOption = &CommandOption{
+	Name:        "dry-run",
+	Description: "Only report what would be copied and deleted (default for sandbox profiles)",
+	Type:        OptionBool,
+}
+
+// components are the buttons attached to notifications.
+var components = []*Component{
+	{Name: "cancel", Handler: handleCancelButton},
+	{Name: "pause", Handler: handlePauseButton},
+	{Name: "resume", Handler: handleResumeButton},
+	{Name: "confirm-wipe", Handler: handleConfirmWipe},
+	{Name: "cancel-wipe", Handler: handleCancelWipe},
+	{Name: "profile-wizard", Handler: handleProfileWizard},
+	{Name: "profile-preset", Handler: handleProfilePreset},
+	{Name: "profile-power", Handler: handleProfilePower},
+	{Name: "profile-create", Handler: handleProfileCreateButton},
+	{Name: "profile-cancel", Handler: handleProfileCancel},
+}
+
+var commands = []*Command{
+	{
+		Name:        "copy",
+		Description: "Copy server files from one server to another",
+		Options: []*CommandOption{
+			profileOption,
+			{
+				Name:        "force",
+				Description: "Copy even if the destination server appears to be running",
+				Type:        OptionBool,
+			},
+			{
+				Name:        "allow-mass-delete",
+				Description: "Delete destination files even beyond the profile's delete cap",
+				Type:        OptionBool,
+			},
+			{
+				Name:        "allow-empty-source",
+				Description: "Copy even if the source is empty or missing marker files",
+				Type:        OptionBool,
+			},
+			{
+				Name:        "build",
+				Description: "Build to release, for profiles released from an artifact",
+				Type:        OptionString,
+			},
+			{
+				Name:        "sha256",
+				Description: "Expected SHA-256 checksum of the artifact",
+				Type:        OptionString,
+			},
+			{
+				Name:        "note",
+				Description: "Note recorded with the job, e.g. what the release fixes",
+				Type:        OptionString,
+			},
+			dryRunOption,
+			wipeOption,
+		},
+		Handler: handleCopy,
+	},
+	{
+		Name:        "release-all",
+		Description: "Copy every profile in a group at once",
+		Options: []*CommandOption{
+			{
+				Name:        "group",
+				Description: "Group of profiles to copy",
+				Type:        OptionString,
+				Required:    true,
+			},
+			{
+				Name:        "force",
+				Description: "Copy even if destination servers appear to be running",
+				Type:        OptionBool,
+			},
+			dryRunOption,
+			wipeOption,
+		},
+		Handler: handleReleaseAll,
+	},
+	{
+		Name:        "bootstrap",
+		Description: "Set up a new server from a template server and add it as a profile",
+		Options: []*CommandOption{
+			{
+				Name:        "name",
+				Description: "Name of the new profile",
+				Type:        OptionString,
+				Required:    true,
+			},
+			{
+				Name:        "template",
+				Description: "UUID of the template server to copy from",
+				Type:        OptionString,
+				Required:    true,
+			},
+			{
+				Name:        "destination",
+				Description: "UUID of the new server, which must be empty or not exist yet",
+				Type:        OptionString,
+				Required:    true,
+			},
+			{
+				Name:        "preset",
+				Description: "Preset of the new profile",
+				Type:        OptionString,
+			},
+		},
+		Handler: handleBootstrap,
+	},
+	{
+		Name:        "provision",
+		Description: "Create a new server on the panel like a template server and copy the template into it",
+		Options: []*CommandOption{
+			{
+				Name:        "name",
+				Description: "Name of the new server and profile",
+				Type:        OptionString,
+				Required:    true,
+			},
+			{
+				Name:        "template",
+				Description: "UUID of the template server to copy the egg, limits and files from",
+				Type:        OptionString,
+				Required:    true,
+			},
+			{
+				Name:        "preset",
+				Description: "Preset of the new profile",
+				Type:        OptionString,
+			},
+		},
+		Handler: handleProvision,
+	},
+	{
+		Name:        "pause",
+		Description: "Pause reading and writing files of a running job",
+		Options:     []*CommandOption{jobOption},
+		Handler:     handlePause,
+	},
+	{
+		Name:        "resume",
+		Description: "Resume a paused job",
+		Options:     []*CommandOption{jobOption},
+		Handler:     handleResume,
+	},
+	{
+		Name:        "logs",
+		Description: "Attach the log of a job",
+		Options: []*CommandOption{
+			{
+				Name:        "job",
+				Description: "ID of the job",
+				Type:        OptionString,
+				Required:    true,
+			},
+		},
+		Handler: handleLogs,
+	},
+	{
+		Name:        "info",
+		Description: "Show the configuration and effective rules of a profile",
+		Options:     []*CommandOption{profileOption},
+		Handler:     handleInfo,
+	},
+	{
+		Name:        "show-keep-files",
+		Description: "Show files that will not be overwritten or deleted",
+		Options:     []*CommandOption{profileOption},
+		Handler:     handleShowKeepFiles,
+	},
+	{
+		Name:        "estimate",
+		Description: "Estimate how much a copy would transfer and how long it would take",
+		Options:     []*CommandOption{profileOption},
+		Handler:     handleEstimate,
+	},
+	{
+		Name:        "verify-release",
+		Description: "Check that the destination matches the source without changing anything",
+		Options:     []*CommandOption{profileOption},
+		Handler:     handleVerifyRelease,
+	},
+	{
+		Name:        "du",
+		Description: "Show the storage used by a profile and the free space left",
+		Options:     []*CommandOption{profileOption},
+		Handler:     handleDu,
+	},
+	{
+		Name:        "stats",
+		Description: "Show copy speed statistics of past jobs",
+		Handler:     handleStats,
+	},
+	{
+		Name:        "profile",
+		Description: "Manage profiles",
+		Subcommands: []*Command{
+			{
+				Name:        "create",
+				Description: "Set up a new profile step by step",
+				Handler:     handleProfileCreate,
+			},
+			{
+				Name:        "export",
+				Description: "Attach a profile as YAML, to share or back it up",
+				Options:     []*CommandOption{profileOption},
+				Handler:     handleProfileExport,
+			},
+			{
+				Name:        "import",
+				Description: "Add a profile from an exported YAML file",
+				Options: []*CommandOption{
+					{
+						Name:        "file",
+						Description: "YAML file of the profile",
+						Type:        OptionAttachment,
+						Required:    true,
+					},
+					{
+						Name:        "name",
+						Description: "Name to import the profile as, if not the one in the file",
+						Type:        OptionString,
+					},
+				},
+				Handler: handleProfileImport,
+			},
+		},
+	},
+	{
+		Name:        "link",
+		Description: "Link your panel account, so releases run as your panel user",
+		Options: []*CommandOption{
+			{
+				Name:        "remove",
+				Description: "Unlink your panel account instead",
+				Type:        OptionBool,
+			},
+		},
+		Handler: handleLink,
+	},
+	{
+		Name:        "help",
+		Description: "Describe the commands, one command, or the error codes",
+		Options: []*CommandOption{
+			{
+				Name:        "topic",
+				Description: "A command, \"errors\", or an error code",
+				Type:        OptionString,
+			},
+		},
+		Handler: handleHelp,
+	},
+}
+
+// replyError replies to ctx with a failure message.
+func replyError(ctx CommandContext, format string, v ...any) {
+	_, err := ctx.Reply(&Notification{
+		Color:       0xff0000,
+		Description: ":x: " + fmt.Sprintf(format, v...),
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+// scopedContext is implemented by interactions that can only see some of
+// the profiles, such as those with an additional bot.
+type scopedContext interface {
+	Profiles() []*profile
+}
+
+// contextProfiles returns the profiles visible to ctx.
+func contextProfiles(ctx CommandContext) []*profile {
+	if s, ok := ctx.(scopedContext); ok {
+		return s.Profiles()
+	}
+	return allProfiles()
+}
+
+// canSee reports whether p, or a blue/green target of it, is visible to ctx.
+func canSee(ctx CommandContext, p *profile) bool {
+	for _, v := range contextProfiles(ctx) {
+		if v.Name == p.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectProfile returns the profile named by the profile option, which may
+// be omitted if there is only one. It replies with an error and returns nil
+// if there is no such profile.
+func selectProfile(ctx CommandContext) *profile {
+	visible := contextProfiles(ctx)
+	name := ctx.Option("profile")
+	if name == "" {
+		if len(visible) == 1 {
+			return visible[0]
+		}
+
+		replyError(ctx, "Please choose a profile: %s", profileNames(visible))
+		return nil
+	}
+
+	for _, p := range visible {
+		if p.Name == name {
+			return p
+		}
+	}
+
+	replyError(ctx, "Unknown profile `%s`! Available profiles: %s", name, profileNames(visible))
+	return nil
+}
+
+func profileNames(profiles []*profile) string {
+	names := []string{}
+	for _, p := range profiles {
+		names = append(names, fmt.Sprintf("`%s`", p.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+func handleCopy(ctx CommandContext) {
+	p := selectProfile(ctx)
+	if p == nil {
+		return
+	}
+	p = p.target()
+	dryRun := p.dryRun(ctx.Option("dry-run"))
+	if !dryRun && !authorizeRelease(ctx, p) {
+		return
+	}
+
+	if reason := detectRunningServer(p.dstDir); reason != "" && !boolOption(ctx, "force") && !dryRun {
+		_, err := ctx.Reply(&Notification{
+			Color:       0xff0000,
+			Title:       "Destination server appears to be running",
+			Description: fmt.Sprintf(":x: %s.\nStop the server before copying, or use the `force` option to copy anyway.", reason),
+		})
+		if err != nil {
+			log.Printf("Error replying to command: %s", err)
+		}
+		return
+	}
+
+	if !dryRun && p.keepsNothing() && !boolOption(ctx, wipeOption.Name) {
+		confirmWipe(ctx, "copy", []*profile{p}, handleCopy)
+		return
+	}
+
+	j := newJob(p, true)
+	j.attribute(ctx.User())
+	j.DryRun = dryRun
+	j.AllowMassDelete = boolOption(ctx, "allow-mass-delete")
+	j.AllowEmptySource = boolOption(ctx, "allow-empty-source")
+	j.Build = ctx.Option("build")
+	j.SHA256 = ctx.Option("sha256")
+	j.Note = ctx.Option("note")
+	targets := append([]Notifier{ctx.Notifier()}, notifiers...)
+	j.notifiers = targets
+	ch := make(chan bool)
+	go copy(j, ch)
+
+	started := startedNotification(j)
+	started.Actions = []NotificationAction{
+		{Label: "Pause", ID: "pause:" + j.ID},
+		{Label: "Cancel", ID: "cancel:" + j.ID},
+	}
+	reply, err := ctx.Reply(started)
+	if err != nil {
+		j.logf("Error replying to command: %s", err)
+	} else {
+		j.addSink(newReplySink(reply, started))
+		j.link(reply.Links())
+	}
+	notifyAll(notifiers, started)
+
+	success := <-ch
+	started.Description = ""
+	started.Actions = nil
+	switch {
+	case success && j.DryRun:
+		started.Color = 0x00ff00
+		started.Title = "Finished dry run"
+	case success:
+		started.Color = 0x00ff00
+		started.Title = "Copied server files"
+	case j.DryRun:
+		started.Color = 0xff0000
+		started.Title = "Dry run failed"
+	default:
+		started.Color = 0xff0000
+		started.Title = "Failed to copy server files"
+	}
+	notifyAll(targets, resultNotification(j, success))
+
+	if reply != nil {
+		err = reply.Edit(started)
+		if err != nil {
+			j.logf("Error editing reply: %s", err)
+		}
+	}
+}
+
+// handleCancelButton cancels the job with the given ID.
+func handleCancelButton(ctx CommandContext, id string) {
+	j := findJob(id)
+	if j == nil {
+		replyError(ctx, "Job `%s` not found!", id)
+		return
+	}
+
+	j.mu.Lock()
+	done := j.Done
+	j.mu.Unlock()
+	if done {
+		replyError(ctx, "Job `%s` has already finished!", id)
+		return
+	}
+
+	j.Cancel()
+	_, err := ctx.Reply(&Notification{
+		Color:       0xffff00,
+		Description: fmt.Sprintf(":hourglass: Canceling job `%s`...", id),
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+// startedNotification announces that j has started.
+func startedNotification(j *job) *Notification {
+	p := j.Profile
+	n := &Notification{
+		Color:       0xffff00,
+		Title:       "Copying server files...",
+		Description: ":warning: Do not add any modifications to the server files while copying!",
+		Fields: []NotificationField{
+			{
+				Name:  "Job ID",
+				Value: fmt.Sprintf("`%s`", j.ID),
+			},
+			{
+				Name:  "Profile",
+				Value: fmt.Sprintf("`%s`", p.Name),
+			},
+			{
+				Name:  "Source Server",
+				Value: fmt.Sprintf("`%s`", p.Source),
+			},
+			{
+				Name:  "Destination Server",
+				Value: fmt.Sprintf("`%s`", p.Destination),
+			},
+			{
+				Name:  "Keep Files",
+				Value: fmt.Sprintf("```\n%s\n```", strings.Join(p.keep, "\n")),
+			},
+		},
+	}
+	if j.DryRun {
+		n.Title = "Dry run of copying server files..."
+		n.Description = ":mag: Nothing will be copied or deleted."
+	}
+	if j.Note != "" {
+		n.Fields = append(n.Fields, noteField(j.Note))
+	}
+	n.Fields = append(n.Fields, ruleFields(p)...)
+	if j.Release != nil {
+		n.Fields = append(n.Fields, j.Release.fields()...)
+	}
+	return n
+}
+
+// resultNotification reports how j ended.
+func resultNotification(j *job, success bool) *Notification {
+	if !success {
+		code := errorCodeOf(j.Err)
+		failed := &Notification{
+			Color:       0xff0000,
+			Description: fmt.Sprintf(":x: Copying has failed! (job `%s`)\n```\n%s\n```", j.ID, firstLine(j.Err)),
+			Fields: []NotificationField{{
+				Name:  fmt.Sprintf("Error `%s`", code.Code),
+				Value: fmt.Sprintf("%s\n%s", code.Summary, code.Remedy),
+			}},
+		}
+		if j.RolledBack {
+			failed.Fields = append(failed.Fields, NotificationField{
+				Name:  "Rollback",
+				Value: ":leftwards_arrow_with_hook: The destination has been restored to its state before the release and started again.",
+			})
+		}
+		return failed
+	}
+
+	if j.DryRun && j.Plan != nil {
+		done := &Notification{
+			Color:       0x87ceeb,
+			Title:       fmt.Sprintf("Dry run of %s", j.Profile.Name),
+			Description: fmt.Sprintf(":mag: Dry run completed, nothing has been changed. (job `%s`)", j.ID),
+		}
+		done.Fields, done.Files = estimateFields(j.Profile, j.Plan)
+		if len(j.Warnings) > 0 {
+			done.Fields = append(done.Fields, warningsField(j.sortedWarnings()))
+		}
+		return done
+	}
+
+	summary := fmt.Sprintf("Copied %d files (%s) in %s.", j.Files, formatBytes(j.Bytes), time.Since(j.StartedAt).Round(time.Second))
+	if j.SkippedFiles > 0 {
+		summary += fmt.Sprintf(" Skipped %d files (%s) by size or age.", j.SkippedFiles, formatBytes(j.SkippedBytes))
+	}
+	if j.Inconsistent {
+		summary += fmt.Sprintf("\n:warning: %d source files changed during the copy, so the release is inconsistent.", len(j.SourceChanges))
+	}
+	done := &Notification{
+		Color:       0x00ff00,
+		Description: fmt.Sprintf(":white_check_mark: Copying has been completed! (job `%s`)\n%s", j.ID, summary),
+	}
+	if j.Started {
+		done.Fields = append(done.Fields, NotificationField{
+			Name:  "Destination Server",
+			Value: ":white_check_mark: Restarted and passed the smoke check",
+		})
+	}
+	if j.Query != nil {
+		done.Fields = append(done.Fields, queryField(j.Query))
+	}
+	if len(j.PanelChanges) > 0 {
+		done.Fields = append(done.Fields, NotificationField{
+			Name:  "Panel Settings",
+			Value: fmt.Sprintf("Synced from the source: %s", strings.Join(j.PanelChanges, ", ")),
+		})
+	}
+	if j.Jars != nil && !j.Jars.empty() {
+		done.Fields = append(done.Fields, NotificationField{
+			Name:  "Plugins",
+			Value: fmt.Sprintf("```diff\n%s\n```", j.Jars),
+		})
+	}
+	if j.Notes != nil {
+		done.Fields = append(done.Fields, notesField(j.Notes))
+		if len(j.Notes.Files) > 0 {
+			done.Files = append(done.Files, NotificationFile{Name: fmt.Sprintf("changes-%s.diff", j.ID), Data: []byte(strings.Join(j.Notes.Files, "\n") + "\n")})
+		}
+	}
+	if j.Largest != nil && len(j.Largest.Files) > 0 {
+		done.Fields = append(done.Fields, largestField(j.Largest))
+	}
+	if len(j.Renamed) > 0 {
+		done.Fields = append(done.Fields, renamedField(j.Renamed))
+	}
+	if j.Note != "" {
+		done.Fields = append(done.Fields, noteField(j.Note))
+	}
+	if j.Release != nil {
+		done.Title = fmt.Sprintf("Released %s", j.Profile.Name)
+		done.Fields = append(done.Fields, j.Release.fields()...)
+	}
+	if j.Changelog != nil {
+		if j.Release == nil || j.Release.Changelog == "" {
+			if section := j.Changelog.topSection(); section != "" {
+				done.Fields = append(done.Fields, changelogField(section))
+			}
+		}
+		done.Files = append(done.Files, NotificationFile{Name: j.Changelog.Name, Data: j.Changelog.Data})
+	}
+	if len(j.Warnings) > 0 {
+		done.Fields = append(done.Fields, warningsField(j.sortedWarnings()))
+	}
+	return done
+}
+
+// maxLargestShown is the number of largest files and directories listed in
+// a notification. The release report lists more.
+const maxLargestShown = 5
+
+func largestField(l *largest) NotificationField {
+	var b strings.Builder
+	for _, e := range l.Files[:min(len(l.Files), maxLargestShown)] {
+		fmt.Fprintf(&b, "%10s  %s\n", formatBytes(e.Bytes), e.Path)
+	}
+	if len(l.Dirs) > 0 {
+		b.WriteString("\n")
+	}
+	for _, e := range l.Dirs[:min(len(l.Dirs), maxLargestShown)] {
+		fmt.Fprintf(&b, "%10s  %s/\n", formatBytes(e.Bytes), e.Path)
+	}
+
+	return NotificationField{
+		Name:  "Largest Copied",
+		Value: fmt.Sprintf("```\n%s```", b.String()),
+	}
+}
+
+// renamedField lists the files whose names were normalized, up to
+// maxWarnings of them.
+func renamedField(renamed []string) NotificationField {
+	shown := renamed[:min(len(renamed), maxWarnings)]
+	value := fmt.Sprintf("```\n%s\n```", strings.Join(shown, "\n"))
+	if len(renamed) > len(shown) {
+		value += fmt.Sprintf("\n...and %d more", len(renamed)-len(shown))
+	}
+
+	return NotificationField{
+		Name:  fmt.Sprintf("Renamed (%d)", len(renamed)),
+		Value: value,
+	}
+}
+
+func noteField(note string) NotificationField {
+	return NotificationField{Name: "Note", Value: note}
+}
+
+// firstLine returns the first line of err, leaving out stack traces.
+func firstLine(err error) string {
+	if err == nil {
+		return "unknown error"
+	}
+	line, _, _ := strings.Cut(err.Error(), "\n")
+	return line
+}
+
+// maxWarnings is the number of warnings listed in a notification.
+const maxWarnings = 10
+
+func warningsField(warnings []string) NotificationField {
+	shown := warnings
+	if len(shown) > maxWarnings {
+		shown = shown[:maxWarnings]
+	}
+
+	value := fmt.Sprintf("```\n%s\n```", strings.Join(shown, "\n"))
+	if len(warnings) > len(shown) {
+		value += fmt.Sprintf("\n...and %d more", len(warnings)-len(shown))
+	}
+
+	return NotificationField{
+		Name:  fmt.Sprintf(":warning: Warnings (%d)", len(warnings)),
+		Value: value,
+	}
+}
+
+// ruleFields lists the rules of p other than keep, if it has any.
+func ruleFields(p *profile) []NotificationField {
+	fields := []NotificationField{}
+	if len(p.keepIfExists) > 0 {
+		fields = append(fields, NotificationField{
+			Name:  "Kept If Existing",
+			Value: fmt.Sprintf("```\n%s\n```", strings.Join(p.keepIfExists, "\n")),
+		})
+	}
+	if len(p.seedOnce) > 0 {
+		fields = append(fields, NotificationField{
+			Name:  "Seeded Once",
+			Value: fmt.Sprintf("```\n%s\n```", strings.Join(p.seedOnce, "\n")),
+		})
+	}
+	if len(p.exclude) > 0 {
+		fields = append(fields, NotificationField{
+			Name:  "Excluded Files",
+			Value: fmt.Sprintf("```\n%s\n```", strings.Join(p.exclude, "\n")),
+		})
+	}
+	if len(p.merge) > 0 {
+		fields = append(fields, NotificationField{
+			Name:  "Merged Directories",
+			Value: fmt.Sprintf("```\n%s\n```", strings.Join(p.merge, "\n")),
+		})
+	}
+	return fields
+}
+
+func handleShowKeepFiles(ctx CommandContext) {
+	p := selectProfile(ctx)
+	if p == nil {
+		return
+	}
+
+	_, err := ctx.Reply(&Notification{
+		Color:       0x87ceeb,
+		Title:       "Keep Files",
+		Description: fmt.Sprintf("These files will not be overwritten or deleted:\n```%s```", strings.Join(p.keep, "\n")),
+		Fields:      ruleFields(p),
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+func handleInfo(ctx CommandContext) {
+	p := selectProfile(ctx)
+	if p == nil {
+		return
+	}
+
+	_, err := ctx.Reply(profileNotification(p))
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+// profileNotification describes the servers, rules and options of p.
+func profileNotification(p *profile) *Notification {
+	preset := "None"
+	if p.Preset != "" {
+		preset = fmt.Sprintf("`%s`", p.Preset)
+	}
+
+	keep := "None"
+	if len(p.keep) > 0 {
+		keep = fmt.Sprintf("```\n%s\n```", strings.Join(p.keep, "\n"))
+	}
+
+	n := &Notification{
+		Color: 0x87ceeb,
+		Title: fmt.Sprintf("Profile %s", p.Name),
+		Fields: []NotificationField{
+			{
+				Name:  "Source",
+				Value: fmt.Sprintf("`%s`", p.Source),
+			},
+			{
+				Name:  "Destination",
+				Value: fmt.Sprintf("`%s`", p.Destination),
+			},
+			{
+				Name:  "Preset",
+				Value: preset,
+			},
+			{
+				Name:  "Keep Files",
+				Value: keep,
+			},
+		},
+	}
+	n.Fields = append(n.Fields, ruleFields(p)...)
+
+	if p.Standby != "" {
+		n.Fields = append(n.Fields, NotificationField{
+			Name:  "Standby",
+			Value: fmt.Sprintf("`%s` (live: `%s`)", p.Standby, liveServer(p)),
+		})
+	}
+
+	options := []string{}
+	if p.Restart {
+		options = append(options, "restart")
+	}
+	if p.SmokeCheck != nil {
+		options = append(options, "smoke check")
+	}
+	if p.Rollback {
+		options = append(options, "rollback")
+	}
+	if p.Proxy != nil {
+		options = append(options, fmt.Sprintf("drain `%s` to `%s`", p.Proxy.Backend, p.Proxy.Fallback))
+	}
+	if len(p.DependsOn) > 0 {
+		options = append(options, fmt.Sprintf("depends on `%s`", strings.Join(p.DependsOn, "`, `")))
+	}
+	if len(options) > 0 {
+		n.Fields = append(n.Fields, NotificationField{
+			Name:  "Options",
+			Value: strings.Join(options, "\n"),
+		})
+	}
+
+	return n
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// concurrency limits how many operations of each kind a job runs at once.
+type concurrency struct {
+	Scan int
+	Copy int
+	Hash int
+}
+
+// backendConcurrency holds the defaults for each kind of filesystem. Network
+// filesystems benefit from more parallel directory reads to hide latency,
+// while FUSE mounted SFTP falls over with too many parallel requests.
+var backendConcurrency = map[string]concurrency{
+	"local": {Scan: 16, Copy: 8, Hash: 4},
+	"nfs":   {Scan: 32, Copy: 16, Hash: 2},
+	"sftp":  {Scan: 4, Copy: 4, Hash: 2},
+}
+
+// Overrides set through the environment; zero means the backend default.
+var (
+	scanConcurrency int
+	copyConcurrency int
+	hashConcurrency int
+)
+
+// scaled returns the limits for a filesystem reached through n connections,
+// each of which can serve as many requests as the filesystem alone.
+func (c concurrency) scaled(n int) concurrency {
+	return concurrency{Scan: c.Scan * n, Copy: c.Copy * n, Hash: c.Hash * n}
+}
+
+// jobConcurrency returns the limits for copying srcPath to dstPath.
+func jobConcurrency(srcPath, dstPath string) concurrency {
+	src := backendConcurrency[filesystemBackend(srcPath)].scaled(streams(srcPath))
+	dst := backendConcurrency[filesystemBackend(dstPath)].scaled(streams(dstPath))
+
+	c := concurrency{
+		Scan: src.Scan,
+		Copy: min(src.Copy, dst.Copy),
+		Hash: min(src.Hash, dst.Hash),
+	}
+	if scanConcurrency > 0 {
+		c.Scan = scanConcurrency
+	}
+	if copyConcurrency > 0 {
+		c.Copy = copyConcurrency
+	}
+	if hashConcurrency > 0 {
+		c.Hash = hashConcurrency
+	}
+
+	return c
+}
+
+// filesystemBackend classifies the filesystem path is stored on as "local",
+// "nfs" or "sftp" by looking up its mount in /proc/self/mounts.
+func filesystemBackend(path string) string {
+	switch fsType := mountType(path); {
+	case fsType == "fuse.sshfs":
+		return "sftp"
+	case strings.HasPrefix(fsType, "nfs"), fsType == "cifs", fsType == "smb3", fsType == "ceph", fsType == "fuse.glusterfs":
+		return "nfs"
+	default:
+		return "local"
+	}
+}
+
+func mountType(path string) string {
+	_, fsType := findMount(path)
+	return fsType
+}
+
+// findMount returns the mount point and filesystem type of the mount path is
+// stored on.
+func findMount(path string) (mountPoint string, fsType string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", ""
+	}
+
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		point := fields[1]
+		if abs != point && !strings.HasPrefix(abs, strings.TrimSuffix(point, "/")+"/") {
+			continue
+		}
+
+		if len(point) > len(mountPoint) {
+			mountPoint, fsType = point, fields[2]
+		}
+	}
+
+	return mountPoint, fsType
+}
+
+// pool runs functions on a bounded number of goroutines and remembers the
+// first error any of them returned.
+type pool struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	err error
+}
+
+func newPool(size int) *pool {
+	return &pool{sem: make(chan struct{}, max(size, 1))}
+}
+
+// Go runs fn once a slot is free. It does nothing once a previous function
+// has failed. A panic in fn fails the pool instead of the process.
+func (p *pool) Go(fn func() error) {
+	if p.Err() != nil {
+		return
+	}
+
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				p.fail(panicError(r))
+			}
+		}()
+
+		err := fn()
+		if err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+// fail records err unless a function already failed.
+func (p *pool) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *pool) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Wait waits for all functions to finish and returns the first error.
+func (p *pool) Wait() error {
+	p.wg.Wait()
+	return p.Err()
+}
@@ -0,0 +1,803 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is read if CONFIG_FILE is not set. Without it a single
+// profile is configured from the environment.
+const defaultConfigFile = "config.yml"
+
+type config struct {
+	Profiles []*profile `yaml:"profiles"`
+
+	// Defaults are rules every profile starts from, in addition to
+	// KEEP_FILES, which are added to Keep once loaded.
+	Defaults ruleSet `yaml:"defaults"`
+
+	// Groups name sets of profiles that are released together.
+	Groups map[string][]string `yaml:"groups"`
+
+	// API controls access to the HTTP API.
+	API *apiAuth `yaml:"api"`
+
+	// Nodes are the machines servers run on, if not all are local.
+	Nodes []*node `yaml:"nodes"`
+
+	// NodeLoad holds copies back while the node is busy.
+	NodeLoad *nodeLoad `yaml:"node_load"`
+
+	// Bandwidth limits how fast copies write by time of day.
+	Bandwidth *bandwidth `yaml:"bandwidth"`
+
+	// Background caps jobs started with the background priority.
+	Background *backgroundLimits `yaml:"background"`
+
+	// MaxCPU is how many cores hashing, verification and decompression may
+	// keep busy together. Unlike node_load.max_cpu it limits the bot itself
+	// rather than waiting for the node to calm down.
+	MaxCPU int `yaml:"max_cpu"`
+
+	// ImportHostSettings lets /profile import set git, artifact,
+	// source_snapshot and chown and use servers on remote nodes, which run
+	// commands or mount volumes on the machine of the bot.
+	ImportHostSettings bool `yaml:"import_host_settings"`
+
+	// PanelPermissions checks that users may write files on destination
+	// servers in the panel before they release to them.
+	PanelPermissions *panelPermissions `yaml:"panel_permissions"`
+
+	// Permissions decide who may use each command and release each
+	// profile, instead of ALLOWED_USERS.
+	Permissions *permissionMatrix `yaml:"permissions"`
+
+	// Bots are Discord bots run in addition to the one of
+	// DISCORD_BOT_TOKEN, e.g. for staging.
+	Bots []*bot `yaml:"bots"`
+}
+
+// ruleSet is a list of keep, exclude and merge rules.
+type ruleSet struct {
+	Keep         []string `yaml:"keep"`
+	KeepIfExists []string `yaml:"keep_if_exists"`
+	SeedOnce     []string `yaml:"seed_once"`
+	Exclude      []string `yaml:"exclude"`
+	Merge        []string `yaml:"merge"`
+}
+
+// profile is a pair of servers that can be copied from one to the other.
+type profile struct {
+	Name        string `yaml:"name"`
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+	Preset      string `yaml:"preset"`
+
+	// Source and Destination are server UUIDs below SERVER_BASE_DIR, or
+	// absolute paths. Paths outside of SERVER_BASE_DIR are refused unless
+	// AllowOutsideBaseDir is set.
+	AllowOutsideBaseDir bool `yaml:"allow_outside_base_dir"`
+
+	// Git releases a git repository instead of the Source server.
+	Git *gitSource `yaml:"git"`
+
+	// Artifact releases a downloaded archive instead of the Source server.
+	Artifact *artifactSource `yaml:"artifact"`
+
+	// Keep, Exclude and Merge extend the rules of the preset. Rules are
+	// paths relative to the server directory and may contain wildcards.
+	// Keep paths are never touched at the destination, excluded paths are
+	// neither copied nor deleted, and destination-only files in merge
+	// directories are preserved while the source still overwrites them.
+	Keep    []string `yaml:"keep"`
+	Exclude []string `yaml:"exclude"`
+	Merge   []string `yaml:"merge"`
+
+	// KeepIfExists paths are preserved at the destination if present and
+	// copied from the source otherwise. SeedOnce paths are only copied the
+	// first time and not recreated if they are removed later on.
+	KeepIfExists []string `yaml:"keep_if_exists"`
+	SeedOnce     []string `yaml:"seed_once"`
+
+	// SourceSnapshot reads the source from a filesystem snapshot.
+	SourceSnapshot *sourceSnapshot `yaml:"source_snapshot"`
+
+	// Quiesce pauses saving on the running source server while it is read.
+	Quiesce *quiesce `yaml:"quiesce"`
+
+	// Changelog is the path of the changelog in the source, which is
+	// CHANGELOG.md or RELEASE_NOTES.md if not set. Its top section is shown
+	// when the release completes.
+	Changelog string `yaml:"changelog"`
+
+	// Markers are files, which may contain wildcards, that must exist in
+	// the source for it to be released.
+	Markers []string `yaml:"markers"`
+
+	// MaxDelete and MaxDeletePercent cap how many destination files that
+	// are not in the source a release may delete. Zero means no limit.
+	MaxDelete        int `yaml:"max_delete"`
+	MaxDeletePercent int `yaml:"max_delete_percent"`
+
+	// WorldCheck parses copied region files and level.dat files for
+	// corruption after copying: "warn" lists them in the report and "fail"
+	// also stops the release before the destination is restarted.
+	WorldCheck string `yaml:"world_check"`
+
+	// WatchSource watches the source for files modified during the copy:
+	// "warn" lists them in the report and "abort" cancels the release.
+	WatchSource string `yaml:"watch_source"`
+
+	// Manifest hashes the source before and after the copy and marks the
+	// release as inconsistent in the report if anything changed. The
+	// manifest of each successful release is stored, so the next one lists
+	// the files and plugins changed since.
+	Manifest bool `yaml:"manifest"`
+
+	// JarSync matches plugin and mod jars by the name in their metadata,
+	// so that a renamed jar of a new version replaces the old one.
+	JarSync bool `yaml:"jar_sync"`
+
+	// DeleteAfter copies over the live destination first and only removes
+	// files that are not in the source once everything has been copied,
+	// like rsync --delete-after. This shortens the time the destination is
+	// incomplete, at the cost of mixing old and new files while copying.
+	DeleteAfter bool `yaml:"delete_after"`
+
+	// FollowSymlinks copies what symlinks in the source point to instead of
+	// failing on symlinked directories. Symlinks leading back to one of
+	// their parent directories are skipped, as they would never end.
+	FollowSymlinks bool `yaml:"follow_symlinks"`
+
+	// FileMode and DirMode force the permissions of copied files and
+	// directories, such as 0664 and 0775, whatever they are at the source.
+	// Umask clears permission bits of the source modes instead.
+	FileMode string `yaml:"file_mode"`
+	DirMode  string `yaml:"dir_mode"`
+	Umask    string `yaml:"umask"`
+
+	// NormalizeNames converts the names of copied files to the Unicode
+	// normalization form "nfc" or "nfd". Renamed files are listed in the
+	// report.
+	NormalizeNames string `yaml:"normalize_names"`
+
+	// Chown hands copied files to uid:gid, or to the owner of the
+	// destination directory if it is "auto".
+	Chown string `yaml:"chown"`
+
+	// PreserveXattrs copies POSIX ACLs and SELinux contexts along with
+	// files and directories.
+	PreserveXattrs bool `yaml:"preserve_xattrs"`
+
+	// Sandbox makes releases of the profile dry runs unless the dry-run
+	// option is turned off, and makes it available in the test guild.
+	Sandbox bool `yaml:"sandbox"`
+
+	// Restart restarts the destination through the panel after copying.
+	Restart    bool        `yaml:"restart"`
+	SmokeCheck *smokeCheck `yaml:"smoke_check"`
+
+	// RemindRestart is how long after a release without restart to remind
+	// the channel if the destination is offline or was not restarted since.
+	RemindRestart time.Duration `yaml:"remind_restart"`
+
+	// Query queries the destination once it was restarted, and shows what
+	// it reports in the completion notification.
+	Query *serverQuery `yaml:"query"`
+
+	// PanelSync copies the startup command and egg variables from the
+	// source to the destination server before it is restarted.
+	PanelSync *panelSync `yaml:"panel_sync"`
+
+	// Rollback restores a snapshot of the destination taken before the
+	// release if the smoke check fails.
+	Rollback bool `yaml:"rollback"`
+
+	// Standby enables blue/green releases: each release copies into
+	// whichever of Destination and Standby is not serving players, and the
+	// primary allocation is moved over once it passes the smoke check.
+	Standby string `yaml:"standby"`
+
+	// DependsOn names profiles that are released before this one when they
+	// are in the same group. If one of them fails, this one is skipped.
+	DependsOn []string `yaml:"depends_on"`
+
+	// Proxy drains players from the destination before copying.
+	Proxy *proxyConfig `yaml:"proxy"`
+
+	// Skip leaves files out of the release by size or age.
+	Skip []*skipRule `yaml:"skip"`
+
+	// Budget warns while a release runs if it is far larger or slower than
+	// expected.
+	Budget *budget `yaml:"budget"`
+
+	// Limits abort releases of sources with too many files, too deeply
+	// nested directories or too many bytes.
+	Limits *sourceLimits `yaml:"limits"`
+
+	srcDir       string
+	dstDir       string
+	keep         []string
+	keepIfExists []string
+	seedOnce     []string
+	exclude      []string
+	merge        []string
+	fileMode     fs.FileMode
+	dirMode      fs.FileMode
+	umask        fs.FileMode
+
+	// live is the server currently serving players when releasing a
+	// blue/green profile.
+	live string
+
+	// yaml is the profile as written in the config file or imported, if it
+	// was.
+	yaml *yaml.Node
+}
+
+// configPath returns the path of the config file, and whether it was given
+// with CONFIG_FILE.
+func configPath() (string, bool) {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path, true
+	}
+	return defaultConfigFile, false
+}
+
+func loadConfig() (*config, error) {
+	path, explicit := configPath()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		profiles, err := envProfiles()
+		if err != nil {
+			return nil, err
+		}
+		return &config{Profiles: profiles, Defaults: ruleSet{Keep: keepFiles}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	err = checkSchema(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	err = cfg.Defaults.check()
+	if err != nil {
+		return nil, fmt.Errorf("%s: defaults: %w", path, err)
+	}
+
+	// Profiles find their servers on the nodes.
+	nodeNames, servers := map[string]bool{}, map[string]string{}
+	for i, n := range cfg.Nodes {
+		err := n.init()
+		if err != nil {
+			return nil, fmt.Errorf("%s: nodes[%d]: %w", path, i, err)
+		} else if nodeNames[n.Name] {
+			return nil, fmt.Errorf("%s: nodes[%d]: duplicate name %q", path, i, n.Name)
+		}
+		nodeNames[n.Name] = true
+
+		for _, server := range n.Servers {
+			if other, ok := servers[server]; ok {
+				return nil, fmt.Errorf("%s: nodes[%d]: server %s is already on node %s", path, i, server, other)
+			}
+			servers[server] = n.Name
+		}
+	}
+	nodes = cfg.Nodes
+
+	cfg.Defaults.Keep = append(append([]string{}, keepFiles...), cfg.Defaults.Keep...)
+	defaults := cfg.Defaults
+
+	names := map[string]bool{}
+	for i, p := range cfg.Profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("%s: profiles[%d]: no name", path, i)
+		} else if names[p.Name] {
+			return nil, fmt.Errorf("%s: profiles[%d]: duplicate name %q", path, i, p.Name)
+		}
+		names[p.Name] = true
+
+		err := p.init(defaults)
+		if err != nil {
+			return nil, fmt.Errorf("%s: profiles[%d]: %w", path, i, err)
+		}
+	}
+
+	for i, p := range cfg.Profiles {
+		for _, dep := range p.DependsOn {
+			if !names[dep] {
+				return nil, fmt.Errorf("%s: profiles[%d]: depends_on: unknown profile %q", path, i, dep)
+			}
+		}
+	}
+
+	err = checkDependencies(cfg.Profiles)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, members := range cfg.Groups {
+		if len(members) == 0 {
+			return nil, fmt.Errorf("%s: groups.%s: no profiles", path, name)
+		}
+
+		for _, member := range members {
+			if !names[member] {
+				return nil, fmt.Errorf("%s: groups.%s: unknown profile %q", path, name, member)
+			}
+		}
+	}
+
+	if cfg.API != nil {
+		err = cfg.API.init()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	botNames := map[string]bool{}
+	for i, b := range cfg.Bots {
+		err := b.init(names)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bots[%d]: %w", path, i, err)
+		} else if botNames[b.Name] {
+			return nil, fmt.Errorf("%s: bots[%d]: duplicate name %q", path, i, b.Name)
+		}
+		botNames[b.Name] = true
+	}
+
+	if cfg.NodeLoad != nil {
+		err = cfg.NodeLoad.init()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if cfg.Bandwidth != nil {
+		err = cfg.Bandwidth.init()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if cfg.MaxCPU < 0 {
+		return nil, fmt.Errorf("%s: max_cpu must not be negative", path)
+	}
+
+	if cfg.Background != nil {
+		err = cfg.Background.init()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if cfg.PanelPermissions != nil {
+		err = cfg.PanelPermissions.init()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if cfg.Permissions != nil {
+		err = cfg.Permissions.init()
+		if err != nil {
+			return nil, fmt.Errorf("%s: permissions: %w", path, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// envProfiles configures a single profile named "default" from the
+// environment.
+func envProfiles() ([]*profile, error) {
+	p := &profile{
+		Name:        "default",
+		Source:      os.Getenv("SRC_SERVER_UUID"),
+		Destination: os.Getenv("DST_SERVER_UUID"),
+		Preset:      os.Getenv("PRESET"),
+	}
+
+	err := p.init(ruleSet{Keep: keepFiles})
+	if err != nil {
+		return nil, err
+	}
+
+	return []*profile{p}, nil
+}
+
+// init validates p and works out its effective rules from defaults, its
+// preset and its own rules, in that order.
+func (p *profile) init(defaults ruleSet) error {
+	if p.Git != nil && p.Artifact != nil {
+		return fmt.Errorf("git and artifact cannot both be set")
+	}
+
+	if p.Git != nil {
+		if p.Source != "" {
+			return fmt.Errorf("source and git cannot both be set")
+		}
+
+		err := p.Git.init()
+		if err != nil {
+			return err
+		}
+	} else if p.Artifact != nil {
+		if p.Source != "" {
+			return fmt.Errorf("source and artifact cannot both be set")
+		}
+
+		err := p.Artifact.init()
+		if err != nil {
+			return err
+		}
+	} else if p.Source == "" {
+		return fmt.Errorf("no source server UUID found")
+	}
+
+	if p.Destination == "" {
+		return fmt.Errorf("no destination server UUID found")
+	}
+
+	rules := ruleSet{Keep: p.Keep, KeepIfExists: p.KeepIfExists, SeedOnce: p.SeedOnce, Exclude: p.Exclude, Merge: p.Merge}
+	err := rules.check()
+	if err != nil {
+		return err
+	}
+	err = checkGlobs("markers", p.Markers)
+	if err != nil {
+		return err
+	}
+
+	servers := []string{}
+	switch {
+	case p.Git != nil:
+		p.Source = p.Git.String()
+		p.srcDir = gitCacheDir(p.Name)
+		p.exclude = append(p.exclude, ".git")
+	case p.Artifact != nil:
+		p.Source = p.Artifact.String()
+		p.srcDir = artifactDir(p.Name)
+	default:
+		p.srcDir = serverDir(p.Source)
+		servers = append(servers, p.Source)
+	}
+	p.dstDir = serverDir(p.Destination)
+	servers = append(servers, p.Destination)
+
+	for _, server := range servers {
+		dir, root := serverDir(server), serverRoot(server)
+		if !p.AllowOutsideBaseDir && !isWithin(root, dir) {
+			return fmt.Errorf("%s is outside of %s; set allow_outside_base_dir to allow it", dir, root)
+		}
+	}
+
+	p.keep = append([]string{}, defaults.Keep...)
+	p.exclude = append(p.exclude, defaults.Exclude...)
+	p.merge = append([]string{}, defaults.Merge...)
+	p.keepIfExists = append([]string{}, defaults.KeepIfExists...)
+	p.seedOnce = append([]string{}, defaults.SeedOnce...)
+	if p.Preset != "" {
+		preset, err := resolvePreset(p.Preset)
+		if err != nil {
+			return err
+		}
+
+		p.keep = append(p.keep, preset.Keep...)
+		p.keepIfExists = append(p.keepIfExists, preset.KeepIfExists...)
+		p.seedOnce = append(p.seedOnce, preset.SeedOnce...)
+		p.exclude = append(p.exclude, preset.Exclude...)
+		p.merge = append(p.merge, preset.Merge...)
+	}
+	p.keep = append(p.keep, p.Keep...)
+	p.keepIfExists = append(p.keepIfExists, p.KeepIfExists...)
+	p.seedOnce = append(p.seedOnce, p.SeedOnce...)
+	p.exclude = append(p.exclude, p.Exclude...)
+	p.merge = append(p.merge, p.Merge...)
+
+	if p.SourceSnapshot != nil {
+		if p.Git != nil || p.Artifact != nil {
+			return fmt.Errorf("source_snapshot requires a source directory")
+		}
+
+		err := p.SourceSnapshot.init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Quiesce != nil {
+		if panel == nil {
+			return fmt.Errorf("quiesce requires PANEL_URL and PANEL_API_KEY")
+		} else if p.Git != nil || p.Artifact != nil || filepath.IsAbs(p.Source) {
+			return fmt.Errorf("quiesce requires the source to be a server UUID")
+		}
+
+		err := p.Quiesce.init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.MaxDelete < 0 {
+		return fmt.Errorf("max_delete must not be negative")
+	} else if p.MaxDeletePercent < 0 || p.MaxDeletePercent > 100 {
+		return fmt.Errorf("max_delete_percent must be between 0 and 100")
+	}
+
+	err = p.initModes()
+	if err != nil {
+		return err
+	}
+
+	err = p.initNormalize()
+	if err != nil {
+		return err
+	}
+
+	if p.Chown != "" && p.Chown != chownAuto {
+		_, _, err := parseOwner(p.Chown)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Restart && panel == nil {
+		return fmt.Errorf("restart requires PANEL_URL and PANEL_API_KEY")
+	}
+
+	if p.PanelSync != nil {
+		err := p.PanelSync.init(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (p.Restart || p.Standby != "") && filepath.IsAbs(p.Destination) {
+		return fmt.Errorf("restart and standby require the destination to be a server UUID")
+	}
+
+	if p.RemindRestart < 0 {
+		return fmt.Errorf("remind_restart must not be negative")
+	} else if p.RemindRestart > 0 && p.Restart {
+		return fmt.Errorf("remind_restart cannot be combined with restart, which already restarts the destination")
+	}
+
+	if p.SmokeCheck != nil {
+		if !p.Restart {
+			return fmt.Errorf("smoke_check requires restart")
+		}
+
+		err := p.SmokeCheck.init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Query != nil {
+		if !p.Restart {
+			return fmt.Errorf("query requires restart")
+		}
+
+		err := p.Query.init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Rollback && p.SmokeCheck == nil {
+		return fmt.Errorf("rollback requires smoke_check")
+	}
+
+	if p.WorldCheck != "" && p.WorldCheck != worldCheckWarn && p.WorldCheck != worldCheckFail {
+		return fmt.Errorf("world_check must be %q or %q", worldCheckWarn, worldCheckFail)
+	}
+
+	if p.WatchSource != "" && p.WatchSource != watchSourceWarn && p.WatchSource != watchSourceAbort {
+		return fmt.Errorf("watch_source must be %q or %q", watchSourceWarn, watchSourceAbort)
+	}
+
+	for i, r := range p.Skip {
+		err := r.init()
+		if err != nil {
+			return fmt.Errorf("skip[%d]: %w", i, err)
+		}
+	}
+
+	if p.Budget != nil {
+		err := p.Budget.init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Limits != nil {
+		err := p.Limits.init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Proxy != nil {
+		if panel == nil {
+			return fmt.Errorf("proxy requires PANEL_URL and PANEL_API_KEY")
+		}
+
+		err := p.Proxy.init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Standby != "" {
+		if p.SmokeCheck == nil {
+			return fmt.Errorf("standby requires smoke_check")
+		} else if panelApp == nil {
+			return fmt.Errorf("standby requires PANEL_APPLICATION_KEY")
+		} else if p.Standby == p.Destination {
+			return fmt.Errorf("standby must differ from destination")
+		}
+	}
+
+	return nil
+}
+
+// checkDependencies returns an error if the dependencies of profiles form a
+// cycle.
+func checkDependencies(profiles []*profile) error {
+	deps := map[string][]string{}
+	for _, p := range profiles {
+		deps[p.Name] = p.DependsOn
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		chain = append(chain, name)
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(chain, " -> "))
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			err := visit(dep, chain)
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, p := range profiles {
+		err := visit(p.Name, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serverDir returns the directory of server, which is either a UUID on its
+// node, below baseDir if it has none, or an absolute path.
+func serverDir(server string) string {
+	if filepath.IsAbs(server) {
+		return filepath.Clean(server)
+	} else if n := findNode(server); n != nil {
+		return n.dir(server)
+	}
+	return filepath.Join(baseDir, server)
+}
+
+// isWithin reports whether path lies below dir.
+func isWithin(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// profilesMu guards profiles, which imports and bootstraps add to while
+// commands are handled.
+var profilesMu sync.Mutex
+
+// allProfiles returns the profiles. Profiles are only ever appended, so the
+// returned slice stays valid.
+func allProfiles() []*profile {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	return profiles
+}
+
+func findProfile(name string) *profile {
+	for _, p := range allProfiles() {
+		if p.Name == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// addProfile adds p to the profiles unless there already is one of the same
+// name.
+func addProfile(p *profile) error {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	for _, other := range profiles {
+		if other.Name == p.Name {
+			return fmt.Errorf("profile `%s` already exists", p.Name)
+		}
+	}
+	profiles = append(profiles, p)
+	return nil
+}
+
+// isKeepFile reports whether file at the destination must not be touched.
+func (p *profile) isKeepFile(file string) bool {
+	return matchRules(p.keep, p.dstDir, file)
+}
+
+// isPreserved reports whether file at the destination must not be deleted
+// because of a keep, keep-if-exists or seed-once rule.
+func (p *profile) isPreserved(file string) bool {
+	return p.isKeepFile(file) || matchRules(p.keepIfExists, p.dstDir, file) || matchRules(p.seedOnce, p.dstDir, file)
+}
+
+// isExcluded reports whether file, below root, is neither copied nor
+// deleted.
+func (p *profile) isExcluded(root string, file string) bool {
+	return matchRules(p.exclude, root, file)
+}
+
+// isMerged reports whether destination-only files in file at the
+// destination are preserved.
+func (p *profile) isMerged(file string) bool {
+	return matchRules(p.merge, p.dstDir, file)
+}
+
+// matchRules reports whether file, which lies below root, matches one of
+// rules.
+func matchRules(rules []string, root string, file string) bool {
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		log.Printf("Error getting relative path of %s: %s", file, err)
+		return false
+	}
+
+	for _, rule := range rules {
+		if ok, _ := filepath.Match(filepath.Clean(rule), rel); ok {
+			return true
+		}
+	}
+
+	return false
+}
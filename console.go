@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// console is a connection to the console websocket of a server through
+// Wings.
+type console struct {
+	server string
+	conn   *websocket.Conn
+}
+
+type consoleEvent struct {
+	Event string   `json:"event"`
+	Args  []string `json:"args,omitempty"`
+}
+
+func openConsole(server string) (*console, error) {
+	token, socket, err := panel.websocket(server)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wings only accepts connections originating from the panel.
+	header := http.Header{}
+	header.Set("Origin", panel.url)
+
+	conn, _, err := websocket.DefaultDialer.Dial(socket, header)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &console{server: server, conn: conn}
+	err = c.send("auth", token)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *console) send(event string, args ...string) error {
+	return c.conn.WriteJSON(consoleEvent{Event: event, Args: args})
+}
+
+func (c *console) Close() error {
+	return c.conn.Close()
+}
+
+// next returns the next event from the console, renewing the token when
+// Wings asks for it.
+func (c *console) next() (consoleEvent, error) {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return consoleEvent{}, err
+		}
+
+		var ev consoleEvent
+		if json.Unmarshal(data, &ev) != nil {
+			continue
+		}
+
+		if ev.Event == "token expiring" || ev.Event == "token expired" {
+			token, _, err := panel.websocket(c.server)
+			if err != nil {
+				return consoleEvent{}, err
+			}
+			err = c.send("auth", token)
+			if err != nil {
+				return consoleEvent{}, err
+			}
+			continue
+		}
+
+		return ev, nil
+	}
+}
+
+// waitForStart reads the console until a line matches started, a line
+// matches crash or the server goes offline again after starting, or timeout
+// elapses. It returns nil only if the server started.
+func (c *console) waitForStart(started *regexp.Regexp, crash *regexp.Regexp, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	c.conn.SetReadDeadline(deadline)
+
+	starting := false
+	for {
+		ev, err := c.next()
+		if err != nil {
+			if time.Now().After(deadline) {
+				return withCode("E_POWER_TIMEOUT", fmt.Errorf("server did not start within %s", timeout))
+			}
+			return err
+		}
+
+		switch ev.Event {
+		case "status":
+			if len(ev.Args) == 0 {
+				continue
+			}
+			switch ev.Args[0] {
+			case "starting":
+				starting = true
+			case "offline":
+				if starting {
+					return fmt.Errorf("server stopped while starting")
+				}
+			}
+		case "console output":
+			for _, line := range ev.Args {
+				if crash != nil && crash.MatchString(line) {
+					return fmt.Errorf("crash detected: %s", line)
+				}
+				if started.MatchString(line) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// waitForLine reads the console until a line matches pattern or timeout
+// elapses.
+func (c *console) waitForLine(pattern *regexp.Regexp, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	c.conn.SetReadDeadline(deadline)
+
+	for {
+		ev, err := c.next()
+		if err != nil {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("no console output matched %q within %s", pattern, timeout)
+			}
+			return err
+		}
+
+		if ev.Event != "console output" {
+			continue
+		}
+		for _, line := range ev.Args {
+			if pattern.MatchString(line) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "context"
+
+// cpuSlots bounds how many files are hashed, verified or decompressed at
+// once, nil unless max_cpu is configured.
+var cpuSlots chan struct{}
+
+// setMaxCPU lets CPU heavy work keep at most n cores busy, so that it does
+// not take CPU from game servers on the same node. Zero removes the limit.
+func setMaxCPU(n int) {
+	cpuSlots = nil
+	if n > 0 {
+		cpuSlots = make(chan struct{}, n)
+	}
+}
+
+// useCPU waits for a core to be free for CPU heavy work and returns the
+// function freeing it again.
+func useCPU(ctx context.Context) (func(), error) {
+	slots := cpuSlots
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// cpuConcurrency returns how many CPU heavy tasks may run at once, or zero
+// without a limit.
+func cpuConcurrency() int {
+	return cap(cpuSlots)
+}
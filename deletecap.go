@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// checkDeleteCap refuses to run the delete phase of j if it would remove more
+// files than the profile allows, which usually means the source is empty or
+// not mounted.
+func checkDeleteCap(j *job) error {
+	p := j.Profile
+	if (p.MaxDelete == 0 && p.MaxDeletePercent == 0) || j.AllowMassDelete {
+		return nil
+	}
+
+	total, gone, err := countDeletions(p, j.srcDir)
+	if err != nil {
+		return err
+	}
+	deleted := len(gone)
+	if !j.Delete {
+		// Only the jars replaced by jar sync are deleted.
+		deleted = len(j.replacedJars)
+	}
+
+	if p.MaxDelete > 0 && deleted > p.MaxDelete {
+		return fmt.Errorf("%d destination files would be deleted, more than max_delete (%d)", deleted, p.MaxDelete)
+	}
+
+	if p.MaxDeletePercent > 0 && total > 0 && deleted*100 > total*p.MaxDeletePercent {
+		return fmt.Errorf("%d of %d destination files (%d%%) would be deleted, more than max_delete_percent (%d%%)", deleted, total, deleted*100/total, p.MaxDeletePercent)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// deltaBlockSize is the granularity files are compared and rewritten at.
+// It is a multiple of the 4 KiB sectors Minecraft region files are laid
+// out in, so chunks that did not change line up with their old blocks.
+const deltaBlockSize = 64 << 10
+
+// deltaSyncSize is the size from which files already present at the
+// destination are delta synced; zero disables delta syncing.
+//
+// Delta syncing saves writes, not reads: the blocks of a file that did not
+// change stay shared with the old file through a reflink instead of being
+// rewritten. It therefore only applies to local destinations on filesystems
+// with reflinks, such as btrfs and XFS formatted with reflink=1. Other
+// destinations, including all remote ones, are copied in full.
+var deltaSyncSize int64
+
+// errNoDelta is returned when a file cannot be delta synced and has to be
+// copied in full, and errNoReflink when that is because the destination
+// filesystem has no reflinks.
+var (
+	errNoDelta   = errors.New("no destination file to delta sync against")
+	errNoReflink = errors.New("destination filesystem has no reflinks to delta sync with")
+)
+
+// useDelta reports whether a source file of the given size is delta synced.
+// Remote destinations are not, as comparing blocks would read all of the
+// destination over the network to save writing it.
+func (j *job) useDelta(info fs.FileInfo) bool {
+	return deltaSyncSize > 0 && !atomicWrites && !j.remote() && info.Mode().IsRegular() && info.Size() >= deltaSyncSize
+}
+
+// deltaCopyFile replaces dstPath with srcPath, writing only the blocks that
+// differ. The destination is cloned into a temporary file, which is patched
+// and renamed over it, so an interrupted copy leaves the old file intact.
+// Blocks are compared at the same offset, which suits files like region
+// files that change in place. Without reflinks, cloning would mean writing
+// the whole file, so errNoReflink is returned for a copy in full. It returns
+// the number of bytes that had to be written.
+func deltaCopyFile(srcPath string, dstPath string, info fs.FileInfo) (int64, error) {
+	dst, err := os.Open(dstPath)
+	if os.IsNotExist(err) {
+		return 0, errNoDelta
+	} else if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	if dstInfo, err := dst.Stat(); err != nil {
+		return 0, err
+	} else if !dstInfo.Mode().IsRegular() {
+		return 0, errNoDelta
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".releaser-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if cloneFile(tmp, dst) != nil {
+		return 0, errNoReflink
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	srcBuf := make([]byte, deltaBlockSize)
+	dstBuf := make([]byte, deltaBlockSize)
+	var offset, written int64
+	for {
+		n, rerr := io.ReadFull(src, srcBuf)
+		if n > 0 {
+			m, _ := io.ReadFull(dst, dstBuf[:n])
+			if m != n || !bytes.Equal(srcBuf[:n], dstBuf[:n]) {
+				_, err = tmp.WriteAt(srcBuf[:n], offset)
+				if err != nil {
+					return written, err
+				}
+				written += int64(n)
+			}
+			offset += int64(n)
+		}
+
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			return written, rerr
+		}
+	}
+
+	err = tmp.Truncate(offset)
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if err == nil && durability == durabilityFile {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return written, err
+	}
+
+	return written, os.Rename(tmp.Name(), dstPath)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxChangelog is the length of the changelog snippet shown in
+// notifications, which Discord caps at 1024 characters per field.
+const maxChangelog = 1000
+
+// releaseInfo is the build metadata of a job triggered by CI.
+type releaseInfo struct {
+	// Commit is the SHA of the released commit, and Repository the web URL
+	// of its repository, e.g. https://github.com/org/repo.
+	Commit     string `json:"commit"`
+	Repository string `json:"repository"`
+
+	// BuildURL links to the CI run that built the release.
+	BuildURL string `json:"build_url"`
+
+	// ArtifactURL replaces the artifact URL of the profile.
+	ArtifactURL string `json:"artifact_url"`
+
+	Changelog string `json:"changelog"`
+}
+
+// commitLink formats the commit as a Markdown link if the repository is
+// known.
+func (r *releaseInfo) commitLink() string {
+	short := r.Commit
+	if len(short) > 7 {
+		short = short[:7]
+	}
+
+	if r.Repository == "" {
+		return fmt.Sprintf("`%s`", short)
+	}
+	return fmt.Sprintf("[`%s`](%s/commit/%s)", short, strings.TrimSuffix(r.Repository, "/"), r.Commit)
+}
+
+// fields lists the release metadata for notifications.
+func (r *releaseInfo) fields() []NotificationField {
+	fields := []NotificationField{}
+	if r.Commit != "" {
+		fields = append(fields, NotificationField{Name: "Commit", Value: r.commitLink()})
+	}
+	if r.BuildURL != "" {
+		fields = append(fields, NotificationField{Name: "Build", Value: fmt.Sprintf("[CI run](%s)", r.BuildURL)})
+	}
+	if changelog := strings.TrimSpace(r.Changelog); changelog != "" {
+		fields = append(fields, changelogField(changelog))
+	}
+	return fields
+}
+
+func changelogField(changelog string) NotificationField {
+	if len(changelog) > maxChangelog {
+		changelog = strings.TrimSpace(changelog[:maxChangelog]) + "\n..."
+	}
+	return NotificationField{Name: "Changelog", Value: changelog}
+}
+
+// deployRequest is the body of POST /deploy.
+type deployRequest struct {
+	startJobRequest
+	releaseInfo
+
+	// Build and SHA256 select the artifact of artifact profiles, like the
+	// options of /copy.
+	Build  string `json:"build"`
+	SHA256 string `json:"sha256"`
+}
+
+// handleDeploy starts a release from CI, e.g. a GitHub Actions workflow,
+// and reports it with its commit and changelog.
+func handleDeploy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	} else if !authorized(w, r, scopeCopy) {
+		return
+	}
+
+	var req deployRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if p := findProfile(req.Profile); p != nil && p.Artifact == nil && (req.ArtifactURL != "" || req.Build != "") {
+		http.Error(w, "profile is not released from an artifact", http.StatusBadRequest)
+		return
+	}
+
+	j, err := launchJob(&req.startJobRequest, func(j *job) {
+		j.Release = &req.releaseInfo
+		j.Build = req.Build
+		j.SHA256 = req.SHA256
+	})
+	if errors.Is(err, errUnknownProfile) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, j.status())
+}
@@ -0,0 +1,37 @@
+package main
+
+import "io/fs"
+
+// fileID identifies a file by its device and inode, whatever path leads to
+// it.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// dirChain is a directory and the ones it was reached through, from the root
+// of a walk down to it.
+type dirChain struct {
+	id     fileID
+	path   string
+	parent *dirChain
+}
+
+// enter returns the chain with the directory at path added. If the directory
+// is already in the chain, as with a symlink or bind mount pointing to one of
+// its parents, it returns the path it was first reached through instead, and
+// the directory must not be entered again. Directories are not tracked where
+// the platform has no inodes.
+func (c *dirChain) enter(path string, info fs.FileInfo) (*dirChain, string) {
+	id, ok := fileIDOf(info)
+	if !ok {
+		return c, ""
+	}
+
+	for p := c; p != nil; p = p.parent {
+		if p.id == id {
+			return c, p.path
+		}
+	}
+	return &dirChain{id: id, path: path, parent: c}, ""
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "io/fs"
+
+func fileIDOf(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
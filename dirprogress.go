@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// rootFiles is the key of the files directly in the server directory, which
+// are tracked together.
+const rootFiles = "."
+
+// maxDirsShown is the number of top-level directories listed in the
+// progress of a job.
+const maxDirsShown = 8
+
+// dirProgress is how far the copy of a top-level directory of the server
+// has got.
+type dirProgress struct {
+	Name   string
+	Total  int64
+	Copied int64
+
+	// dispatched is set once every file in the directory has been handed
+	// to a copy worker, and pending counts the ones still being copied.
+	dispatched bool
+	pending    int
+}
+
+func (d *dirProgress) done() bool {
+	return d.dispatched && d.pending == 0
+}
+
+func (d *dirProgress) started() bool {
+	return d.Copied > 0 || d.pending > 0
+}
+
+func (d *dirProgress) String() string {
+	name := d.Name
+	if name == rootFiles {
+		name = "files"
+	}
+
+	switch {
+	case d.done():
+		return name + " ✔"
+	case d.Total == 0:
+		return name
+	default:
+		return fmt.Sprintf("%s %d%%", name, min(d.Copied*100/d.Total, 99))
+	}
+}
+
+// topDir returns the key of the top-level directory path lies in, relative
+// to root.
+func topDir(root string, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return rootFiles
+	}
+
+	top, _, ok := strings.Cut(filepath.ToSlash(rel), "/")
+	if !ok {
+		return rootFiles
+	}
+	return top
+}
+
+// dir returns the progress of a top-level directory. j.mu must be held.
+func (j *job) dir(name string) *dirProgress {
+	if j.dirs == nil {
+		j.dirs = map[string]*dirProgress{}
+	}
+
+	d, ok := j.dirs[name]
+	if !ok {
+		d = &dirProgress{Name: name}
+		j.dirs[name] = d
+		j.dirOrder = append(j.dirOrder, name)
+	}
+	return d
+}
+
+// setDirTotals records the bytes the scan found per top-level directory.
+func (j *job) setDirTotals(totals map[string]int64, order []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, name := range order {
+		j.dir(name).Total = totals[name]
+	}
+}
+
+func (j *job) dirDispatched(name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if d, ok := j.dirs[name]; ok {
+		d.dispatched = true
+	}
+}
+
+func (j *job) dirPending(name string, delta int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.dir(name).pending += delta
+}
+
+// dirsString lists the top-level directories that are done or being
+// copied. If there are too many, the directories finished first are left
+// out. j.mu must be held.
+func (j *job) dirsString() string {
+	dirs := []*dirProgress{}
+	more := 0
+	for _, name := range j.dirOrder {
+		if d := j.dirs[name]; d.done() || d.started() {
+			dirs = append(dirs, d)
+		} else {
+			more++
+		}
+	}
+
+	for i := 0; i < len(dirs) && len(dirs) > maxDirsShown; {
+		if dirs[i].done() {
+			dirs = append(dirs[:i], dirs[i+1:]...)
+			more++
+		} else {
+			i++
+		}
+	}
+
+	if len(dirs) == 0 {
+		return ""
+	}
+
+	shown := []string{}
+	for _, d := range dirs {
+		shown = append(shown, d.String())
+	}
+	if more > 0 {
+		shown = append(shown, fmt.Sprintf("%d more", more))
+	}
+	return strings.Join(shown, ", ")
+}
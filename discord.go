@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var errNoGuilds = errors.New("no guilds found")
+
+var discordOptionTypes = map[OptionType]discordgo.ApplicationCommandOptionType{
+	OptionString:     discordgo.ApplicationCommandOptionString,
+	OptionBool:       discordgo.ApplicationCommandOptionBoolean,
+	OptionInteger:    discordgo.ApplicationCommandOptionInteger,
+	OptionAttachment: discordgo.ApplicationCommandOptionAttachment,
+}
+
+// discordIntents are the gateway intents that can be requested by name.
+// Slash commands do not need any.
+var discordIntents = map[string]discordgo.Intent{
+	"guilds":                        discordgo.IntentGuilds,
+	"guild_members":                 discordgo.IntentGuildMembers,
+	"guild_moderation":              discordgo.IntentGuildModeration,
+	"guild_emojis":                  discordgo.IntentGuildEmojis,
+	"guild_integrations":            discordgo.IntentGuildIntegrations,
+	"guild_webhooks":                discordgo.IntentGuildWebhooks,
+	"guild_invites":                 discordgo.IntentGuildInvites,
+	"guild_voice_states":            discordgo.IntentGuildVoiceStates,
+	"guild_presences":               discordgo.IntentGuildPresences,
+	"guild_messages":                discordgo.IntentGuildMessages,
+	"guild_message_reactions":       discordgo.IntentGuildMessageReactions,
+	"guild_message_typing":          discordgo.IntentGuildMessageTyping,
+	"direct_messages":               discordgo.IntentDirectMessages,
+	"direct_message_reactions":      discordgo.IntentDirectMessageReactions,
+	"direct_message_typing":         discordgo.IntentDirectMessageTyping,
+	"message_content":               discordgo.IntentMessageContent,
+	"guild_scheduled_events":        discordgo.IntentGuildScheduledEvents,
+	"auto_moderation_configuration": discordgo.IntentAutoModerationConfiguration,
+	"auto_moderation_execution":     discordgo.IntentAutoModerationExecution,
+}
+
+// parseIntents parses a comma-separated list of intent names.
+func parseIntents(s string) (discordgo.Intent, error) {
+	intents := discordgo.IntentsNone
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		intent, ok := discordIntents[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown intent %q", name)
+		}
+		intents |= intent
+	}
+
+	return intents, nil
+}
+
+// discordGateway configures the gateway connection of the bot.
+type discordGateway struct {
+	Intents discordgo.Intent
+
+	// GuildID is the guild commands are registered in. Without it, the
+	// first guild the bot is a member of is used.
+	GuildID string
+
+	// TestGuildID is a guild the commands are also registered in for
+	// practicing, where only sandbox profiles are available.
+	TestGuildID string
+
+	// ShardID and ShardCount run the bot as one shard of a larger
+	// application, e.g. when sharing the token of another bot.
+	ShardID    int
+	ShardCount int
+}
+
+// guildShard returns the shard Discord routes the events of a guild to.
+func guildShard(guildID string, shardCount int) (int, error) {
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid guild ID %q", guildID)
+	}
+	return int((id >> 22) % uint64(shardCount)), nil
+}
+
+type discordFrontend struct {
+	session     *discordgo.Session
+	guildID     string
+	testGuildID string
+
+	// interactions routes the interactions with the bot, which is the
+	// dispatcher shared by all frontends unless the bot has its own.
+	interactions *dispatcher
+
+	// allows limits the jobs shown in the presence of the bot to its
+	// profiles. All jobs are shown if nil.
+	allows func(*profile) bool
+}
+
+func newDiscordFrontend(token string, gw discordGateway) (*discordFrontend, error) {
+	dg, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the bot's own user is needed from the gateway, so nothing else
+	// is cached.
+	dg.StateEnabled = false
+	dg.Identify.Intents = gw.Intents
+	if gw.ShardCount > 1 {
+		dg.ShardID = gw.ShardID
+		dg.ShardCount = gw.ShardCount
+	}
+
+	d := &discordFrontend{session: dg, guildID: gw.GuildID, testGuildID: gw.TestGuildID, interactions: interactions}
+	dg.AddHandler(d.interactionCreate)
+
+	return d, nil
+}
+
+func (d *discordFrontend) Open() error {
+	err := d.session.Open()
+	if err != nil {
+		return err
+	}
+
+	if d.guildID != "" {
+		return nil
+	}
+
+	guilds, err := d.session.UserGuilds(1, "", "", false)
+	if err != nil {
+		return err
+	} else if len(guilds) == 0 {
+		return errNoGuilds
+	}
+	d.guildID = guilds[0].ID
+
+	return nil
+}
+
+// RegisterCommands replaces the commands of the bot in the guild, and the
+// test guild if there is one, with cmds in a single bulk overwrite, so
+// commands left over from a crash or an older version are removed and
+// restarts do not create duplicates.
+func (d *discordFrontend) RegisterCommands(cmds []*Command) error {
+	appID := d.session.State.User.ID
+
+	wanted := map[string]bool{}
+	appCmds := []*discordgo.ApplicationCommand{}
+	for _, c := range cmds {
+		options := discordOptions(c.Options)
+		for _, s := range c.Subcommands {
+			options = append(options, &discordgo.ApplicationCommandOption{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        s.Name,
+				Description: s.Description,
+				Options:     discordOptions(s.Options),
+			})
+		}
+
+		appCmds = append(appCmds, &discordgo.ApplicationCommand{
+			Name:        c.Name,
+			Description: c.Description,
+			Options:     options,
+		})
+		wanted[c.Name] = true
+	}
+
+	guilds := []string{d.guildID}
+	if d.testGuildID != "" && d.testGuildID != d.guildID {
+		guilds = append(guilds, d.testGuildID)
+	}
+	for _, guildID := range guilds {
+		existing, err := d.session.ApplicationCommands(appID, guildID)
+		if err != nil {
+			return err
+		}
+
+		for _, cmd := range existing {
+			if !wanted[cmd.Name] {
+				log.Printf("Removing stale application command %s", cmd.Name)
+			}
+		}
+
+		log.Printf("Registering %d application commands in guild %s", len(appCmds), guildID)
+		_, err = d.session.ApplicationCommandBulkOverwrite(appID, guildID, appCmds)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func discordOptions(opts []*CommandOption) []*discordgo.ApplicationCommandOption {
+	options := []*discordgo.ApplicationCommandOption{}
+	for _, o := range opts {
+		options = append(options, &discordgo.ApplicationCommandOption{
+			Type:        discordOptionTypes[o.Type],
+			Name:        o.Name,
+			Description: o.Description,
+			Required:    o.Required,
+		})
+	}
+	return options
+}
+
+// Close disconnects from Discord. Commands stay registered, so they keep
+// working across restarts.
+func (d *discordFrontend) Close() error {
+	return d.session.Close()
+}
+
+func (d *discordFrontend) interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var ctx CommandContext = &discordCommandContext{session: s, interaction: i}
+	switch {
+	case i.GuildID == d.guildID:
+	case i.GuildID == d.testGuildID && d.testGuildID != "":
+		ctx = &sandboxContext{CommandContext: ctx}
+	default:
+		return
+	}
+
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		name := data.Name
+		if len(data.Options) > 0 && data.Options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+			name += " " + data.Options[0].Name
+		}
+		d.interactions.command(name, ctx)
+	case discordgo.InteractionMessageComponent:
+		d.interactions.component(i.MessageComponentData().CustomID, ctx)
+	case discordgo.InteractionModalSubmit:
+		d.interactions.component(i.ModalSubmitData().CustomID, ctx)
+	}
+}
+
+type discordCommandContext struct {
+	session     *discordgo.Session
+	interaction *discordgo.InteractionCreate
+}
+
+func (c *discordCommandContext) Reply(n *Notification) (Reply, error) {
+	err := c.session.InteractionRespond(c.interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{n.embed()},
+			Components: n.components(),
+			Files:      n.files(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &discordReply{session: c.session, interaction: c.interaction.Interaction}, nil
+}
+
+func (c *discordCommandContext) Notifier() Notifier {
+	return &discordNotifier{session: c.session, channelID: c.interaction.ChannelID}
+}
+
+func (c *discordCommandContext) User() string {
+	if c.interaction.Member != nil {
+		return c.interaction.Member.User.ID
+	}
+	return c.interaction.User.ID
+}
+
+func (c *discordCommandContext) Option(name string) string {
+	switch c.interaction.Type {
+	case discordgo.InteractionMessageComponent:
+		if name == "value" {
+			return strings.Join(c.interaction.MessageComponentData().Values, ",")
+		}
+		return ""
+	case discordgo.InteractionModalSubmit:
+		for _, row := range c.interaction.ModalSubmitData().Components {
+			row, ok := row.(*discordgo.ActionsRow)
+			if !ok {
+				continue
+			}
+			for _, field := range row.Components {
+				if field, ok := field.(*discordgo.TextInput); ok && field.CustomID == name {
+					return field.Value
+				}
+			}
+		}
+		return ""
+	}
+	if c.interaction.Type != discordgo.InteractionApplicationCommand {
+		return ""
+	}
+
+	data := c.interaction.ApplicationCommandData()
+	options := data.Options
+	if len(options) > 0 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+		options = options[0].Options
+	}
+	for _, opt := range options {
+		if opt.Name != name {
+			continue
+		} else if opt.Type == discordgo.ApplicationCommandOptionAttachment {
+			if data.Resolved != nil && data.Resolved.Attachments[fmt.Sprint(opt.Value)] != nil {
+				return data.Resolved.Attachments[fmt.Sprint(opt.Value)].URL
+			}
+			return ""
+		}
+		return fmt.Sprint(opt.Value)
+	}
+
+	return ""
+}
+
+func (c *discordCommandContext) ShowForm(f *Form) error {
+	rows := []discordgo.MessageComponent{}
+	for _, field := range f.Fields {
+		rows = append(rows, discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.TextInput{
+				CustomID:    field.Name,
+				Label:       field.Label,
+				Style:       discordgo.TextInputShort,
+				Placeholder: field.Placeholder,
+				Value:       field.Value,
+				Required:    field.Required,
+			},
+		}})
+	}
+
+	return c.session.InteractionRespond(c.interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   f.ID,
+			Title:      f.Title,
+			Components: rows,
+		},
+	})
+}
+
+func (c *discordCommandContext) Update(n *Notification) error {
+	if c.interaction.Type != discordgo.InteractionMessageComponent {
+		_, err := c.Reply(n)
+		return err
+	}
+
+	return c.session.InteractionRespond(c.interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{n.embed()},
+			Components: n.components(),
+		},
+	})
+}
+
+type discordReply struct {
+	session     *discordgo.Session
+	interaction *discordgo.Interaction
+}
+
+func (r *discordReply) Edit(n *Notification) error {
+	embeds := []*discordgo.MessageEmbed{n.embed()}
+	components := n.components()
+	_, err := r.session.InteractionResponseEdit(r.interaction, &discordgo.WebhookEdit{
+		Embeds:     &embeds,
+		Components: &components,
+	})
+	return err
+}
+
+func (r *discordReply) Links() (string, string) {
+	guild := r.interaction.GuildID
+	if guild == "" {
+		guild = "@me"
+	}
+	channel := fmt.Sprintf("https://discord.com/channels/%s/%s", guild, r.interaction.ChannelID)
+
+	msg, err := r.session.InteractionResponse(r.interaction)
+	if err != nil {
+		log.Printf("Error looking up reply: %s", err)
+		return "", channel
+	}
+	return fmt.Sprintf("%s/%s", channel, msg.ID), channel
+}
+
+type discordNotifier struct {
+	session   *discordgo.Session
+	channelID string
+}
+
+func (d *discordNotifier) Notify(n *Notification) error {
+	msg := &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{n.embed()},
+		Components: n.components(),
+		Files:      n.files(),
+	}
+
+	_, err := d.session.ChannelMessageSendComplex(d.channelID, msg)
+	return err
+}
+
+// files returns the attachments of n, typed by their extension.
+func (n *Notification) files() []*discordgo.File {
+	files := []*discordgo.File{}
+	for _, f := range n.Files {
+		contentType := "text/plain"
+		switch filepath.Ext(f.Name) {
+		case ".md":
+			contentType = "text/markdown"
+		case ".yml", ".yaml":
+			contentType = "application/yaml"
+		}
+		files = append(files, &discordgo.File{
+			Name:        f.Name,
+			ContentType: contentType,
+			Reader:      bytes.NewReader(f.Data),
+		})
+	}
+	return files
+}
+
+func (n *Notification) embed() *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Color:       n.Color,
+		Title:       n.Title,
+		Description: n.Description,
+	}
+	for _, f := range n.Fields {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   f.Name,
+			Value:  f.Value,
+			Inline: false,
+		})
+	}
+	return embed
+}
+
+// components renders the select menus of n in a row each, followed by the
+// actions as a row of buttons.
+func (n *Notification) components() []discordgo.MessageComponent {
+	rows := []discordgo.MessageComponent{}
+	for _, s := range n.Selects {
+		menu := discordgo.SelectMenu{CustomID: s.ID, Placeholder: s.Placeholder}
+		for _, c := range s.Choices {
+			menu.Options = append(menu.Options, discordgo.SelectMenuOption{
+				Label:       c.Label,
+				Value:       c.Value,
+				Description: c.Description,
+				Default:     c.Default,
+			})
+		}
+		rows = append(rows, discordgo.ActionsRow{Components: []discordgo.MessageComponent{menu}})
+	}
+	if len(n.Actions) == 0 {
+		return rows
+	}
+
+	row := discordgo.ActionsRow{}
+	for _, a := range n.Actions {
+		row.Components = append(row.Components, discordgo.Button{
+			Label:    a.Label,
+			Style:    discordgo.DangerButton,
+			CustomID: a.ID,
+		})
+	}
+	return append(rows, row)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HandlerFunc handles a single command or component interaction.
+type HandlerFunc func(ctx CommandContext)
+
+// Middleware wraps the handler of every interaction. route is the name of
+// the command or component being handled.
+type Middleware func(route string, next HandlerFunc) HandlerFunc
+
+// Component is a button, select menu or modal. Its custom ID is the name,
+// optionally followed by a colon and an argument such as a job ID, which
+// is passed to the handler.
+type Component struct {
+	Name    string
+	Handler func(ctx CommandContext, arg string)
+}
+
+// dispatcher routes interactions from all frontends to their handlers
+// through a chain of middleware.
+type dispatcher struct {
+	commands   map[string]*Command
+	components map[string]*Component
+	middleware []Middleware
+}
+
+func newDispatcher(middleware ...Middleware) *dispatcher {
+	return &dispatcher{
+		commands:   map[string]*Command{},
+		components: map[string]*Component{},
+		middleware: middleware,
+	}
+}
+
+// interactions is the dispatcher of the bot. Middleware runs in order, so
+// panics in the later ones are recovered too.
+var interactions = newDispatcher(recoverPanics, logInteractions, authorizeUsers, checkPermissions)
+
+func (d *dispatcher) register(cmds []*Command, components []*Component) {
+	for _, c := range cmds {
+		if len(c.Subcommands) == 0 {
+			d.commands[c.Name] = c
+		}
+		for _, s := range c.Subcommands {
+			d.commands[c.Name+" "+s.Name] = s
+		}
+	}
+	for _, c := range components {
+		d.components[c.Name] = c
+	}
+}
+
+// wrap applies the middleware of d to h.
+func (d *dispatcher) wrap(route string, h HandlerFunc) HandlerFunc {
+	for i := len(d.middleware) - 1; i >= 0; i-- {
+		h = d.middleware[i](route, h)
+	}
+	return h
+}
+
+// command handles an invocation of the named command, which is
+// "<command> <subcommand>" for subcommands. It reports whether the command
+// is known.
+func (d *dispatcher) command(name string, ctx CommandContext) bool {
+	cmd, ok := d.commands[name]
+	if !ok {
+		return false
+	}
+
+	d.wrap(name, func(ctx CommandContext) {
+		for _, opt := range cmd.Options {
+			if opt.Required && ctx.Option(opt.Name) == "" {
+				replyError(ctx, "Missing required option `%s`!", opt.Name)
+				return
+			}
+		}
+
+		cmd.Handler(ctx)
+	})(ctx)
+	return true
+}
+
+// component handles an interaction with the component of customID. It
+// reports whether the component is known.
+func (d *dispatcher) component(customID string, ctx CommandContext) bool {
+	name, arg, _ := strings.Cut(customID, ":")
+	c, ok := d.components[name]
+	if !ok {
+		return false
+	}
+
+	d.wrap(name, func(ctx CommandContext) {
+		c.Handler(ctx, arg)
+	})(ctx)
+	return true
+}
+
+// recoverPanics turns a panic into a failure message instead of taking the
+// whole bot down.
+func recoverPanics(route string, next HandlerFunc) HandlerFunc {
+	return func(ctx CommandContext) {
+		defer func() {
+			if r := recover(); r != nil {
+				err := panicError(r)
+				log.Printf("Panic while handling %s: %s", route, err)
+				reportError(err, map[string]string{"command": route})
+
+				nerr := ctx.Notifier().Notify(&Notification{
+					Color:       0xff0000,
+					Description: ":x: An internal error occurred while handling the command!",
+				})
+				if nerr != nil {
+					log.Printf("Error sending notification: %s", nerr)
+				}
+			}
+		}()
+
+		next(ctx)
+	}
+}
+
+func logInteractions(route string, next HandlerFunc) HandlerFunc {
+	return func(ctx CommandContext) {
+		start := time.Now()
+		log.Printf("%s invoked %s", ctx.User(), route)
+		next(ctx)
+		log.Printf("Handled %s for %s in %s", route, ctx.User(), time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// allowedUsers are the users that may interact with the bot, from
+// ALLOWED_USERS. Anyone may if it is empty.
+var allowedUsers = envList("ALLOWED_USERS")
+
+func authorizeUsers(route string, next HandlerFunc) HandlerFunc {
+	return func(ctx CommandContext) {
+		if len(allowedUsers) > 0 && !slices.Contains(allowedUsers, ctx.User()) {
+			log.Printf("Refused %s for %s", route, ctx.User())
+			replyError(ctx, "You are not allowed to use `%s`!", route)
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+// envList parses an optional comma-separated environment variable.
+func envList(key string) []string {
+	list := []string{}
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// usage is the size of a directory tree.
+type usage struct {
+	Files int
+	Bytes int64
+}
+
+// dirUsage adds up the sizes of the files below dir. A missing directory is
+// empty.
+func dirUsage(dir string) (usage, error) {
+	var files, bytes atomic.Int64
+	err := walkConcurrent(dir, backendConcurrency[filesystemBackend(dir)].Scan, func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		files.Add(1)
+		bytes.Add(info.Size())
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return usage{}, nil
+	} else if err != nil {
+		return usage{}, err
+	}
+
+	return usage{Files: int(files.Load()), Bytes: bytes.Load()}, nil
+}
+
+func handleDu(ctx CommandContext) {
+	p := selectProfile(ctx)
+	if p == nil {
+		return
+	}
+	p = p.target()
+
+	reply, err := ctx.Reply(&Notification{
+		Color:       0xffff00,
+		Description: "Measuring server files...",
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+
+	n := duNotification(p)
+	if reply == nil {
+		_, err = ctx.Reply(n)
+	} else {
+		err = reply.Edit(n)
+	}
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+func duNotification(p *profile) *Notification {
+	src, err := dirUsage(p.srcDir)
+	if err == nil {
+		var dst, snapshots usage
+		dst, err = dirUsage(p.dstDir)
+		if err == nil {
+			snapshots, err = dirUsage(filepath.Join(dataDir, "snapshots"))
+		}
+		if err == nil {
+			return usageNotification(p, src, dst, snapshots)
+		}
+	}
+
+	log.Printf("Error measuring %s: %s", p.Name, err)
+	return &Notification{
+		Color:       0xff0000,
+		Description: fmt.Sprintf(":x: Failed to measure server files!\n```\n%s\n```", err),
+	}
+}
+
+func usageNotification(p *profile, src usage, dst usage, snapshots usage) *Notification {
+	n := &Notification{
+		Color: 0x87ceeb,
+		Title: fmt.Sprintf("Storage for %s", p.Name),
+		Fields: []NotificationField{
+			{
+				Name:  "Source",
+				Value: fmt.Sprintf("`%s`\n%d files (%s)\n%s", p.Source, src.Files, formatBytes(src.Bytes), freeString(p.srcDir)),
+			},
+			{
+				Name:  "Destination",
+				Value: fmt.Sprintf("`%s`\n%d files (%s)\n%s", p.Destination, dst.Files, formatBytes(dst.Bytes), freeString(p.dstDir)),
+			},
+			{
+				Name:  "Rollback Snapshots",
+				Value: fmt.Sprintf("%d files (%s)\n%s", snapshots.Files, formatBytes(snapshots.Bytes), freeString(dataDir)),
+			},
+		},
+	}
+
+	// The release overwrites the destination, so it only needs room for
+	// the source beyond what the destination already takes up, plus a
+	// copy of the destination if it is snapshotted for rollback.
+	need := max(src.Bytes-dst.Bytes, 0)
+	if free, _, err := diskSpace(p.dstDir); err == nil && need > free {
+		n.Color = 0xffa500
+		n.Description = fmt.Sprintf(":warning: The release needs about %s more on the destination, but only %s is free.", formatBytes(need), formatBytes(free))
+	} else if free, _, err := diskSpace(dataDir); err == nil && p.Rollback && dst.Bytes > free {
+		n.Color = 0xffa500
+		n.Description = fmt.Sprintf(":warning: The rollback snapshot needs about %s in the data directory, but only %s is free.", formatBytes(dst.Bytes), formatBytes(free))
+	} else {
+		n.Description = ":white_check_mark: There is enough room for a release."
+	}
+
+	return n
+}
+
+// freeString describes the space left on the filesystem holding dir.
+func freeString(dir string) string {
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		return "Free space unknown"
+	}
+	return fmt.Sprintf("%s free of %s", formatBytes(free), formatBytes(total))
+}
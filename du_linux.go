@@ -0,0 +1,14 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// diskSpace returns the bytes available to unprivileged users and the total
+// size of the filesystem holding path.
+func diskSpace(path string) (free int64, total int64, err error) {
+	var st unix.Statfs_t
+	err = unix.Statfs(path, &st)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Bavail) * st.Bsize, int64(st.Blocks) * st.Bsize, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func diskSpace(path string) (free int64, total int64, err error) {
+	return 0, 0, errors.New("disk space is only available on Linux")
+}
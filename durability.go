@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Durability modes controlling how written data is flushed to disk.
+const (
+	// durabilityNone leaves flushing to the kernel.
+	durabilityNone = "none"
+	// durabilityFile fsyncs every written file and its directory.
+	durabilityFile = "file"
+	// durabilitySyncfs flushes the whole destination filesystem once the
+	// copy has finished.
+	durabilitySyncfs = "syncfs"
+)
+
+func parseDurability(v string) (string, error) {
+	switch v {
+	case "":
+		return durabilityNone, nil
+	case durabilityNone, durabilityFile, durabilitySyncfs:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unknown durability mode %q", v)
+	}
+}
+
+// writeFile is like os.WriteFile but fsyncs the file before closing it when
+// durability is set to file.
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil && durability == durabilityFile {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func syncDir(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
+
+// syncWrites makes the files written by j durable according to the
+// configured durability mode.
+func syncWrites(j *job, dstDirPath string) error {
+	switch durability {
+	case durabilityFile:
+		for _, dir := range j.writtenDirs {
+			err := syncDir(dir)
+			if err != nil {
+				return err
+			}
+		}
+	case durabilitySyncfs:
+		return syncfs(dstDirPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func syncfs(path string) error {
+	return errors.New("syncfs is only supported on Linux")
+}
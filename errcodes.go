@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorCode is a stable identifier for a kind of failure, shown in failure
+// messages and explained by /help errors.
+type errorCode struct {
+	Code    string
+	Summary string
+	Remedy  string
+}
+
+var errorCodes = []*errorCode{
+	{"E_DST_MISSING", "The destination server directory does not exist.", "Check the destination of the profile and that the server has not been deleted."},
+	{"E_NODE", "A remote node could not be mounted.", "Check the host and credentials of the node and that sshfs is installed."},
+	{"E_SRC_MISSING", "The source server directory does not exist.", "Check the source of the profile and that the server has not been deleted."},
+	{"E_SOURCE_INVALID", "The source is empty or misses marker files.", "Make sure the source is mounted and complete, or use the allow-empty-source option."},
+	{"E_DELETE_CAP", "The release would delete more files than the profile allows.", "Check that the source is complete, or use the allow-mass-delete option."},
+	{"E_DISK_FULL", "The destination ran out of disk space.", "Free up space on the destination volume and copy again."},
+	{"E_PERMISSION", "A file could not be read or written due to its permissions.", "Check the ownership and mode of the file named in the error."},
+	{"E_GIT", "The git repository could not be checked out.", "Check the repository URL, ref and credentials of the profile."},
+	{"E_ARTIFACT", "The artifact could not be downloaded or extracted.", "Check the artifact URL, build and checksum."},
+	{"E_QUIESCE", "Saving could not be paused on the source server.", "Check that the source server is running and its console is reachable."},
+	{"E_SRC_SNAPSHOT", "The snapshot of the source could not be taken.", "Check the source_snapshot settings and free space in the volume or pool."},
+	{"E_SNAPSHOT", "The rollback snapshot of the destination could not be taken.", "Check free space in the data directory."},
+	{"E_DRAIN", "Players could not be moved off the destination.", "Check that the proxy server is running and the drain commands are correct."},
+	{"E_SCAN", "The source files could not be scanned.", "Check the error for the file that could not be read."},
+	{"E_LIMIT", "The source has more files, bytes or nesting than the profile allows.", "Look for backups or loops inside the source, or raise the limits of the profile."},
+	{"E_DELETE", "Files could not be removed from the destination.", "Check the error for the file that could not be removed."},
+	{"E_COPY", "Files could not be copied to the destination.", "Check the error for the file that could not be copied."},
+	{"E_SOURCE_CHANGED", "Source files were modified while they were being copied.", "Stop the source server or wait for changes to finish, then copy again."},
+	{"E_WORLD_CORRUPT", "Copied region or level.dat files failed the world check.", "Check the files listed in the warnings and restore them from a backup."},
+	{"E_SYNC", "Copied files could not be flushed to disk.", "Check the destination volume for I/O errors."},
+	{"E_STATE", "The state kept in the data directory could not be read.", "Check the files in DATA_DIR for corruption."},
+	{"E_PANEL", "The panel could not be reached.", "Check PANEL_URL, the API key and that the panel is up."},
+	{"E_POWER_TIMEOUT", "The destination server did not restart in time.", "Check the server in the panel; it may be stuck starting or stopping."},
+	{"E_SMOKE_CHECK", "The destination server did not pass the smoke check after restarting.", "Check the server console for the error it crashed with."},
+	{"E_ROLLBACK", "Restoring the destination after a failed release also failed.", "Restore the destination by hand, it may be in a partial state."},
+	{"E_SWITCH", "Traffic could not be switched to the released server.", "Check the allocations of both servers in the panel."},
+	{"E_UPSTREAM", "The job was skipped because a profile it depends on failed to release.", "Fix the profile that failed and release the group again."},
+	{"E_CANCELED", "The job was canceled.", "Start the copy again when ready."},
+	{"E_PANIC", "The bot crashed while working on the job.", "Report the error with the stack trace from the logs of the bot."},
+	{"E_INTERNAL", "An unexpected error occurred.", "Check the logs of the bot and report the error."},
+}
+
+func findErrorCode(code string) *errorCode {
+	for _, c := range errorCodes {
+		if c.Code == code {
+			return c
+		}
+	}
+	return nil
+}
+
+// codedError attaches an error code to an error.
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+func withCode(code string, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// errorCodeOf returns the code of err. Running out of space, permissions
+// and cancellation take precedence over the step that failed, and codes
+// attached deeper in the chain over those attached further out.
+func errorCodeOf(err error) *errorCode {
+	code := ""
+	var coded *codedError
+	for e := err; errors.As(e, &coded); e = coded.err {
+		code = coded.code
+	}
+
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return findErrorCode("E_DISK_FULL")
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return findErrorCode("E_PERMISSION")
+	case errors.Is(err, errCanceled):
+		return findErrorCode("E_CANCELED")
+	case code != "":
+		return findErrorCode(code)
+	default:
+		return findErrorCode("E_INTERNAL")
+	}
+}
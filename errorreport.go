@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// ErrorReporter forwards failures to an error tracking service.
+type ErrorReporter interface {
+	Report(err error, tags map[string]string) error
+}
+
+func reportError(err error, tags map[string]string) {
+	for _, reporter := range errorReporters {
+		rerr := reporter.Report(err, tags)
+		if rerr != nil {
+			log.Printf("Error reporting error: %s", rerr)
+		}
+	}
+}
+
+// panicError converts a recovered panic value into an error carrying the
+// stack trace of the panicking goroutine.
+func panicError(r any) error {
+	return withCode("E_PANIC", fmt.Errorf("panic: %v\n%s", r, debug.Stack()))
+}
+
+type sentryReporter struct{}
+
+func newSentryReporter(dsn string) (*sentryReporter, error) {
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:              dsn,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sentryReporter{}, nil
+}
+
+func (s *sentryReporter) Report(err error, tags map[string]string) error {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+		sentry.CaptureException(err)
+	})
+
+	// Failures are rare and may be followed by a crash, so deliver them
+	// right away instead of relying on the background transport.
+	sentry.Flush(5 * time.Second)
+
+	return nil
+}
+
+type webhookReporter struct {
+	url string
+}
+
+func (w *webhookReporter) Report(err error, tags map[string]string) error {
+	return postJSON(w.url, map[string]any{
+		"error":     err.Error(),
+		"tags":      tags,
+		"timestamp": time.Now().UTC(),
+	})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// estimate is what a release of a profile would do, found without touching
+// the destination.
+type estimate struct {
+	Files int
+	Bytes int64
+
+	// Changed files differ from the destination in size or modification
+	// time.
+	Changed      int
+	ChangedBytes int64
+
+	// Deleted are the destination files that are not in the source,
+	// relative to the destination.
+	Deleted []string
+}
+
+// estimateRelease scans srcDir and the destination of p like a release
+// would.
+func estimateRelease(p *profile, srcDir string) (*estimate, error) {
+	e := &estimate{}
+
+	seeded, err := loadSeeded(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	skipSeed := func(dst string) bool {
+		skip, _ := p.skipSeed(dst, seeded)
+		return skip
+	}
+
+	var mu sync.Mutex
+	now := time.Now()
+	err = walkConcurrent(srcDir, jobConcurrency(srcDir, p.dstDir).Scan, func(path string, d fs.DirEntry) error {
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(p.dstDir, p.normalizeName(rel))
+
+		if p.isKeepFile(dst) || p.isExcluded(srcDir, path) || skipSeed(dst) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		} else if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		} else if p.skipReason(srcDir, path, info, now) != "" {
+			return nil
+		}
+
+		dstInfo, err := os.Stat(dst)
+		changed := err != nil || dstInfo.Size() != info.Size() || !dstInfo.ModTime().Equal(info.ModTime())
+
+		mu.Lock()
+		defer mu.Unlock()
+		e.Files++
+		e.Bytes += info.Size()
+		if changed {
+			e.Changed++
+			e.ChangedBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, e.Deleted, err = countDeletions(p, srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// previewSource returns the directory an estimate of p reads the source
+// from. Git and artifact sources are fetched into a directory of their own,
+// as fetching replaces the source a running release may be reading. cleanup
+// removes it again.
+func previewSource(p *profile) (dir string, cleanup func(), err error) {
+	fetchArtifact := p.Artifact != nil && !strings.Contains(p.Artifact.URL, "{build}")
+	if p.Git == nil && !fetchArtifact {
+		return p.srcDir, func() {}, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(p.srcDir), 0755)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err = os.MkdirTemp(filepath.Dir(p.srcDir), p.Name+"-estimate-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			log.Printf("Error removing %s: %s", dir, err)
+		}
+	}
+
+	if p.Git != nil {
+		_, err = p.Git.checkout(dir)
+	} else {
+		err = p.Artifact.fetch(dir, "", "", "")
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return dir, cleanup, nil
+}
+
+// countDeletions returns how many destination files of p a release from
+// srcDir could delete, and the paths of those that are not in the source and
+// would be gone for good, in order.
+func countDeletions(p *profile, srcDir string) (total int, deleted []string, err error) {
+	var mu sync.Mutex
+	err = walkConcurrent(p.dstDir, jobConcurrency(p.dstDir, srcDir).Scan, func(path string, d fs.DirEntry) error {
+		if p.isPreserved(path) || p.isExcluded(p.dstDir, path) || p.isMerged(path) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		} else if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(p.dstDir, path)
+		if err != nil {
+			return err
+		}
+		_, err = os.Lstat(filepath.Join(srcDir, p.sourceName(srcDir, rel)))
+
+		mu.Lock()
+		defer mu.Unlock()
+		total++
+		if os.IsNotExist(err) {
+			deleted = append(deleted, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(deleted)
+	return total, deleted, err
+}
+
+// maxDeletionSamples is the number of paths shown for each top-level
+// directory in the destination-only files of an estimate, and
+// maxDeletionsLength the length of the whole list, which stays below the
+// 1024 characters Discord allows per field.
+const (
+	maxDeletionSamples = 3
+	maxDeletionsLength = 1000
+)
+
+// deletionsField lists destination-only files grouped by their top-level
+// directory, so that a release about to wipe something like player data
+// stands out before it runs.
+func deletionsField(deleted []string) NotificationField {
+	groups := []string{}
+	byGroup := map[string][]string{}
+	for _, path := range deleted {
+		group, _, ok := strings.Cut(path, "/")
+		if !ok {
+			group = "."
+		}
+		if _, seen := byGroup[group]; !seen {
+			groups = append(groups, group)
+		}
+		byGroup[group] = append(byGroup[group], path)
+	}
+
+	var b strings.Builder
+	for i, group := range groups {
+		var g strings.Builder
+		paths := byGroup[group]
+		fmt.Fprintf(&g, "%s/ (%d)\n", group, len(paths))
+		for _, path := range paths[:min(len(paths), maxDeletionSamples)] {
+			fmt.Fprintf(&g, "  %s\n", path)
+		}
+		if len(paths) > maxDeletionSamples {
+			fmt.Fprintf(&g, "  ...and %d more\n", len(paths)-maxDeletionSamples)
+		}
+
+		if b.Len()+g.Len() > maxDeletionsLength-50 {
+			fmt.Fprintf(&b, "...and %d more directories\n", len(groups)-i)
+			break
+		}
+		b.WriteString(g.String())
+	}
+
+	return NotificationField{
+		Name:  "Destination-only Files",
+		Value: fmt.Sprintf("```\n%s```", b.String()),
+	}
+}
+
+// predictDuration estimates how long copying bytes takes for profile from
+// the average throughput of its past releases. It returns zero if there is
+// no history to go by.
+func predictDuration(profile string, bytes int64) time.Duration {
+	rate := historicalRate(profile)
+	if rate == 0 {
+		return 0
+	}
+
+	return time.Duration(float64(bytes) / rate * float64(time.Second))
+}
+
+func handleEstimate(ctx CommandContext) {
+	p := selectProfile(ctx)
+	if p == nil {
+		return
+	}
+	p = p.target()
+
+	reply, err := ctx.Reply(&Notification{
+		Color:       0xffff00,
+		Description: "Scanning server files...",
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+
+	n := &Notification{}
+	var e *estimate
+	srcDir, cleanup, err := previewSource(p)
+	if err == nil {
+		e, err = estimateRelease(p, srcDir)
+		cleanup()
+	}
+	if err != nil {
+		log.Printf("Error estimating release of %s: %s", p.Name, err)
+		n.Color = 0xff0000
+		n.Description = fmt.Sprintf(":x: Failed to scan server files!\n```\n%s\n```", err)
+	} else {
+		n.Color = 0x87ceeb
+		n.Title = fmt.Sprintf("Estimate for %s", p.Name)
+		n.Fields, n.Files = estimateFields(p, e)
+	}
+
+	if reply == nil {
+		_, err = ctx.Reply(n)
+	} else {
+		err = reply.Edit(n)
+	}
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+// estimateFields describes what a release of p would do according to e, with
+// the full list of destination-only files attached.
+func estimateFields(p *profile, e *estimate) ([]NotificationField, []NotificationFile) {
+	fields := []NotificationField{
+		{
+			Name:  "To Copy",
+			Value: fmt.Sprintf("%d files (%s)", e.Files, formatBytes(e.Bytes)),
+		},
+		{
+			Name:  "Changed",
+			Value: fmt.Sprintf("%d files (%s)", e.Changed, formatBytes(e.ChangedBytes)),
+		},
+		{
+			Name:  "To Delete",
+			Value: fmt.Sprintf("%d files", len(e.Deleted)),
+		},
+	}
+	files := []NotificationFile{}
+	if len(e.Deleted) > 0 {
+		fields = append(fields, deletionsField(e.Deleted))
+		files = append(files, NotificationFile{
+			Name: "deletions.txt",
+			Data: []byte(strings.Join(e.Deleted, "\n") + "\n"),
+		})
+	}
+
+	duration := "No past releases of this profile to go by."
+	if d := predictDuration(p.Name, e.Bytes); d > 0 {
+		duration = fmt.Sprintf("About %s", d.Round(time.Second))
+	}
+	fields = append(fields, NotificationField{
+		Name:  "Predicted Duration",
+		Value: duration,
+	})
+
+	return fields, files
+}
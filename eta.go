@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// minRateSample is how long the copy must have run before its own
+// throughput is trusted without any history to go by.
+const minRateSample = 10 * time.Second
+
+// historicalRate returns the average copy rate of the past successful
+// releases of profile in bytes per second, or zero if there are none. Only
+// the copy phase counts, as the rest of a release takes about as long
+// whatever its size.
+func historicalRate(profile string) float64 {
+	records, err := loadHistory()
+	if err != nil {
+		log.Printf("Error loading job history: %s", err)
+		return 0
+	}
+
+	var rate float64
+	var runs int
+	for _, r := range records {
+		if r.Success && r.Profile == profile && r.copyRate() > 0 {
+			rate += r.copyRate()
+			runs++
+		}
+	}
+	if runs == 0 {
+		return 0
+	}
+
+	return rate / float64(runs)
+}
+
+// eta estimates how long the copy of j has left. It starts from the
+// historical throughput of the profile and shifts towards the throughput
+// measured so far as more of the data has been copied, so the estimate
+// does not jump around at the start. It returns zero if there is nothing to
+// go by yet or while j is paused. j.mu must be held.
+func (j *job) eta() time.Duration {
+	if j.Phase != "copy" || j.TotalBytes == 0 || j.paused != nil {
+		return 0
+	}
+
+	elapsed := time.Since(j.phaseStarted) - j.pausedFor
+	done := float64(j.Bytes) / float64(j.TotalBytes)
+	var measured float64
+	if elapsed > 0 {
+		measured = float64(j.Bytes) / elapsed.Seconds()
+	}
+
+	var rate float64
+	switch {
+	case j.historicalRate > 0 && measured > 0:
+		rate = (1-done)*j.historicalRate + done*measured
+	case j.historicalRate > 0:
+		rate = j.historicalRate
+	case elapsed >= minRateSample:
+		rate = measured
+	}
+	if rate <= 0 {
+		return 0
+	}
+
+	remaining := float64(j.TotalBytes - j.Bytes)
+	return time.Duration(remaining / rate * float64(time.Second))
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EventType is the kind of progress event a job emits.
+type EventType string
+
+const (
+	EventStarted     EventType = "started"
+	EventPhase       EventType = "phase"
+	EventFileCopied  EventType = "file_copied"
+	EventFileDeleted EventType = "file_deleted"
+	EventDelta       EventType = "delta"
+	EventWarning     EventType = "warning"
+	EventPaused      EventType = "paused"
+	EventResumed     EventType = "resumed"
+	EventFinished    EventType = "finished"
+)
+
+// Event is a single step in the progress of a job.
+type Event struct {
+	Type    EventType `json:"type"`
+	Job     string    `json:"job"`
+	Profile string    `json:"profile"`
+	Time    time.Time `json:"time"`
+
+	// Phase is set for phase events.
+	Phase string `json:"phase,omitempty"`
+
+	// Path is set for copied and deleted files and Bytes for copied files.
+	// Delta events only carry the bytes delta syncing did not have to
+	// rewrite.
+	Path  string `json:"path,omitempty"`
+	Bytes int64  `json:"bytes,omitempty"`
+
+	// Message is the warning, or the error a job failed with.
+	Message string `json:"message,omitempty"`
+	Success bool   `json:"success,omitempty"`
+}
+
+// EventSink receives the progress events of jobs. Handle is called from the
+// goroutine doing the work, possibly from several at once.
+type EventSink interface {
+	Handle(j *job, e *Event)
+}
+
+// eventSinks receive the events of every job.
+var eventSinks = []EventSink{&logSink{}}
+
+func (j *job) emit(e Event) {
+	e.Job = j.ID
+	e.Profile = j.Profile.Name
+	e.Time = time.Now()
+
+	j.mu.Lock()
+	sinks := append(append([]EventSink{}, eventSinks...), j.sinks...)
+	j.mu.Unlock()
+
+	for _, sink := range sinks {
+		sink.Handle(j, &e)
+	}
+}
+
+// stoppingSink is implemented by sinks running until the job finishes.
+type stoppingSink interface {
+	EventSink
+	stop()
+}
+
+// addSink subscribes sink to the events of j only. Sinks added once j has
+// finished miss its finished event, so they are stopped right away.
+func (j *job) addSink(sink EventSink) {
+	j.mu.Lock()
+	done := j.Done
+	if !done {
+		j.sinks = append(j.sinks, sink)
+	}
+	j.mu.Unlock()
+
+	if s, ok := sink.(stoppingSink); ok && done {
+		s.stop()
+	}
+}
+
+// startPhase starts the span of a phase of the release and lets the sinks
+// know that it began.
+func (j *job) startPhase(ctx context.Context, name string) (context.Context, trace.Span) {
+	j.mu.Lock()
+	j.Phase = name
+	j.phaseStarted = time.Now()
+	j.mu.Unlock()
+
+	j.emit(Event{Type: EventPhase, Phase: name})
+	return tracer.Start(ctx, name)
+}
+
+// progress is a consistent view of how far a job has got.
+type progress struct {
+	Phase      string
+	Files      int
+	TotalFiles int
+	Bytes      int64
+	TotalBytes int64
+
+	// Dirs lists the progress of the top-level directories while copying.
+	Dirs string
+
+	// ETA is the estimated time left to copy, or zero if unknown.
+	ETA time.Duration
+
+	Paused bool
+}
+
+func (j *job) progress() progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	p := progress{
+		Phase:      j.Phase,
+		Files:      j.Files,
+		TotalFiles: j.TotalFiles,
+		Bytes:      j.Bytes,
+		TotalBytes: j.TotalBytes,
+		Paused:     j.paused != nil,
+	}
+	if j.Phase == "copy" {
+		p.Dirs = j.dirsString()
+		p.ETA = j.eta()
+	}
+	return p
+}
+
+func (p progress) String() string {
+	phase := p.Phase
+	if p.Paused {
+		phase += " (paused)"
+	}
+
+	if p.TotalFiles == 0 {
+		return fmt.Sprintf("Phase: %s", phase)
+	}
+	s := fmt.Sprintf("Phase: %s\n%d/%d files (%s/%s)", phase, p.Files, p.TotalFiles, formatBytes(p.Bytes), formatBytes(p.TotalBytes))
+	if p.ETA > 0 {
+		s += fmt.Sprintf(", about %s left", p.ETA.Round(time.Second))
+	}
+	if p.Dirs != "" {
+		s += "\n" + p.Dirs
+	}
+	return s
+}
+
+// logSink writes phase changes and warnings to the log.
+type logSink struct{}
+
+func (s *logSink) Handle(j *job, e *Event) {
+	switch e.Type {
+	case EventPhase:
+		j.logf("Phase: %s", e.Phase)
+	case EventWarning:
+		j.logf("Warning: %s", e.Message)
+	case EventPaused:
+		j.logf("Paused")
+	case EventResumed:
+		j.logf("Resumed")
+	}
+}
+
+// replyInterval is how often a reply is edited to show progress at most.
+const replyInterval = 5 * time.Second
+
+// replySink keeps a field of a chat reply up to date with the progress of a
+// job until it finishes. Edits are made by a goroutine of its own, so a slow
+// chat service does not hold up the workers emitting events.
+type replySink struct {
+	reply Reply
+	n     *Notification
+
+	mu     sync.Mutex
+	edited time.Time
+	done   bool
+
+	// pending is the latest state of the reply not edited in yet, and job
+	// the job it shows.
+	pending *Notification
+	job     *job
+
+	// wake tells run about a pending edit, and stopped is closed once run
+	// has returned.
+	wake    chan struct{}
+	stopped chan struct{}
+}
+
+// newReplySink edits reply, which was sent as n. The sink works on a copy of
+// n, so the caller remains free to change n for the final edit.
+func newReplySink(reply Reply, n *Notification) *replySink {
+	own := *n
+	own.Fields = append([]NotificationField{}, n.Fields...)
+	s := &replySink{reply: reply, n: &own, wake: make(chan struct{}, 1), stopped: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+// run edits the reply whenever Handle left a pending state, until the job
+// finishes.
+func (s *replySink) run() {
+	defer close(s.stopped)
+
+	for range s.wake {
+		s.mu.Lock()
+		n, j := s.pending, s.job
+		s.pending = nil
+		if s.done {
+			n = nil
+		}
+		s.mu.Unlock()
+
+		if n == nil {
+			continue
+		}
+		err := s.reply.Edit(n)
+		if err != nil {
+			j.logf("Error editing reply: %s", err)
+		}
+	}
+}
+
+// stop ends run. It waits for an edit in flight, so it cannot overwrite the
+// final state of the reply edited in once the job is done.
+func (s *replySink) stop() {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.mu.Unlock()
+
+	close(s.wake)
+	<-s.stopped
+}
+
+func (s *replySink) Handle(j *job, e *Event) {
+	if e.Type == EventFinished {
+		s.stop()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+
+	switch e.Type {
+	case EventPhase:
+	case EventPaused:
+		s.swapAction("pause:"+j.ID, NotificationAction{Label: "Resume", ID: "resume:" + j.ID})
+	case EventResumed:
+		s.swapAction("resume:"+j.ID, NotificationAction{Label: "Pause", ID: "pause:" + j.ID})
+	default:
+		if time.Since(s.edited) < replyInterval {
+			return
+		}
+	}
+	s.edited = time.Now()
+
+	field := NotificationField{Name: "Progress", Value: j.progress().String()}
+	if last := len(s.n.Fields) - 1; last >= 0 && s.n.Fields[last].Name == field.Name {
+		s.n.Fields[last] = field
+	} else {
+		s.n.Fields = append(s.n.Fields, field)
+	}
+
+	pending := *s.n
+	pending.Fields = append([]NotificationField{}, s.n.Fields...)
+	s.pending, s.job = &pending, j
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// swapAction replaces the button with the given ID. s.mu must be held.
+func (s *replySink) swapAction(id string, action NotificationAction) {
+	actions := append([]NotificationAction{}, s.n.Actions...)
+	for i, a := range actions {
+		if a.ID == id {
+			actions[i] = action
+		}
+	}
+	s.n.Actions = actions
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// fdsPerCopy is the most files copying a single file holds open: the
+	// source, the destination or its temporary file, and the directory it
+	// syncs.
+	fdsPerCopy = 3
+
+	// fdReserve are the open files left out of the copy budget for logs,
+	// directory reads and connections to the panel and chat services.
+	fdReserve = 128
+
+	// fdRetryDelay is the wait before copying a file again after running
+	// out of open files, doubled after every further attempt up to
+	// maxFdRetryDelay. A copy fails once it waited for maxFdWait in total.
+	fdRetryDelay    = 100 * time.Millisecond
+	maxFdRetryDelay = 5 * time.Second
+	maxFdWait       = 2 * time.Minute
+)
+
+// maxOpenFiles overrides the open file limit of the process the copy budget
+// is taken from; zero means the limit is looked up.
+var maxOpenFiles int
+
+var (
+	copySlotsOnce sync.Once
+	copySlots     chan struct{}
+)
+
+// openFileSlots returns the semaphore bounding how many files all jobs copy
+// at once, so that high copy concurrency or several jobs running together
+// stay below the open file limit.
+func openFileSlots() chan struct{} {
+	copySlotsOnce.Do(func() {
+		limit := maxOpenFiles
+		if limit == 0 {
+			limit = openFileLimit()
+		}
+
+		slots := max((limit-fdReserve)/fdsPerCopy, 1)
+		log.Printf("Copying at most %d files at once to stay below %d open files", slots, limit)
+		copySlots = make(chan struct{}, slots)
+	})
+	return copySlots
+}
+
+// tooManyOpenFiles reports whether err is caused by the process or the
+// system running out of file descriptors.
+func tooManyOpenFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// withOpenFiles runs fn, which copies a single file, once the copy budget
+// allows it. If fn runs out of file descriptors anyway, as other parts of
+// the process hold more than expected, it is run again after a backoff
+// instead of failing the job.
+func (j *job) withOpenFiles(fn func() error) error {
+	slots := openFileSlots()
+	delay, waited := fdRetryDelay, time.Duration(0)
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-j.ctx.Done():
+			return j.canceled()
+		}
+		err := fn()
+		<-slots
+
+		if !tooManyOpenFiles(err) || waited >= maxFdWait {
+			return err
+		}
+
+		j.logf("Out of open files, retrying in %s: %s", delay, err)
+		select {
+		case <-j.ctx.Done():
+			return j.canceled()
+		case <-time.After(delay):
+		}
+		waited += delay
+		delay = min(2*delay, maxFdRetryDelay)
+	}
+}
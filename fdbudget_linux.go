@@ -0,0 +1,18 @@
+package main
+
+import (
+	"math"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFileLimit returns the soft limit on open files of the process, which
+// Go raises to the hard limit on start.
+func openFileLimit() int {
+	var r unix.Rlimit
+	err := unix.Getrlimit(unix.RLIMIT_NOFILE, &r)
+	if err != nil {
+		return 1024
+	}
+	return int(min(r.Cur, math.MaxInt32))
+}
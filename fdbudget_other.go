@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func openFileLimit() int {
+	return 256
+}
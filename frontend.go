@@ -0,0 +1,98 @@
+package main
+
+import "errors"
+
+// Frontend is a chat service users can trigger commands from.
+type Frontend interface {
+	Open() error
+	RegisterCommands(cmds []*Command) error
+	Close() error
+}
+
+type Command struct {
+	Name        string
+	Description string
+	Options     []*CommandOption
+	Handler     HandlerFunc
+
+	// Subcommands are invoked as "/<name> <subcommand>" and routed by both
+	// names. A command with subcommands has no options or handler itself.
+	Subcommands []*Command
+}
+
+type OptionType int
+
+const (
+	OptionString OptionType = iota
+	OptionBool
+	OptionInteger
+
+	// OptionAttachment is a file, whose option value is a URL to download
+	// it from.
+	OptionAttachment
+)
+
+type CommandOption struct {
+	Name        string
+	Description string
+	Type        OptionType
+	Required    bool
+}
+
+// CommandContext is a single invocation of a command on a frontend.
+type CommandContext interface {
+	// Reply responds to the invocation. The returned reply can be edited
+	// afterwards to report progress.
+	Reply(n *Notification) (Reply, error)
+
+	// Notifier returns a notifier posting to where the command was invoked.
+	Notifier() Notifier
+
+	// Option returns the value of the named option, or an empty string if
+	// it was not given. Boolean options are "true" or "false". The choice
+	// made in a select menu is the "value" option, and the fields of a
+	// submitted form are options by name.
+	Option(name string) string
+
+	// User identifies who invoked the command on the frontend.
+	User() string
+
+	// ShowForm responds by asking the user to fill in f. It returns
+	// errFormsUnsupported if the frontend has no forms.
+	ShowForm(f *Form) error
+
+	// Update responds to a component by replacing the message it is on.
+	// Elsewhere it replies instead.
+	Update(n *Notification) error
+}
+
+// errFormsUnsupported is returned by frontends that cannot show forms.
+var errFormsUnsupported = errors.New("forms are not supported by this frontend")
+
+// Form asks the user for text, such as a Discord modal. The submission is
+// routed to the component named by the prefix of ID.
+type Form struct {
+	ID     string
+	Title  string
+	Fields []FormField
+}
+
+type FormField struct {
+	Name        string
+	Label       string
+	Placeholder string
+	Value       string
+	Required    bool
+}
+
+func boolOption(ctx CommandContext, name string) bool {
+	return ctx.Option(name) == "true"
+}
+
+type Reply interface {
+	Edit(n *Notification) error
+
+	// Links returns web links to the reply and to the channel or thread it
+	// was sent in, empty where unknown.
+	Links() (message string, channel string)
+}
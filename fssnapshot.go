@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sourceSnapshot reads the source from a read-only filesystem snapshot taken
+// at the start of the release, so the source server can keep running without
+// the copy seeing files it is halfway through writing.
+type sourceSnapshot struct {
+	// Type is btrfs, zfs or lvm.
+	Type string `yaml:"type"`
+
+	// Subvolume is the btrfs subvolume containing the source. It defaults
+	// to the source directory itself.
+	Subvolume string `yaml:"subvolume"`
+
+	// Dataset is the ZFS dataset containing the source.
+	Dataset string `yaml:"dataset"`
+
+	// Volume is the LVM logical volume containing the source as vg/lv,
+	// mounted at MountPoint. Size is reserved for changes to the origin
+	// while the snapshot exists.
+	Volume       string `yaml:"volume"`
+	MountPoint   string `yaml:"mount_point"`
+	Size         string `yaml:"size"`
+	MountOptions string `yaml:"mount_options"`
+}
+
+func (s *sourceSnapshot) init() error {
+	switch s.Type {
+	case "btrfs":
+	case "zfs":
+		if s.Dataset == "" {
+			return fmt.Errorf("source_snapshot.dataset: required for zfs")
+		}
+	case "lvm":
+		if s.Volume == "" || s.MountPoint == "" {
+			return fmt.Errorf("source_snapshot: volume and mount_point are required for lvm")
+		}
+		if s.Size == "" {
+			s.Size = "1G"
+		}
+		if s.MountOptions == "" {
+			s.MountOptions = "ro"
+		}
+	default:
+		return fmt.Errorf("source_snapshot.type: unknown type %q", s.Type)
+	}
+
+	return nil
+}
+
+// take snapshots the volume containing srcDir and returns where srcDir can be
+// read from in the snapshot, along with a function removing the snapshot.
+func (s *sourceSnapshot) take(j *job, srcDir string) (string, func() error, error) {
+	name := "releaser-" + j.ID
+
+	switch s.Type {
+	case "btrfs":
+		subvolume := s.Subvolume
+		if subvolume == "" {
+			subvolume = srcDir
+		}
+		rel, err := filepath.Rel(subvolume, srcDir)
+		if err != nil {
+			return "", nil, err
+		}
+
+		snapshot := filepath.Join(filepath.Dir(subvolume), "."+name)
+		_, err = runTool("btrfs", "subvolume", "snapshot", "-r", subvolume, snapshot)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return filepath.Join(snapshot, rel), func() error {
+			_, err := runTool("btrfs", "subvolume", "delete", snapshot)
+			return err
+		}, nil
+
+	case "zfs":
+		mountPoint, err := runTool("zfs", "get", "-H", "-o", "value", "mountpoint", s.Dataset)
+		if err != nil {
+			return "", nil, err
+		}
+		rel, err := filepath.Rel(mountPoint, srcDir)
+		if err != nil {
+			return "", nil, err
+		}
+
+		snapshot := s.Dataset + "@" + name
+		_, err = runTool("zfs", "snapshot", snapshot)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return filepath.Join(mountPoint, ".zfs", "snapshot", name, rel), func() error {
+			_, err := runTool("zfs", "destroy", snapshot)
+			return err
+		}, nil
+
+	default:
+		rel, err := filepath.Rel(s.MountPoint, srcDir)
+		if err != nil {
+			return "", nil, err
+		}
+
+		vg, _, _ := strings.Cut(s.Volume, "/")
+		_, err = runTool("lvcreate", "--snapshot", "--name", name, "--size", s.Size, s.Volume)
+		if err != nil {
+			return "", nil, err
+		}
+		remove := func() error {
+			_, err := runTool("lvremove", "--force", vg+"/"+name)
+			return err
+		}
+
+		mnt := filepath.Join(dataDir, "mnt", name)
+		err = os.MkdirAll(mnt, 0755)
+		if err == nil {
+			_, err = runTool("mount", "-o", s.MountOptions, filepath.Join("/dev", vg, name), mnt)
+		}
+		if err != nil {
+			remove()
+			return "", nil, err
+		}
+
+		return filepath.Join(mnt, rel), func() error {
+			_, err := runTool("umount", mnt)
+			if err != nil {
+				return err
+			}
+			os.Remove(mnt)
+			return remove()
+		}, nil
+	}
+}
+
+func runTool(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", name, args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
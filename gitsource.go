@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitSource is a git repository released instead of a source server.
+type gitSource struct {
+	URL string `yaml:"url"`
+	Ref string `yaml:"ref"`
+}
+
+// gitMu serializes checkouts, as jobs of the same profile share one.
+var gitMu sync.Mutex
+
+func (g *gitSource) init() error {
+	if g.URL == "" {
+		return fmt.Errorf("git.url: no repository URL found")
+	} else if strings.HasPrefix(g.URL, "-") {
+		return fmt.Errorf("git.url must not start with -")
+	}
+
+	if g.Ref == "" {
+		g.Ref = "HEAD"
+	} else if strings.HasPrefix(g.Ref, "-") {
+		return fmt.Errorf("git.ref must not start with -")
+	}
+
+	return nil
+}
+
+func (g *gitSource) String() string {
+	return fmt.Sprintf("%s#%s", g.URL, g.Ref)
+}
+
+// gitCacheDir is where the checkout of the git source of a profile is kept.
+func gitCacheDir(name string) string {
+	return filepath.Join(dataDir, "git", name)
+}
+
+// checkout updates the checkout in dir to the latest commit of the ref and
+// returns its hash.
+func (g *gitSource) checkout(dir string) (string, error) {
+	gitMu.Lock()
+	defer gitMu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = runGit(dir, "init", "--quiet")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	steps := [][]string{
+		{"fetch", "--quiet", "--depth=1", "--force", "--", g.URL, g.Ref},
+		{"checkout", "--quiet", "--force", "FETCH_HEAD"},
+		{"clean", "--quiet", "-ffdx"},
+	}
+	for _, args := range steps {
+		_, err := runGit(dir, args...)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return runGit(dir, "rev-parse", "HEAD")
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
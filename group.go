@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+func groupNames() string {
+	names := []string{}
+	for name := range groups {
+		names = append(names, fmt.Sprintf("`%s`", name))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// groupRelease is a release of every profile in a group.
+type groupRelease struct {
+	Name   string
+	Jobs   []*job
+	Status []string
+}
+
+func (g *groupRelease) notification() *Notification {
+	n := &Notification{
+		Color: 0xffff00,
+		Title: fmt.Sprintf("Releasing group %s...", g.Name),
+	}
+	for i, j := range g.Jobs {
+		n.Fields = append(n.Fields, NotificationField{
+			Name:  fmt.Sprintf("%s (job `%s`)", j.Profile.Name, j.ID),
+			Value: g.Status[i],
+		})
+	}
+	return n
+}
+
+func handleReleaseAll(ctx CommandContext) {
+	name := ctx.Option("group")
+	members, ok := groups[name]
+	if !ok {
+		replyError(ctx, "Unknown group `%s`! Available groups: %s", name, groupNames())
+		return
+	}
+
+	// Profiles can be imported and removed while the bot runs, so members
+	// are looked up once.
+	targets := make([]*profile, len(members))
+	for i, member := range members {
+		p := findProfile(member)
+		if p == nil || !canSee(ctx, p) {
+			replyError(ctx, "Group `%s` includes profile `%s`, which is not available here!", name, member)
+			return
+		}
+		targets[i] = p.target()
+	}
+
+	wiped := []*profile{}
+	for _, p := range targets {
+		if !p.dryRun(ctx.Option("dry-run")) && p.keepsNothing() {
+			wiped = append(wiped, p)
+		}
+	}
+	if len(wiped) > 0 && !boolOption(ctx, wipeOption.Name) {
+		confirmWipe(ctx, "release-all", wiped, handleReleaseAll)
+		return
+	}
+
+	// Every member is checked before any job is created, as jobs left
+	// behind by a refused release would never run.
+	for _, p := range targets {
+		dryRun := p.dryRun(ctx.Option("dry-run"))
+		if !dryRun && !authorizeRelease(ctx, p) {
+			return
+		}
+		if reason := detectRunningServer(p.dstDir); reason != "" && !boolOption(ctx, "force") && !dryRun {
+			replyError(ctx, "The destination of `%s` appears to be running: %s.\nStop the server before copying, or use the `force` option to copy anyway.", p.Name, reason)
+			return
+		}
+	}
+
+	g := &groupRelease{Name: name}
+	index := map[string]int{}
+	for i, member := range members {
+		p := targets[i]
+		dryRun := p.dryRun(ctx.Option("dry-run"))
+		j := newJob(p, true)
+		j.attribute(ctx.User())
+		j.DryRun = dryRun
+		g.Jobs = append(g.Jobs, j)
+		g.Status = append(g.Status, ":hourglass: Copying...")
+		index[member] = i
+	}
+
+	// Dependencies outside the group are not released and do not hold
+	// anything up.
+	deps := make([][]int, len(g.Jobs))
+	for i, j := range g.Jobs {
+		waiting := []string{}
+		for _, dep := range j.Profile.DependsOn {
+			if d, ok := index[dep]; ok {
+				deps[i] = append(deps[i], d)
+				waiting = append(waiting, fmt.Sprintf("`%s`", dep))
+			}
+		}
+		if len(waiting) > 0 {
+			g.Status[i] = fmt.Sprintf(":hourglass: Waiting for %s", strings.Join(waiting, ", "))
+		}
+	}
+
+	type event struct {
+		index    int
+		started  bool
+		success  bool
+		upstream string
+	}
+	events := make(chan event)
+	finished := make([]chan struct{}, len(g.Jobs))
+	succeeded := make([]bool, len(g.Jobs))
+	for i := range g.Jobs {
+		finished[i] = make(chan struct{})
+	}
+
+	for i, j := range g.Jobs {
+		go func(i int, j *job) {
+			defer close(finished[i])
+
+			for _, d := range deps[i] {
+				<-finished[d]
+				if !succeeded[d] {
+					upstream := g.Jobs[d].Profile.Name
+					j.Err = withCode("E_UPSTREAM", fmt.Errorf("skipped because %s failed", upstream))
+					j.logf("Skipping: %s", j.Err)
+					saveRecord(j, false)
+					j.finish()
+					j.emit(Event{Type: EventFinished, Message: j.Err.Error()})
+					events <- event{index: i, upstream: upstream}
+					return
+				}
+			}
+
+			// Jobs waiting for others only start now.
+			j.mu.Lock()
+			j.StartedAt = time.Now()
+			j.mu.Unlock()
+			events <- event{index: i, started: true}
+			ch := make(chan bool)
+			go copy(j, ch)
+			succeeded[i] = <-ch
+			events <- event{index: i, success: succeeded[i]}
+		}(i, j)
+	}
+
+	reply, err := ctx.Reply(g.notification())
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	} else {
+		message, channel := reply.Links()
+		for _, j := range g.Jobs {
+			j.link(message, channel)
+		}
+	}
+	notifyAll(notifiers, g.notification())
+
+	failed := 0
+	for remaining := len(g.Jobs); remaining > 0; {
+		e := <-events
+		j := g.Jobs[e.index]
+		switch {
+		case e.started:
+			g.Status[e.index] = ":hourglass: Copying..."
+		case e.upstream != "":
+			remaining--
+			failed++
+			g.Status[e.index] = fmt.Sprintf(":x: Skipped because `%s` failed", e.upstream)
+		case e.success && j.DryRun && j.Plan != nil:
+			remaining--
+			g.Status[e.index] = fmt.Sprintf(":mag: Would copy %d changed files (%s) and delete %d files", j.Plan.Changed, formatBytes(j.Plan.ChangedBytes), len(j.Plan.Deleted))
+		case e.success:
+			remaining--
+			g.Status[e.index] = fmt.Sprintf(":white_check_mark: Copied %d files (%s) in %s", j.Files, formatBytes(j.Bytes), time.Since(j.StartedAt).Round(time.Second))
+		default:
+			remaining--
+			failed++
+			g.Status[e.index] = fmt.Sprintf(":x: %s", firstLine(j.Err))
+			if j.RolledBack {
+				g.Status[e.index] += "\n:leftwards_arrow_with_hook: Rolled back"
+			}
+		}
+
+		if reply != nil {
+			err := reply.Edit(g.notification())
+			if err != nil {
+				log.Printf("Error editing reply: %s", err)
+			}
+		}
+	}
+
+	done := g.notification()
+	if failed == 0 {
+		done.Color = 0x00ff00
+		done.Title = fmt.Sprintf("Released group %s", name)
+		done.Description = fmt.Sprintf(":white_check_mark: All %d profiles have been copied!", len(g.Jobs))
+	} else {
+		done.Color = 0xff0000
+		done.Title = fmt.Sprintf("Failed to release group %s", name)
+		done.Description = fmt.Sprintf(":x: %d of %d profiles have failed!", failed, len(g.Jobs))
+	}
+
+	if reply != nil {
+		err = reply.Edit(done)
+		if err != nil {
+			log.Printf("Error editing reply: %s", err)
+		}
+	}
+	notifyAll(append([]Notifier{ctx.Notifier()}, notifiers...), done)
+}
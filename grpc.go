@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/legacyofvaliant/releaser/releaserpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcScopes are the scopes of the gRPC methods that need more than read.
+var grpcScopes = map[string]string{
+	releaserpb.Releaser_StartJob_FullMethodName:  scopeCopy,
+	releaserpb.Releaser_CancelJob_FullMethodName: scopeCopy,
+}
+
+// serveGRPC serves the gRPC API on addr.
+func serveGRPC(addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Error listening for gRPC: %s", err)
+	}
+
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			err := grpcAuthorize(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			err := grpcAuthorize(ss.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	releaserpb.RegisterReleaserServer(s, &grpcServer{})
+
+	err = s.Serve(lis)
+	if err != nil {
+		log.Fatalf("Error serving gRPC API: %s", err)
+	}
+}
+
+// grpcAuthorize checks the bearer token in the authorization metadata of a
+// call against the scope of its method.
+func grpcAuthorize(ctx context.Context, method string) error {
+	var token string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		token, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+
+	scope, ok := grpcScopes[method]
+	if !ok {
+		scope = scopeRead
+	}
+
+	err := auth.allow(token, scope)
+	if errors.Is(err, errUnauthenticated) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	} else if err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return nil
+}
+
+type grpcServer struct {
+	releaserpb.UnimplementedReleaserServer
+}
+
+func (s *grpcServer) ListProfiles(ctx context.Context, req *releaserpb.ListProfilesRequest) (*releaserpb.ListProfilesResponse, error) {
+	resp := &releaserpb.ListProfilesResponse{}
+	for _, p := range allProfiles() {
+		resp.Profiles = append(resp.Profiles, &releaserpb.Profile{
+			Name:        p.Name,
+			Source:      p.Source,
+			Destination: p.Destination,
+			Preset:      p.Preset,
+			Keep:        p.keep,
+			Exclude:     p.exclude,
+			Merge:       p.merge,
+		})
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) ListHistory(ctx context.Context, req *releaserpb.ListHistoryRequest) (*releaserpb.ListHistoryResponse, error) {
+	records, err := recentHistory()
+	if err != nil {
+		log.Printf("Error loading job history: %s", err)
+		return nil, status.Error(codes.Internal, "failed to load job history")
+	}
+
+	resp := &releaserpb.ListHistoryResponse{}
+	for _, r := range records {
+		resp.Records = append(resp.Records, &releaserpb.HistoryRecord{
+			Id:          r.ID,
+			Profile:     r.Profile,
+			Source:      r.Source,
+			Destination: r.Destination,
+			StartedAt:   timestamppb.New(r.StartedAt),
+			Duration:    durationpb.New(r.Duration),
+			Files:       int64(r.Files),
+			Bytes:       r.Bytes,
+			Warnings:    int64(r.Warnings),
+			Success:     r.Success,
+			Note:        r.Note,
+		})
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) ListJobs(ctx context.Context, req *releaserpb.ListJobsRequest) (*releaserpb.ListJobsResponse, error) {
+	resp := &releaserpb.ListJobsResponse{}
+	for _, j := range listJobs() {
+		resp.Jobs = append(resp.Jobs, jobMessage(j))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetJob(ctx context.Context, req *releaserpb.GetJobRequest) (*releaserpb.Job, error) {
+	j := findJob(req.Id)
+	if j == nil {
+		return nil, status.Error(codes.NotFound, "job not found")
+	}
+	return jobMessage(j), nil
+}
+
+func (s *grpcServer) StartJob(ctx context.Context, req *releaserpb.StartJobRequest) (*releaserpb.Job, error) {
+	if _, err := parsePriority(req.Priority); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	j, err := launchJob(&startJobRequest{
+		Profile:         req.Profile,
+		Force:           req.Force,
+		Note:            req.Note,
+		Priority:        req.Priority,
+		DryRun:          req.DryRun,
+		WipeDestination: req.WipeDestination,
+	}, nil)
+	if errors.Is(err, errUnknownProfile) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return jobMessage(j), nil
+}
+
+func (s *grpcServer) CancelJob(ctx context.Context, req *releaserpb.CancelJobRequest) (*releaserpb.Job, error) {
+	j := findJob(req.Id)
+	if j == nil {
+		return nil, status.Error(codes.NotFound, "job not found")
+	}
+	j.Cancel()
+	return jobMessage(j), nil
+}
+
+func (s *grpcServer) WatchJob(req *releaserpb.WatchJobRequest, stream releaserpb.Releaser_WatchJobServer) error {
+	j := findJob(req.Id)
+	if j == nil {
+		return status.Error(codes.NotFound, "job not found")
+	}
+
+	events, unsubscribe := hub.subscribe(j)
+	defer unsubscribe()
+	if events == nil {
+		return nil
+	}
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			err := stream.Send(&releaserpb.Event{
+				Type:    string(e.Type),
+				Job:     e.Job,
+				Profile: e.Profile,
+				Time:    timestamppb.New(e.Time),
+				Phase:   e.Phase,
+				Path:    e.Path,
+				Bytes:   e.Bytes,
+				Message: e.Message,
+				Success: e.Success,
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func jobMessage(j *job) *releaserpb.Job {
+	s := j.status()
+	return &releaserpb.Job{
+		Id:         s.ID,
+		Profile:    s.Profile,
+		Phase:      s.Phase,
+		Files:      int64(s.Files),
+		TotalFiles: int64(s.TotalFiles),
+		Bytes:      s.Bytes,
+		TotalBytes: s.TotalBytes,
+		Warnings:   s.Warnings,
+		Done:       s.Done,
+		Error:      s.Error,
+		ErrorCode:  s.ErrorCode,
+		Note:       s.Note,
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// handleHelp describes the commands, a single command with its options, or
+// the error codes, depending on the topic option.
+func handleHelp(ctx CommandContext) {
+	topic := strings.TrimPrefix(strings.TrimSpace(ctx.Option("topic")), "/")
+
+	var n *Notification
+	switch {
+	case topic == "":
+		n = commandsHelp()
+	case topic == "errors":
+		n = errorsHelp()
+	case findErrorCode(strings.ToUpper(topic)) != nil:
+		c := findErrorCode(strings.ToUpper(topic))
+		n = &Notification{
+			Title:       c.Code,
+			Description: fmt.Sprintf("%s\n\n**Remedy:** %s", c.Summary, c.Remedy),
+		}
+	case interactions.commands[topic] != nil:
+		n = commandHelp(topic, interactions.commands[topic])
+	default:
+		replyError(ctx, "Unknown help topic `%s`! Try `/help`, `/help errors` or `/help <command>`.", topic)
+		return
+	}
+
+	n.Color = 0x0099ff
+	_, err := ctx.Reply(n)
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+func commandsHelp() *Notification {
+	names := []string{}
+	for name := range interactions.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "`/%s` %s\n", name, interactions.commands[name].Description)
+	}
+	b.WriteString("\nUse `/help <command>` for its options and `/help errors` for error codes.")
+
+	return &Notification{Title: "Commands", Description: b.String()}
+}
+
+func commandHelp(name string, cmd *Command) *Notification {
+	n := &Notification{Title: "/" + name, Description: cmd.Description}
+	for _, opt := range cmd.Options {
+		name := fmt.Sprintf("`%s`", opt.Name)
+		if opt.Required {
+			name += " (required)"
+		}
+		n.Fields = append(n.Fields, NotificationField{Name: name, Value: opt.Description})
+	}
+	return n
+}
+
+func errorsHelp() *Notification {
+	var b strings.Builder
+	for _, c := range errorCodes {
+		fmt.Fprintf(&b, "`%s` %s\n", c.Code, c.Summary)
+	}
+	b.WriteString("\nUse `/help <code>` for how to fix one.")
+
+	return &Notification{Title: "Error codes", Description: b.String()}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxHistory is the number of job records kept in the history file.
+const maxHistory = 1000
+
+// jobRecord is the outcome of a finished job as kept in the job history.
+type jobRecord struct {
+	ID          string        `json:"id"`
+	Profile     string        `json:"profile"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	StartedAt   time.Time     `json:"started_at"`
+	Duration    time.Duration `json:"duration"`
+	Files       int           `json:"files"`
+	Bytes       int64         `json:"bytes"`
+	TotalBytes  int64         `json:"total_bytes,omitempty"`
+	Warnings    int           `json:"warnings"`
+	Success     bool          `json:"success"`
+	Note        string        `json:"note,omitempty"`
+	StartedBy   string        `json:"started_by,omitempty"`
+	PanelUser   string        `json:"panel_user,omitempty"`
+	MessageURL  string        `json:"message_url,omitempty"`
+	ChannelURL  string        `json:"channel_url,omitempty"`
+
+	// CopyBytes and CopyDuration are what the copy phase alone wrote and
+	// took, not counting pauses.
+	CopyBytes    int64         `json:"copy_bytes,omitempty"`
+	CopyDuration time.Duration `json:"copy_duration,omitempty"`
+}
+
+// throughput returns the copy speed of the job in bytes per second.
+func (r *jobRecord) throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// copyRate returns how fast the copy phase of the job wrote in bytes per
+// second, or zero for records from before it was kept.
+func (r *jobRecord) copyRate() float64 {
+	if r.CopyDuration <= 0 {
+		return 0
+	}
+	return float64(r.CopyBytes) / r.CopyDuration.Seconds()
+}
+
+var historyMu sync.Mutex
+
+func historyPath() string {
+	return filepath.Join(dataDir, "history.json")
+}
+
+func loadHistory() ([]jobRecord, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	return readHistory()
+}
+
+func appendHistory(rec jobRecord) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	records, err := readHistory()
+	if err != nil {
+		return err
+	}
+
+	records = append(records, rec)
+	if len(records) > maxHistory {
+		records = records[len(records)-maxHistory:]
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dataDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := historyPath() + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, historyPath())
+}
+
+func readHistory() ([]jobRecord, error) {
+	data, err := os.ReadFile(historyPath())
+	if os.IsNotExist(err) {
+		return []jobRecord{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	records := []jobRecord{}
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
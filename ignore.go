@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ignoreErrors are patterns of paths whose copy and delete errors only
+// produce a warning. Patterns containing a slash are matched against the
+// path relative to the server directory, all others against the file name.
+var ignoreErrors []string
+
+func isIgnoredError(relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, pattern := range ignoreErrors {
+		name := relPath
+		if !strings.Contains(pattern, "/") {
+			name = filepath.Base(relPath)
+		}
+
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tolerate turns err into a warning on j if path, which lies below root, is
+// configured to ignore errors.
+func (j *job) tolerate(root string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	rel, rerr := filepath.Rel(root, path)
+	if rerr != nil || !isIgnoredError(rel) {
+		return err
+	}
+
+	j.warnf("Ignoring error on %s: %s", rel, err)
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// jarDirs are the directories whose jars are matched by plugin or mod name
+// when jar sync is enabled.
+var jarDirs = []string{"plugins", "mods"}
+
+type jarInfo struct {
+	File    string
+	Name    string
+	Version string
+}
+
+// jarChanges describes how the plugins and mods of a server change with a
+// release.
+type jarChanges struct {
+	Added    []jarInfo
+	Removed  []jarInfo
+	Upgraded [][2]jarInfo
+}
+
+func (c *jarChanges) empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Upgraded) == 0
+}
+
+func (c *jarChanges) String() string {
+	var b strings.Builder
+	for _, p := range c.Upgraded {
+		fmt.Fprintf(&b, "↑ %s %s → %s\n", p[1].Name, p[0].Version, p[1].Version)
+	}
+	for _, p := range c.Added {
+		fmt.Fprintf(&b, "+ %s %s\n", p.Name, p.Version)
+	}
+	for _, p := range c.Removed {
+		fmt.Fprintf(&b, "- %s %s\n", p.Name, p.Version)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// planJars compares the plugin and mod jars of srcDir and dstDir by name. It
+// returns the resulting changes and the destination jars replaced by a
+// differently named jar of the same plugin, which removeJars deletes once
+// the source has been checked. Jars the rules of the profile keep away from
+// the copy or the delete are left alone.
+func planJars(j *job, srcDir string, dstDir string) (*jarChanges, []string, error) {
+	p := j.Profile
+	changes := &jarChanges{}
+	replaced := []string{}
+
+	for _, dir := range jarDirs {
+		src, err := readJars(filepath.Join(srcDir, dir))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dst, err := readJars(filepath.Join(dstDir, dir))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Destination-only files of merged directories are never deleted.
+		merged := p.isMerged(filepath.Join(dstDir, dir))
+		untouched := func(path string) bool {
+			return p.isPreserved(path) || p.isExcluded(p.dstDir, filepath.Dir(path)) || p.isExcluded(p.dstDir, path)
+		}
+
+		for name, s := range src {
+			if p.isExcluded(srcDir, filepath.Join(srcDir, dir)) || p.isExcluded(srcDir, filepath.Join(srcDir, dir, s.File)) {
+				continue
+			}
+
+			d, ok := dst[name]
+			if !ok {
+				changes.Added = append(changes.Added, s)
+				continue
+			}
+
+			if d.Version != s.Version {
+				changes.Upgraded = append(changes.Upgraded, [2]jarInfo{d, s})
+			}
+
+			dstPath := filepath.Join(dstDir, dir, d.File)
+			if d.File != s.File && !merged && !untouched(dstPath) {
+				j.logf("%s/%s is replaced by %s", dir, d.File, s.File)
+				replaced = append(replaced, dstPath)
+			}
+		}
+
+		for name, d := range dst {
+			if _, ok := src[name]; !ok && !untouched(filepath.Join(dstDir, dir, d.File)) {
+				changes.Removed = append(changes.Removed, d)
+			}
+		}
+	}
+
+	sort.Slice(changes.Added, func(a, b int) bool { return changes.Added[a].Name < changes.Added[b].Name })
+	sort.Slice(changes.Removed, func(a, b int) bool { return changes.Removed[a].Name < changes.Removed[b].Name })
+	sort.Slice(changes.Upgraded, func(a, b int) bool { return changes.Upgraded[a][1].Name < changes.Upgraded[b][1].Name })
+	sort.Strings(replaced)
+
+	return changes, replaced, nil
+}
+
+// removeJars deletes the replaced jars found by planJars.
+func removeJars(j *job) error {
+	for _, path := range j.replacedJars {
+		if err := j.checkpoint(); err != nil {
+			return err
+		}
+
+		err := removePath(j, path, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readJars returns the jars directly inside dir keyed by plugin name.
+func readJars(dir string) (map[string]jarInfo, error) {
+	jars := map[string]jarInfo{}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return jars, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jar") {
+			continue
+		}
+
+		info, err := readJar(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			// Not every jar is a plugin; fall back to the file name.
+			info = jarInfo{Name: strings.TrimSuffix(entry.Name(), ".jar")}
+		}
+		info.File = entry.Name()
+
+		jars[strings.ToLower(info.Name)] = info
+	}
+
+	return jars, nil
+}
+
+// readJar reads the name and version of a Bukkit, BungeeCord, Velocity,
+// Fabric or Forge plugin from its metadata.
+func readJar(path string) (jarInfo, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return jarInfo{}, err
+	}
+	defer r.Close()
+
+	for _, name := range []string{"paper-plugin.yml", "plugin.yml", "bungee.yml"} {
+		if data, err := readZipFile(&r.Reader, name); err == nil {
+			info := jarInfo{
+				Name:    yamlScalar(data, "name"),
+				Version: yamlScalar(data, "version"),
+			}
+			if info.Name != "" {
+				return info, nil
+			}
+		}
+	}
+
+	for _, name := range []string{"velocity-plugin.json", "fabric.mod.json"} {
+		if data, err := readZipFile(&r.Reader, name); err == nil {
+			var meta struct {
+				ID      string `json:"id"`
+				Version string `json:"version"`
+			}
+			if json.Unmarshal(data, &meta) == nil && meta.ID != "" {
+				return jarInfo{Name: meta.ID, Version: meta.Version}, nil
+			}
+		}
+	}
+
+	if data, err := readZipFile(&r.Reader, "META-INF/mods.toml"); err == nil {
+		info := jarInfo{
+			Name:    tomlString(data, "modId"),
+			Version: tomlString(data, "version"),
+		}
+		if strings.HasPrefix(info.Version, "${") {
+			manifest, _ := readZipFile(&r.Reader, "META-INF/MANIFEST.MF")
+			info.Version = manifestValue(manifest, "Implementation-Version")
+		}
+		if info.Name != "" {
+			return info, nil
+		}
+	}
+
+	return jarInfo{}, fmt.Errorf("no plugin metadata in %s", path)
+}
+
+func readZipFile(r *zip.Reader, name string) ([]byte, error) {
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// yamlScalar returns the value of a top-level scalar key of a YAML document.
+func yamlScalar(data []byte, key string) string {
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if ok && k == key {
+			return strings.Trim(strings.TrimSpace(v), `"'`)
+		}
+	}
+	return ""
+}
+
+// tomlString returns the first string value assigned to key in a TOML
+// document.
+func tomlString(data []byte, key string) string {
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(k) == key {
+			return strings.Trim(strings.TrimSpace(v), `"'`)
+		}
+	}
+	return ""
+}
+
+func manifestValue(data []byte, key string) string {
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if ok && k == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job is a single copy from the source to the destination server.
+type job struct {
+	ID        string
+	Profile   *profile
+	Delete    bool
+	StartedAt time.Time
+
+	// AllowMassDelete skips the delete cap of the profile.
+	AllowMassDelete bool
+
+	// AllowEmptySource skips the checks for an empty source and marker
+	// files.
+	AllowEmptySource bool
+
+	// Build and SHA256 select the artifact of artifact profiles.
+	Build  string
+	SHA256 string
+
+	// Note is a free-text annotation given by whoever started the job.
+	Note string
+
+	// StartedBy is the user that started the job, and PanelUser the panel
+	// user their account is linked to. Power actions of the job use the API
+	// key of the linked panel user if there is one.
+	StartedBy string
+	PanelUser string
+	panelKey  string
+
+	// MessageURL links to the reply to the command that started the job,
+	// and ChannelURL to the channel or thread the command was used in.
+	MessageURL string
+	ChannelURL string
+
+	// DryRun only scans the servers and reports what the release would do,
+	// in Plan.
+	DryRun bool
+	Plan   *estimate
+
+	// Priority is priorityInteractive or priorityBackground.
+	Priority string
+
+	// Release is the build metadata of jobs triggered by CI.
+	Release *releaseInfo
+
+	// Changelog is the changelog found in the source.
+	Changelog *changelog
+
+	Concurrency concurrency
+
+	// srcDir is where the source is read from, which is a snapshot of the
+	// source directory of the profile if it uses one.
+	srcDir string
+
+	// writtenDirs are the destination directories files were copied into.
+	writtenDirs []string
+
+	// caseInsensitive is set if the destination cannot tell apart names
+	// that differ only by case.
+	caseInsensitive bool
+
+	// chownFiles is set if copied files are handed to uid and gid.
+	chownFiles bool
+	uid, gid   int
+
+	// seeded are the seed-once paths of the profile that have been copied
+	// before, relative to the destination.
+	seeded map[string]bool
+
+	mu         sync.Mutex
+	TotalFiles int
+	TotalBytes int64
+	Files      int
+	Bytes      int64
+
+	// Renamed lists the files whose names were normalized on copy, as
+	// "source -> destination" relative to the destination.
+	Renamed []string
+
+	// SkippedFiles and SkippedBytes count the files left out by the skip
+	// rules of the profile.
+	SkippedFiles int
+	SkippedBytes int64
+
+	// DeltaSkipped is the number of bytes delta syncing did not have to
+	// rewrite.
+	DeltaSkipped int64
+
+	// noReflink logs once that delta syncing is not possible at the
+	// destination.
+	noReflink sync.Once
+
+	Warnings []string
+
+	// Jars are the plugin and mod changes found by jar sync, and
+	// replacedJars the destination jars it deletes.
+	Jars         *jarChanges
+	replacedJars []string
+
+	// Inconsistent is set if the source changed between the manifests taken
+	// before and after the copy, which are listed in SourceChanges.
+	Inconsistent  bool
+	SourceChanges []string
+	manifest      manifest
+
+	// Notes are what the release changes since the last one of the profile,
+	// from the manifests of both. released is stored as the last release
+	// once this one succeeded.
+	Notes    *releaseNotes
+	released *releasedManifest
+
+	// Query is what the destination reported once it was restarted.
+	Query *serverStatus
+
+	// Largest are the largest files and directories copied, set once the
+	// release report has been written.
+	Largest *largest
+
+	// PanelChanges are the panel settings synced from the source server.
+	PanelChanges []string
+
+	// Started is set once the smoke check saw the destination start.
+	Started bool
+
+	// RolledBack is set if the destination was restored after a failed
+	// smoke check.
+	RolledBack bool
+
+	// paused is closed when a paused job is resumed, and nil while it is
+	// not paused. pausedFor is how long the copy phase has been paused
+	// before pausedAt.
+	paused    chan struct{}
+	pausedAt  time.Time
+	pausedFor time.Duration
+
+	// Phase is the part of the release currently running, since
+	// phaseStarted.
+	Phase        string
+	phaseStarted time.Time
+
+	// copyBytes and copyDuration are what the copy phase wrote and took
+	// without pauses, once it is over.
+	copyBytes    int64
+	copyDuration time.Duration
+
+	// historicalRate is the average throughput of past releases of the
+	// profile in bytes per second, used to estimate the time left.
+	historicalRate float64
+
+	// dirs is the copy progress of each top-level directory, listed in
+	// dirOrder.
+	dirs     map[string]*dirProgress
+	dirOrder []string
+
+	// sinks receive the progress events of this job only.
+	sinks []EventSink
+
+	// notifiers receive alerts raised while the job runs. If nil, the
+	// configured notifiers are used.
+	notifiers []Notifier
+
+	// logLines are the lines logged about the job, with their own lock as
+	// they are logged while j.mu is held.
+	logMu           sync.Mutex
+	logLines        []string
+	droppedLogLines int
+
+	// logFile is the log of the job on disk.
+	logFile jobLog
+
+	// Done is set once the job has finished.
+	Done bool
+
+	// ctx is canceled when the job is canceled.
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	// Err is the reason the job failed.
+	Err error
+}
+
+// jobRetention is how long finished jobs can still be looked up.
+const jobRetention = time.Hour
+
+var (
+	jobsMu sync.Mutex
+	jobs   = map[string]*job{}
+)
+
+func newJob(p *profile, delete bool) *job {
+	j := &job{
+		ID:        newJobID(),
+		Profile:   p,
+		Priority:  priorityInteractive,
+		Delete:    delete,
+		srcDir:    p.srcDir,
+		StartedAt: time.Now(),
+	}
+	j.ctx, j.cancel = context.WithCancelCause(context.Background())
+	j.logFile.id = j.ID
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	jobs[j.ID] = j
+	return j
+}
+
+func findJob(id string) *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	return jobs[id]
+}
+
+// errCanceled is the error of canceled jobs.
+var errCanceled = errors.New("job was canceled")
+
+// Cancel stops j at the next file it would delete or copy.
+func (j *job) Cancel() {
+	j.cancel(errCanceled)
+}
+
+// canceled returns errCanceled once j has been canceled.
+func (j *job) canceled() error {
+	if j.ctx.Err() != nil {
+		return context.Cause(j.ctx)
+	}
+	return nil
+}
+
+// finish marks j as done and forgets it after jobRetention.
+func (j *job) finish() {
+	j.mu.Lock()
+	j.Done = true
+	j.mu.Unlock()
+	j.cancel(nil)
+	j.logFile.close()
+	pruneJobLogs()
+
+	time.AfterFunc(jobRetention, func() {
+		jobsMu.Lock()
+		defer jobsMu.Unlock()
+
+		delete(jobs, j.ID)
+	})
+}
+
+// started returns when j started. Jobs of a group waiting for others only
+// start once those have finished, so it is read under j.mu.
+func (j *job) started() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.StartedAt
+}
+
+// newJobID returns a short random identifier that is easy to quote in chat.
+func newJobID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("150405.000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// maxJobLogLines is the number of log lines kept per job. Older lines are
+// dropped in batches once there are twice as many.
+const maxJobLogLines = 10000
+
+// logf logs a line about j and keeps it for /logs.
+func (j *job) logf(format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	log.Printf("[job %s] %s", j.ID, msg)
+
+	line := time.Now().Format("2006/01/02 15:04:05 ") + msg
+	j.logFile.write(line + "\n")
+
+	j.logMu.Lock()
+	defer j.logMu.Unlock()
+
+	j.logLines = append(j.logLines, line)
+	if len(j.logLines) >= 2*maxJobLogLines {
+		j.droppedLogLines += len(j.logLines) - maxJobLogLines
+		j.logLines = append([]string{}, j.logLines[len(j.logLines)-maxJobLogLines:]...)
+	}
+}
+
+// log returns the lines logged about j.
+func (j *job) log() string {
+	j.logMu.Lock()
+	defer j.logMu.Unlock()
+
+	var b strings.Builder
+	if j.droppedLogLines > 0 {
+		fmt.Fprintf(&b, "(%d earlier lines dropped)\n", j.droppedLogLines)
+	}
+	for _, line := range j.logLines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// warnf logs a problem that does not fail the job and keeps it for the
+// final report.
+func (j *job) warnf(format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+
+	j.mu.Lock()
+	j.Warnings = append(j.Warnings, msg)
+	j.mu.Unlock()
+
+	j.emit(Event{Type: EventWarning, Message: msg})
+}
+
+// sortedWarnings returns the warnings of j in a stable order. Copy workers
+// report them as they finish, so the order they were raised in differs
+// between runs.
+func (j *job) sortedWarnings() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	warnings := append([]string{}, j.Warnings...)
+	sort.Strings(warnings)
+	return warnings
+}
+
+func (j *job) addCopied(path string, bytes int64) {
+	j.mu.Lock()
+	j.Files++
+	j.Bytes += bytes
+	j.dir(topDir(j.Profile.dstDir, path)).Copied += bytes
+	j.mu.Unlock()
+
+	j.emit(Event{Type: EventFileCopied, Path: path, Bytes: bytes})
+}
+
+func (j *job) addRenamed(srcPath string, dstPath string) {
+	src, _ := filepath.Rel(j.srcDir, srcPath)
+	dst, _ := filepath.Rel(j.Profile.dstDir, dstPath)
+	j.logf("Renamed %s to %s", src, dst)
+
+	j.mu.Lock()
+	j.Renamed = append(j.Renamed, fmt.Sprintf("%s -> %s", filepath.ToSlash(src), filepath.ToSlash(dst)))
+	j.mu.Unlock()
+}
+
+func (j *job) addSkipped(bytes int64) {
+	j.mu.Lock()
+	j.SkippedFiles++
+	j.SkippedBytes += bytes
+	j.mu.Unlock()
+}
+
+func (j *job) addDeltaSkipped(bytes int64) {
+	j.mu.Lock()
+	j.DeltaSkipped += bytes
+	j.mu.Unlock()
+
+	j.emit(Event{Type: EventDelta, Bytes: bytes})
+}
+
+// link records where the reply to the command that started j was sent, so
+// that the history and API link back to the conversation.
+func (j *job) link(message string, channel string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.MessageURL = message
+	j.ChannelURL = channel
+}
+
+// reportTags returns tags with the link to the conversation that started j
+// added, for error reports.
+func (j *job) reportTags(tags map[string]string) map[string]string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.MessageURL != "" {
+		tags["message"] = j.MessageURL
+	}
+	return tags
+}
+
+func (j *job) record(success bool) jobRecord {
+	return jobRecord{
+		ID:          j.ID,
+		Profile:     j.Profile.Name,
+		Source:      j.Profile.Source,
+		Destination: j.Profile.Destination,
+		StartedAt:   j.StartedAt,
+		Duration:    time.Since(j.StartedAt),
+		Files:       j.Files,
+		Bytes:       j.Bytes,
+		TotalBytes:  j.TotalBytes,
+		Warnings:    len(j.Warnings),
+		Success:     success,
+		Note:        j.Note,
+		StartedBy:   j.StartedBy,
+		PanelUser:   j.PanelUser,
+		MessageURL:  j.MessageURL,
+		ChannelURL:  j.ChannelURL,
+
+		CopyBytes:    j.copyBytes,
+		CopyDuration: j.copyDuration,
+	}
+}
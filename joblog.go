@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxJobLogFiles is the number of rotated log files kept per job besides
+// the current one.
+const maxJobLogFiles = 5
+
+// Settings of the job logs on disk. Each job logs to its own file in
+// jobLogDir, which is rotated once it reaches jobLogMaxSize. Files older
+// than jobLogRetention are removed.
+var (
+	jobLogDir       string
+	jobLogMaxSize   int64 = 10 << 20
+	jobLogRetention       = 30 * 24 * time.Hour
+)
+
+// jobLog is the log file of a job.
+type jobLog struct {
+	mu   sync.Mutex
+	id   string
+	f    *os.File
+	size int64
+
+	// failed is set once the log could not be written, so that the error
+	// is only reported once.
+	failed bool
+}
+
+// jobLogFile returns the path of the log of job id, or of its nth rotated
+// log for n > 0.
+func jobLogFile(id string, n int) string {
+	if n == 0 {
+		return filepath.Join(jobLogDir, id+".log")
+	}
+	return filepath.Join(jobLogDir, fmt.Sprintf("%s.%d.log", id, n))
+}
+
+func (l *jobLog) write(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if jobLogDir == "" || l.failed {
+		return
+	}
+
+	err := l.writeLine(line)
+	if err != nil {
+		log.Printf("[job %s] Error writing job log: %s", l.id, err)
+		l.failed = true
+	}
+}
+
+func (l *jobLog) writeLine(line string) error {
+	if l.f != nil && l.size+int64(len(line)) > jobLogMaxSize {
+		err := l.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if l.f == nil {
+		err := os.MkdirAll(jobLogDir, 0755)
+		if err != nil {
+			return err
+		}
+
+		l.f, err = os.OpenFile(jobLogFile(l.id, 0), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		l.size = 0
+	}
+
+	n, err := l.f.WriteString(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate moves the current log aside, dropping the oldest rotated log.
+func (l *jobLog) rotate() error {
+	err := l.f.Close()
+	l.f = nil
+	if err != nil {
+		return err
+	}
+
+	os.Remove(jobLogFile(l.id, maxJobLogFiles))
+	for n := maxJobLogFiles - 1; n >= 0; n-- {
+		err := os.Rename(jobLogFile(l.id, n), jobLogFile(l.id, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *jobLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
+
+// readJobLog returns the logs of job id on disk, oldest first.
+func readJobLog(id string) ([]byte, error) {
+	if jobLogDir == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, os.ErrNotExist
+	}
+
+	var b bytes.Buffer
+	found := false
+	for n := maxJobLogFiles; n >= 0; n-- {
+		data, err := os.ReadFile(jobLogFile(id, n))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		b.Write(data)
+		found = true
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	return b.Bytes(), nil
+}
+
+// pruneJobLogs removes the job logs older than jobLogRetention.
+func pruneJobLogs() {
+	if jobLogDir == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(jobLogDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Printf("Error pruning job logs: %s", err)
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !strings.HasSuffix(entry.Name(), ".log") || time.Since(info.ModTime()) < jobLogRetention {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(jobLogDir, entry.Name()))
+		if err != nil {
+			log.Printf("Error removing old job log: %s", err)
+		}
+	}
+}
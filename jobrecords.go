@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jobRecordsFile is the file a JSON line is appended to for every finished
+// job, for analytics outside of the bot. Empty disables it.
+var jobRecordsFile string
+
+var jobRecordsMu sync.Mutex
+
+// jobExport is the full record of a finished job as written to
+// jobRecordsFile, one per line.
+type jobExport struct {
+	jobRecord
+
+	Delete           bool         `json:"delete"`
+	DryRun           bool         `json:"dry_run,omitempty"`
+	Priority         string       `json:"priority"`
+	AllowMassDelete  bool         `json:"allow_mass_delete,omitempty"`
+	AllowEmptySource bool         `json:"allow_empty_source,omitempty"`
+	Build            string       `json:"build,omitempty"`
+	Release          *releaseInfo `json:"release,omitempty"`
+
+	TotalFiles    int   `json:"total_files"`
+	SkippedFiles  int   `json:"skipped_files"`
+	SkippedBytes  int64 `json:"skipped_bytes"`
+	DeltaSkipped  int64 `json:"delta_skipped_bytes"`
+	RenamedFiles  int   `json:"renamed_files"`
+	SourceChanges int   `json:"source_changes"`
+
+	WarningMessages []string `json:"warning_messages,omitempty"`
+	Inconsistent    bool     `json:"inconsistent,omitempty"`
+	Started         bool     `json:"started,omitempty"`
+	RolledBack      bool     `json:"rolled_back,omitempty"`
+
+	// Phase is the phase the job failed in.
+	Phase     string `json:"phase,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+func (j *job) export(success bool) jobExport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e := jobExport{
+		jobRecord:        j.record(success),
+		Delete:           j.Delete,
+		DryRun:           j.DryRun,
+		Priority:         j.Priority,
+		AllowMassDelete:  j.AllowMassDelete,
+		AllowEmptySource: j.AllowEmptySource,
+		Build:            j.Build,
+		Release:          j.Release,
+		TotalFiles:       j.TotalFiles,
+		SkippedFiles:     j.SkippedFiles,
+		SkippedBytes:     j.SkippedBytes,
+		DeltaSkipped:     j.DeltaSkipped,
+		RenamedFiles:     len(j.Renamed),
+		SourceChanges:    len(j.SourceChanges),
+		WarningMessages:  append([]string{}, j.Warnings...),
+		Inconsistent:     j.Inconsistent,
+		Started:          j.Started,
+		RolledBack:       j.RolledBack,
+	}
+	if j.Err != nil {
+		e.Phase = j.Phase
+		e.Error = j.Err.Error()
+		e.ErrorCode = errorCodeOf(j.Err).Code
+	}
+	return e
+}
+
+// appendJobRecord writes the record of j as a line to jobRecordsFile.
+func appendJobRecord(j *job, success bool) error {
+	if jobRecordsFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(j.export(success))
+	if err != nil {
+		return err
+	}
+
+	jobRecordsMu.Lock()
+	defer jobRecordsMu.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(jobRecordsFile), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(jobRecordsFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+const (
+	// largeFileBufferSize is the size of the buffer large files are
+	// streamed through.
+	largeFileBufferSize = 8 << 20
+	// directIOAlignment is the buffer alignment required by O_DIRECT.
+	directIOAlignment = 4096
+)
+
+// Settings for files of at least largeFileSize bytes; zero disables the
+// large file path.
+var (
+	largeFileSize int64
+	preallocate   bool
+	directIO      bool
+)
+
+// alignedBuffer returns a buffer of size bytes whose start is aligned for
+// direct IO.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directIOAlignment); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+	return buf[offset : offset+size]
+}
+
+// copyLargeFile streams srcPath into dstPath without holding the whole file
+// in memory. Depending on the settings it preallocates the destination,
+// bypasses the page cache with O_DIRECT and drops the source pages from the
+// cache after reading them, so copying huge worlds does not evict the cache
+// of the running servers.
+func copyLargeFile(j *job, srcPath string, dstPath string, size int64, mode os.FileMode) (int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dst, direct, err := openDestination(dstPath, mode, directIO)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	if preallocate {
+		err = fallocate(dst, size)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	bufSize, release, err := reserveBuffer(j.ctx, largeFileBufferSize)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	buf := alignedBuffer(bufSize)
+	var written int64
+	for {
+		n, rerr := io.ReadFull(src, buf)
+		if n > 0 {
+			// O_DIRECT only accepts writes of whole blocks, so the tail of
+			// the file goes through the page cache.
+			if direct && n%directIOAlignment != 0 {
+				err = disableDirectIO(dst)
+				if err != nil {
+					return written, err
+				}
+				direct = false
+			}
+
+			err = j.throttle(int64(n))
+			if err != nil {
+				return written, err
+			}
+
+			_, err = dst.Write(buf[:n])
+			if err != nil {
+				return written, err
+			}
+			written += int64(n)
+
+			dropCache(src)
+		}
+
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			return written, rerr
+		}
+	}
+
+	// Preallocation may have reserved more than was written if the source
+	// shrank in the meantime.
+	err = dst.Truncate(written)
+	if err != nil {
+		return written, err
+	}
+
+	if durability == durabilityFile {
+		err = dst.Sync()
+		if err != nil {
+			return written, err
+		}
+	}
+	dropCache(dst)
+
+	return written, dst.Close()
+}
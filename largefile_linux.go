@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openDestination opens path for writing, with O_DIRECT if requested and
+// supported by the filesystem. It reports whether O_DIRECT is in effect.
+func openDestination(path string, mode os.FileMode, direct bool) (*os.File, bool, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if direct {
+		f, err := os.OpenFile(path, flag|unix.O_DIRECT, mode)
+		if err == nil {
+			return f, true, nil
+		} else if !errors.Is(err, unix.EINVAL) {
+			return nil, false, err
+		}
+	}
+
+	f, err := os.OpenFile(path, flag, mode)
+	return f, false, err
+}
+
+func disableDirectIO(f *os.File) error {
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = unix.FcntlInt(f.Fd(), unix.F_SETFL, flags&^unix.O_DIRECT)
+	return err
+}
+
+func fallocate(f *os.File, size int64) error {
+	if size == 0 {
+		return nil
+	}
+
+	err := unix.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		return nil
+	}
+	return err
+}
+
+// dropCache advises the kernel that the cached pages of f are no longer
+// needed.
+func dropCache(f *os.File) {
+	unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+func openDestination(path string, mode os.FileMode, direct bool) (*os.File, bool, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	return f, false, err
+}
+
+func disableDirectIO(f *os.File) error {
+	return nil
+}
+
+func fallocate(f *os.File, size int64) error {
+	return nil
+}
+
+func dropCache(f *os.File) {}
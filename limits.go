@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// defaultMaxDepth is how deeply the source may nest directories unless the
+// profile sets another limit. Game servers stay far below it, while a
+// backup copied into itself or a directory loop quickly goes beyond.
+const defaultMaxDepth = 64
+
+// sourceLimits are ceilings on the source of a release. A release going
+// beyond one is aborted while scanning, before anything is deleted, as it
+// usually means the source contains backups of itself or similar runaway
+// directories.
+type sourceLimits struct {
+	// MaxFiles is the most files the source may have.
+	MaxFiles int `yaml:"max_files"`
+
+	// MaxDepth is how deeply directories may be nested, 64 by default.
+	MaxDepth int `yaml:"max_depth"`
+
+	// MaxBytes is the largest the source may be in total, such as 50GiB.
+	MaxBytes string `yaml:"max_bytes"`
+
+	maxBytes int64
+}
+
+func (l *sourceLimits) init() error {
+	if l.MaxFiles < 0 || l.MaxDepth < 0 {
+		return fmt.Errorf("limits: max_files and max_depth must not be negative")
+	}
+
+	if l.MaxBytes != "" {
+		var err error
+		l.maxBytes, err = parseSize(l.MaxBytes)
+		if err != nil {
+			return fmt.Errorf("limits.max_bytes: %w", err)
+		}
+	}
+	return nil
+}
+
+// sourceLimits returns the limits of p, with the default depth if it has
+// none.
+func (p *profile) sourceLimits() sourceLimits {
+	l := sourceLimits{MaxDepth: defaultMaxDepth}
+	if p.Limits != nil {
+		l = *p.Limits
+		if l.MaxDepth == 0 {
+			l.MaxDepth = defaultMaxDepth
+		}
+	}
+	return l
+}
+
+// depth returns how many directories deep path is below root.
+func depth(root string, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator))
+}
+
+// check returns an error if a source with files files and bytes bytes, in
+// which path was found, goes beyond l.
+func (l *sourceLimits) check(root string, path string, files int64, bytes int64) error {
+	switch {
+	case l.MaxFiles > 0 && files > int64(l.MaxFiles):
+		return withCode("E_LIMIT", fmt.Errorf("source has more than %d files (max_files)", l.MaxFiles))
+	case l.maxBytes > 0 && bytes > l.maxBytes:
+		return withCode("E_LIMIT", fmt.Errorf("source is larger than %s (max_bytes)", formatBytes(l.maxBytes)))
+	case l.MaxDepth > 0 && depth(root, path) > l.MaxDepth:
+		return withCode("E_LIMIT", fmt.Errorf("%s is nested more than %d directories deep (max_depth)", path, l.MaxDepth))
+	}
+	return nil
+}
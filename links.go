@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// linkTimeout is how long a user has to finish linking their account once
+// they started.
+const linkTimeout = 10 * time.Minute
+
+// accountLink ties a Discord user to their panel user, whose client API key
+// is used for the panel actions of the jobs they start.
+type accountLink struct {
+	DiscordID   string    `json:"discord_id"`
+	DiscordName string    `json:"discord_name"`
+	PanelUserID int       `json:"panel_user_id"`
+	PanelUser   string    `json:"panel_user"`
+	APIKey      string    `json:"api_key"`
+	LinkedAt    time.Time `json:"linked_at"`
+}
+
+var linksMu sync.Mutex
+
+func linksPath() string {
+	return filepath.Join(dataDir, "links.json")
+}
+
+func readLinks() (map[string]*accountLink, error) {
+	data, err := os.ReadFile(linksPath())
+	if os.IsNotExist(err) {
+		return map[string]*accountLink{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	links := map[string]*accountLink{}
+	err = json.Unmarshal(data, &links)
+	return links, err
+}
+
+func writeLinks(links map[string]*accountLink) error {
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dataDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// The file holds API keys.
+	tmp := linksPath() + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, linksPath())
+}
+
+// findLink returns the link of the Discord user, or nil if they have not
+// linked their panel account.
+func findLink(user string) *accountLink {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+
+	links, err := readLinks()
+	if err != nil {
+		log.Printf("Error reading account links: %s", err)
+		return nil
+	}
+	return links[user]
+}
+
+func saveLink(link *accountLink) error {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+
+	links, err := readLinks()
+	if err != nil {
+		return err
+	}
+	links[link.DiscordID] = link
+	return writeLinks(links)
+}
+
+func removeLink(user string) (bool, error) {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+
+	links, err := readLinks()
+	if err != nil {
+		return false, err
+	} else if _, ok := links[user]; !ok {
+		return false, nil
+	}
+	delete(links, user)
+	return true, writeLinks(links)
+}
+
+// discordOAuth identifies users through Discord when they link their panel
+// account on the HTTP API.
+type discordOAuth struct {
+	clientID     string
+	clientSecret string
+
+	// publicURL is where users reach the HTTP API.
+	publicURL string
+
+	mu sync.Mutex
+
+	// states are the OAuth flows started, and sessions the Discord users
+	// that have yet to enter their panel API key, by random token.
+	states   map[string]time.Time
+	sessions map[string]*accountLink
+	expires  map[string]time.Time
+}
+
+// linkOAuth is nil unless DISCORD_CLIENT_ID and DISCORD_CLIENT_SECRET are
+// set.
+var linkOAuth *discordOAuth
+
+func newDiscordOAuth(clientID string, clientSecret string, publicURL string) *discordOAuth {
+	return &discordOAuth{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		publicURL:    strings.TrimSuffix(publicURL, "/"),
+		states:       map[string]time.Time{},
+		sessions:     map[string]*accountLink{},
+		expires:      map[string]time.Time{},
+	}
+}
+
+func (o *discordOAuth) redirectURL() string {
+	return o.publicURL + "/link/callback"
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	return hex.EncodeToString(b), err
+}
+
+// expire drops flows that were not finished in time. o.mu must be held.
+func (o *discordOAuth) expire() {
+	now := time.Now()
+	for state, started := range o.states {
+		if now.Sub(started) > linkTimeout {
+			delete(o.states, state)
+		}
+	}
+	for token, expires := range o.expires {
+		if now.After(expires) {
+			delete(o.sessions, token)
+			delete(o.expires, token)
+		}
+	}
+}
+
+// handleStart sends the user to Discord to sign in.
+func (o *discordOAuth) handleStart(w http.ResponseWriter, r *http.Request) {
+	state, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	o.mu.Lock()
+	o.expire()
+	o.states[state] = time.Now()
+	o.mu.Unlock()
+
+	q := url.Values{
+		"client_id":     {o.clientID},
+		"redirect_uri":  {o.redirectURL()},
+		"response_type": {"code"},
+		"scope":         {"identify"},
+		"state":         {state},
+	}
+	http.Redirect(w, r, "https://discord.com/oauth2/authorize?"+q.Encode(), http.StatusFound)
+}
+
+// handleCallback identifies the user Discord sent back and asks for their
+// panel API key.
+func (o *discordOAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	o.mu.Lock()
+	o.expire()
+	_, ok := o.states[state]
+	delete(o.states, state)
+	o.mu.Unlock()
+	if !ok {
+		http.Error(w, "link expired, please start again", http.StatusBadRequest)
+		return
+	}
+
+	id, name, err := o.identify(r.URL.Query().Get("code"))
+	if err != nil {
+		log.Printf("Error identifying Discord user: %s", err)
+		http.Error(w, "could not identify Discord user", http.StatusBadGateway)
+		return
+	}
+
+	session, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	o.mu.Lock()
+	o.sessions[session] = &accountLink{DiscordID: id, DiscordName: name}
+	o.expires[session] = time.Now().Add(linkTimeout)
+	o.mu.Unlock()
+
+	renderLinkPage(w, linkPage{Session: session, DiscordName: name})
+}
+
+// identify exchanges the authorization code for a token and returns the ID
+// and name of the Discord user it belongs to.
+func (o *discordOAuth) identify(code string) (string, string, error) {
+	resp, err := httpClient.PostForm("https://discord.com/api/oauth2/token", url.Values{
+		"client_id":     {o.clientID},
+		"client_secret": {o.clientSecret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.redirectURL()},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("exchanging code: %s", resp.Status)
+	} else if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://discord.com/api/users/@me", nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	me, err := httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer me.Body.Close()
+
+	var user struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+	if me.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("fetching user: %s", me.Status)
+	} else if err := json.NewDecoder(me.Body).Decode(&user); err != nil {
+		return "", "", err
+	}
+	return user.ID, user.Username, nil
+}
+
+// handleKey checks the panel API key entered by the user and links their
+// accounts.
+func (o *discordOAuth) handleKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := r.PostFormValue("session")
+	o.mu.Lock()
+	o.expire()
+	link, ok := o.sessions[session]
+	o.mu.Unlock()
+	if !ok {
+		http.Error(w, "link expired, please start again", http.StatusBadRequest)
+		return
+	}
+
+	key := strings.TrimSpace(r.PostFormValue("api_key"))
+	var account struct {
+		Attributes struct {
+			ID       int    `json:"id"`
+			Username string `json:"username"`
+		} `json:"attributes"`
+	}
+	err := newPanelClient(panel.url, key).do(http.MethodGet, "/api/client/account", nil, &account)
+	if err != nil {
+		renderLinkPage(w, linkPage{Session: session, DiscordName: link.DiscordName, Error: "The panel did not accept this API key."})
+		return
+	}
+
+	o.mu.Lock()
+	delete(o.sessions, session)
+	delete(o.expires, session)
+	o.mu.Unlock()
+
+	link.PanelUserID = account.Attributes.ID
+	link.PanelUser = account.Attributes.Username
+	link.APIKey = key
+	link.LinkedAt = time.Now()
+	err = saveLink(link)
+	if err != nil {
+		log.Printf("Error saving account link: %s", err)
+		http.Error(w, "could not save the link", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Linked Discord user %s (%s) to panel user %s", link.DiscordName, link.DiscordID, link.PanelUser)
+	renderLinkPage(w, linkPage{DiscordName: link.DiscordName, PanelUser: link.PanelUser})
+}
+
+type linkPage struct {
+	Session     string
+	DiscordName string
+	PanelUser   string
+	Error       string
+}
+
+var linkTemplate = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Link panel account</title></head>
+<body>
+{{if .PanelUser}}
+<p>Discord user <b>{{.DiscordName}}</b> is now linked to panel user <b>{{.PanelUser}}</b>. You can close this page.</p>
+{{else}}
+<p>Signed in as <b>{{.DiscordName}}</b>. Create a client API key in your panel account settings and enter it below.</p>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+<form method="post" action="/link/key">
+<input type="hidden" name="session" value="{{.Session}}">
+<input type="password" name="api_key" placeholder="ptlc_..." required>
+<button type="submit">Link</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+func renderLinkPage(w http.ResponseWriter, page linkPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := linkTemplate.Execute(w, page)
+	if err != nil {
+		log.Printf("Error rendering link page: %s", err)
+	}
+}
+
+// attribute records that user started j, and has the power actions of j
+// use the API key of their panel user if they linked one.
+func (j *job) attribute(user string) {
+	j.StartedBy = user
+	if link := findLink(user); link != nil {
+		j.PanelUser = link.PanelUser
+		j.panelKey = link.APIKey
+	}
+}
+
+// panel returns the panel client to act on servers for j.
+func (j *job) panel() *panelClient {
+	if j.panelKey == "" || panel == nil {
+		return panel
+	}
+	return newPanelClient(panel.url, j.panelKey)
+}
+
+func handleLink(ctx CommandContext) {
+	if boolOption(ctx, "remove") {
+		removed, err := removeLink(ctx.User())
+		switch {
+		case err != nil:
+			log.Printf("Error removing account link: %s", err)
+			replyError(ctx, "Failed to remove the link!")
+		case !removed:
+			replyError(ctx, "Your account is not linked to a panel user.")
+		default:
+			_, err = ctx.Reply(&Notification{Color: 0x00ff00, Description: ":white_check_mark: Your panel account has been unlinked."})
+		}
+		if err != nil {
+			log.Printf("Error replying to command: %s", err)
+		}
+		return
+	}
+
+	if linkOAuth == nil {
+		replyError(ctx, "Account linking requires DISCORD_CLIENT_ID, DISCORD_CLIENT_SECRET and PUBLIC_URL!")
+		return
+	}
+
+	description := fmt.Sprintf("Sign in with Discord and enter a client API key of your panel account at %s/link", linkOAuth.publicURL)
+	if link := findLink(ctx.User()); link != nil {
+		description = fmt.Sprintf("Your account is linked to panel user `%s`. To link another one, go to %s/link", link.PanelUser, linkOAuth.publicURL)
+	}
+	_, err := ctx.Reply(&Notification{Color: 0x87ceeb, Title: "Link your panel account", Description: description})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
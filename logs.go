@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func handleLogs(ctx CommandContext) {
+	id := ctx.Option("job")
+	description := fmt.Sprintf("Log of job `%s`", id)
+	var data []byte
+	if j := findJob(id); j != nil {
+		description += fmt.Sprintf(" (%s)", j.Profile.Name)
+		data = []byte(j.log())
+	} else {
+		// Logs on disk outlive the jobs they describe.
+		var err error
+		data, err = readJobLog(id)
+		if os.IsNotExist(err) {
+			replyError(ctx, "Job `%s` not found!", id)
+			return
+		} else if err != nil {
+			log.Printf("Error reading log of job %s: %s", id, err)
+			replyError(ctx, "Failed to read the log of job `%s`!", id)
+			return
+		}
+	}
+
+	_, err := ctx.Reply(&Notification{
+		Color:       0x87ceeb,
+		Description: description,
+		Files:       []NotificationFile{{Name: fmt.Sprintf("job-%s.log", id), Data: data}},
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
@@ -1,43 +1,98 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
-	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	srcSrvUUID string
-	dstSrvUUID string
-	srcSrvDir  string
-	dstSrvDir  string
+	baseDir    string
+	profiles   []*profile
+	groups     map[string][]string
 	keepFiles  []string
+	dataDir    string
+	durability string
+	notifiers  []Notifier
+
+	errorReporters []ErrorReporter
+
+	// defaultRules are the rules every profile starts from.
+	defaultRules ruleSet
 )
 
 func init() {
-	srcSrvUUID = os.Getenv("SRC_SERVER_UUID")
-	if srcSrvUUID == "" {
-		log.Fatalf("No source server UUID found")
+	baseDir = os.Getenv("SERVER_BASE_DIR")
+	if baseDir == "" {
+		baseDir = "/var/lib/pterodactyl/volumes/"
 	}
 
-	dstSrvUUID = os.Getenv("DST_SERVER_UUID")
-	if dstSrvUUID == "" {
-		log.Fatalf("No destination server UUID found")
+	dataDir = os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
 	}
 
-	baseDir := os.Getenv("SERVER_BASE_DIR")
-	if baseDir == "" {
-		baseDir = "/var/lib/pterodactyl/volumes/"
+	scanConcurrency = envInt("SCAN_CONCURRENCY")
+	copyConcurrency = envInt("COPY_CONCURRENCY")
+	hashConcurrency = envInt("HASH_CONCURRENCY")
+	maxOpenFiles = envInt("MAX_OPEN_FILES")
+
+	var err error
+	durability, err = parseDurability(os.Getenv("DURABILITY"))
+	if err != nil {
+		log.Fatalf("Invalid durability: %s", err)
+	}
+
+	deltaSyncSize = int64(envInt("DELTA_SYNC_SIZE"))
+	if age := envInt("RUNNING_LOG_AGE"); age > 0 {
+		runningLogAge = time.Duration(age) * time.Second
+	}
+
+	jobLogDir = os.Getenv("JOB_LOG_DIR")
+	if jobLogDir == "" {
+		jobLogDir = filepath.Join(dataDir, "logs")
+	} else if jobLogDir == "off" {
+		jobLogDir = ""
+	}
+	if v := os.Getenv("MEMORY_LIMIT"); v != "" {
+		limit, err := parseSize(v)
+		if err != nil {
+			log.Fatalf("Invalid value for MEMORY_LIMIT: %s", err)
+		}
+		setMemoryLimit(limit)
+	}
+	if v := os.Getenv("JOB_LOG_MAX_SIZE"); v != "" {
+		jobLogMaxSize, err = parseSize(v)
+		if err != nil {
+			log.Fatalf("Invalid value for JOB_LOG_MAX_SIZE: %s", err)
+		}
 	}
+	if days := envInt("JOB_LOG_RETENTION_DAYS"); days > 0 {
+		jobLogRetention = time.Duration(days) * 24 * time.Hour
+	}
+
+	jobRecordsFile = os.Getenv("JOB_RECORDS_FILE")
 
-	srcSrvDir = filepath.Join(baseDir, srcSrvUUID)
-	dstSrvDir = filepath.Join(baseDir, dstSrvUUID)
+	atomicWrites = envBool("ATOMIC_WRITES")
+	largeFileSize = int64(envInt("LARGE_FILE_SIZE"))
+	preallocate = envBool("PREALLOCATE")
+	directIO = envBool("DIRECT_IO")
 
 	keepFiles = []string{}
 	for _, v := range strings.Split(os.Getenv("KEEP_FILES"), ",") {
@@ -47,255 +102,1182 @@ func init() {
 
 		keepFiles = append(keepFiles, strings.TrimSpace(v))
 	}
+
+	if url := os.Getenv("PANEL_URL"); url != "" {
+		key := envSecret("PANEL_API_KEY")
+		if key == "" {
+			log.Fatalf("No panel API key found")
+		}
+
+		panel = newPanelClient(url, key)
+
+		if key := envSecret("PANEL_APPLICATION_KEY"); key != "" {
+			panelApp = newPanelClient(url, key)
+		}
+	}
+
+	ignoreErrors = []string{}
+	for _, v := range strings.Split(os.Getenv("IGNORE_ERRORS"), ",") {
+		if v == "" {
+			continue
+		}
+
+		ignoreErrors = append(ignoreErrors, strings.TrimSpace(v))
+	}
+
+	if url := envSecret("SLACK_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, &slackNotifier{webhookURL: url})
+	}
+
+	if token := envSecret("TELEGRAM_BOT_TOKEN"); token != "" {
+		chatID := os.Getenv("TELEGRAM_CHAT_ID")
+		if chatID == "" {
+			log.Fatalf("No Telegram chat ID found")
+		}
+
+		notifiers = append(notifiers, &telegramNotifier{token: token, chatID: chatID})
+	}
+
+	if dsn := envSecret("SENTRY_DSN"); dsn != "" {
+		reporter, err := newSentryReporter(dsn)
+		if err != nil {
+			log.Fatalf("Error initializing Sentry: %s", err)
+		}
+
+		errorReporters = append(errorReporters, reporter)
+	}
+
+	if url := envSecret("ERROR_WEBHOOK_URL"); url != "" {
+		errorReporters = append(errorReporters, &webhookReporter{url: url})
+	}
 }
 
-func main() {
-	token := os.Getenv("DISCORD_BOT_TOKEN")
-	if token == "" {
-		log.Fatalf("No token found")
+// envInt parses an optional non-negative integer environment variable.
+func envInt(key string) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
 	}
 
-	dg, err := discordgo.New("Bot " + token)
-	if err != nil {
-		log.Fatalf("Error creating Discord session: %s", err)
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("Invalid value for %s: %s", key, v)
 	}
 
-	dg.AddHandler(interactionCreate)
+	return n
+}
 
-	err = dg.Open()
+// envBool parses an optional boolean environment variable.
+func envBool(key string) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(v)
 	if err != nil {
-		log.Fatalf("Error opening Discord session: %s", err)
+		log.Fatalf("Invalid value for %s: %s", key, v)
 	}
-	defer dg.Close()
 
-	guilds, err := dg.UserGuilds(1, "", "", false)
+	return b
+}
+
+// loadProfiles loads the profiles, groups, bots, API access, panel
+// permissions, permission matrix and the load, bandwidth, background and CPU
+// limits from the config and mounts the servers on remote nodes.
+func loadProfiles() {
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatalf("Error getting guilds: %s", err)
-	} else if len(guilds) == 0 {
-		log.Fatalf("No guilds found")
+		log.Fatalf("Error loading profiles: %s", err)
+	} else if len(cfg.Profiles) == 0 {
+		log.Fatalf("No profiles found")
+	}
+	profiles = cfg.Profiles
+	groups = cfg.Groups
+	defaultRules = cfg.Defaults
+	if cfg.API != nil {
+		auth = cfg.API
 	}
+	loadLimits = cfg.NodeLoad
+	bandwidthLimits = cfg.Bandwidth
+	background = cfg.Background
+	setMaxCPU(cfg.MaxCPU)
+	importHostSettings = cfg.ImportHostSettings
+	bots = cfg.Bots
+	panelPerms = cfg.PanelPermissions
+	permissions = cfg.Permissions
 
-	log.Printf("Creating application commands")
+	for _, p := range profiles {
+		err := p.mount()
+		if err != nil {
+			log.Printf("Error mounting servers of %s: %s", p.Name, err)
+		}
+	}
+}
 
-	cmds := []*discordgo.ApplicationCommand{}
+var (
+	simulate      = flag.Bool("simulate", false, "release between generated fake servers instead of the configured ones")
+	simulateSize  = flag.Int64("simulate-size", 256, "total size of the simulated servers in MiB")
+	simulateFiles = flag.Int("simulate-files", 1000, "number of files in the simulated servers")
+)
 
-	cmd, err := dg.ApplicationCommandCreate(dg.State.User.ID, guilds[0].ID, &discordgo.ApplicationCommand{
-		Name:        "copy",
-		Description: "Copy server files from one server to another",
-	})
-	if err != nil {
-		log.Fatalf("Error creating application commands: %s", err)
+func main() {
+	flag.Parse()
+
+	var sim *simulation
+	if *simulate {
+		var err error
+		sim, err = setupSimulation(*simulateSize<<20, *simulateFiles)
+		if err != nil {
+			log.Fatalf("Error setting up simulation: %s", err)
+		}
+		defer sim.Close()
+	} else {
+		loadProfiles()
 	}
-	cmds = append(cmds, cmd)
 
-	cmd, err = dg.ApplicationCommandCreate(dg.State.User.ID, guilds[0].ID, &discordgo.ApplicationCommand{
-		Name:        "show-keep-files",
-		Description: "Show files that will not be overwritten or deleted",
-	})
+	httpAddr, grpcAddr := os.Getenv("HTTP_ADDR"), os.Getenv("GRPC_ADDR")
+	if id := os.Getenv("DISCORD_CLIENT_ID"); id != "" {
+		secret, publicURL := envSecret("DISCORD_CLIENT_SECRET"), os.Getenv("PUBLIC_URL")
+		switch {
+		case secret == "" || publicURL == "":
+			log.Fatalf("Account linking requires DISCORD_CLIENT_SECRET and PUBLIC_URL")
+		case httpAddr == "":
+			log.Fatalf("Account linking requires HTTP_ADDR")
+		case panel == nil:
+			log.Fatalf("Account linking requires PANEL_URL and PANEL_API_KEY")
+		}
+		linkOAuth = newDiscordOAuth(id, secret, publicURL)
+	}
+	if httpAddr != "" || grpcAddr != "" {
+		auth.addToken(envSecret("API_TOKEN"))
+		eventSinks = append(eventSinks, hub)
+	}
+	if httpAddr != "" {
+		go serveAPI(httpAddr)
+	}
+	if grpcAddr != "" {
+		go serveGRPC(grpcAddr)
+	}
+
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		eventSinks = append(eventSinks, newMetricsSink())
+		go serveMetrics(addr)
+	}
+
+	shutdownTracing, err := initTracing()
 	if err != nil {
-		log.Fatalf("Error creating application commands: %s", err)
+		log.Fatalf("Error initializing tracing: %s", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	frontends := []Frontend{}
+
+	if token := envSecret("DISCORD_BOT_TOKEN"); token != "" {
+		gw, err := envDiscordGateway()
+		if err != nil {
+			log.Fatalf("Invalid Discord gateway configuration: %s", err)
+		}
+
+		dg, err := newDiscordFrontend(token, gw)
+		if err != nil {
+			log.Fatalf("Error creating Discord session: %s", err)
+		}
+
+		frontends = append(frontends, dg)
+
+		if channelID := os.Getenv("DISCORD_CHANNEL_ID"); channelID != "" {
+			notifiers = append(notifiers, &discordNotifier{session: dg.session, channelID: channelID})
+		}
+	}
+
+	for _, b := range bots {
+		// A broken staging bot should not take the others down.
+		dg, err := b.frontend()
+		if err != nil {
+			log.Printf("Error creating Discord session of bot %s: %s", b.Name, err)
+			continue
+		}
+
+		frontends = append(frontends, dg)
+	}
+
+	if homeserver := os.Getenv("MATRIX_HOMESERVER"); homeserver != "" {
+		token := envSecret("MATRIX_ACCESS_TOKEN")
+		if token == "" {
+			log.Fatalf("No Matrix access token found")
+		}
+
+		roomID := os.Getenv("MATRIX_ROOM_ID")
+		if roomID == "" {
+			log.Fatalf("No Matrix room ID found")
+		}
+
+		frontends = append(frontends, newMatrixFrontend(homeserver, token, roomID))
+	}
+
+	if len(frontends) == 0 && sim != nil {
+		// Without a chat service, rehearse a single release and exit.
+		success := runSimulation()
+		sim.Close()
+		if !success {
+			os.Exit(1)
+		}
+		return
+	} else if len(frontends) == 0 {
+		log.Fatalf("No token found")
+	}
+
+	interactions.register(commands, components)
+
+	for _, f := range frontends {
+		err := f.Open()
+		if err != nil {
+			log.Fatalf("Error opening %T: %s", f, err)
+		}
+		defer f.Close()
+
+		err = f.RegisterCommands(commands)
+		if err != nil {
+			log.Fatalf("Error registering application commands: %s", err)
+		}
 	}
-	cmds = append(cmds, cmd)
+
+	presence := newPresenceSink()
+	for _, f := range frontends {
+		if d, ok := f.(*discordFrontend); ok {
+			presence.add(d)
+		}
+	}
+	eventSinks = append(eventSinks, presence)
+	go presence.run()
 
 	log.Printf("Bot is now running")
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
-	log.Printf("Removing application commands")
-	for _, cmd := range cmds {
-		err = dg.ApplicationCommandDelete(dg.State.User.ID, guilds[0].ID, cmd.ID)
+	unmountNodes()
+	log.Printf("Bot has been stopped")
+}
+
+// envDiscordGateway reads the gateway intents and sharding of the Discord
+// bot from the environment. If DISCORD_SHARD_COUNT is set without
+// DISCORD_SHARD_ID, the shard of DISCORD_GUILD_ID is used.
+func envDiscordGateway() (discordGateway, error) {
+	intents, err := parseIntents(os.Getenv("DISCORD_INTENTS"))
+	if err != nil {
+		return discordGateway{}, err
+	}
+
+	gw := discordGateway{
+		Intents:     intents,
+		GuildID:     os.Getenv("DISCORD_GUILD_ID"),
+		TestGuildID: os.Getenv("DISCORD_TEST_GUILD_ID"),
+		ShardID:     envInt("DISCORD_SHARD_ID"),
+		ShardCount:  envInt("DISCORD_SHARD_COUNT"),
+	}
+
+	if gw.ShardCount > 1 && os.Getenv("DISCORD_SHARD_ID") == "" {
+		if gw.GuildID == "" {
+			return gw, fmt.Errorf("DISCORD_SHARD_COUNT requires DISCORD_SHARD_ID or DISCORD_GUILD_ID")
+		}
+
+		gw.ShardID, err = guildShard(gw.GuildID, gw.ShardCount)
 		if err != nil {
-			log.Printf("Error deleting application commands: %s", err)
+			return gw, err
 		}
+	} else if gw.ShardCount > 0 && gw.ShardID >= gw.ShardCount {
+		return gw, fmt.Errorf("DISCORD_SHARD_ID must be below DISCORD_SHARD_COUNT")
 	}
 
-	log.Printf("Bot has been stopped")
+	return gw, nil
 }
 
-func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	if i.Type == discordgo.InteractionApplicationCommand {
-		command := i.ApplicationCommandData()
-		if command.Name == "copy" {
-			ch := make(chan bool)
-			go copy(ch, true)
-
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Embeds: []*discordgo.MessageEmbed{
-						{
-							Color:       0xffff00,
-							Title:       "Copying server files...",
-							Description: ":warning: Do not add any modifications to the server files while copying!",
-							Fields: []*discordgo.MessageEmbedField{
-								{
-									Name:   "Source Server",
-									Value:  fmt.Sprintf("`%s`", srcSrvUUID),
-									Inline: false,
-								},
-								{
-									Name:   "Destination Server",
-									Value:  fmt.Sprintf("`%s`", dstSrvUUID),
-									Inline: false,
-								},
-								{
-									Name:   "Keep Files",
-									Value:  fmt.Sprintf("```\n%s\n```", strings.Join(keepFiles, "\n")),
-									Inline: false,
-								},
-							},
-						},
-					},
-				},
-			})
+func copy(j *job, success chan bool) {
+	ctx, span := tracer.Start(j.ctx, "release", trace.WithAttributes(
+		attribute.String("releaser.job", j.ID),
+		attribute.String("releaser.profile", j.Profile.Name),
+		attribute.String("releaser.source", j.Profile.Source),
+		attribute.String("releaser.destination", j.Profile.Destination),
+		attribute.Bool("releaser.delete", j.Delete),
+	))
 
-			success := <-ch
-			if success {
-				s.ChannelMessageSendEmbed(i.ChannelID, &discordgo.MessageEmbed{
-					Color:       0x00ff00,
-					Description: ":white_check_mark: Copying has been completed!",
-				})
-			} else {
-				s.ChannelMessageSendEmbed(i.ChannelID, &discordgo.MessageEmbed{
-					Color:       0xff0000,
-					Description: ":x: Copying has failed!",
-				})
-			}
-		} else if command.Name == "show-keep-files" {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Embeds: []*discordgo.MessageEmbed{
-						{
-							Color:       0x87ceeb,
-							Title:       "Keep Files",
-							Description: fmt.Sprintf("These files will not be overwritten or deleted:\n```%s```", strings.Join(keepFiles, "\n")),
-						},
-					},
-				},
-			})
+	tags := map[string]string{
+		"job":         j.ID,
+		"profile":     j.Profile.Name,
+		"source":      j.Profile.Source,
+		"destination": j.Profile.Destination,
+		"delete":      fmt.Sprint(j.Delete),
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err := panicError(r)
+			j.Err = err
+			j.logf("Panic while copying: %s", err)
+			endSpan(span, err)
+			reportError(err, j.reportTags(tags))
+			saveRecord(j, false)
+			j.finish()
+			j.emit(Event{Type: EventFinished, Message: err.Error()})
+			success <- false
+		}
+	}()
+
+	j.logf("Copying %s to %s", j.Profile.srcDir, j.Profile.dstDir)
+	j.addSink(newReportSink())
+	j.emit(Event{Type: EventStarted})
+
+	err := release(ctx, j)
+	endSpan(span, err)
+	if err != nil {
+		j.Err = err
+		// Cancellations are asked for, so there is nobody to alert.
+		if !errors.Is(err, errCanceled) {
+			reportError(err, j.reportTags(tags))
+		}
+	} else if j.DryRun {
+		j.logf("Dry run has been completed in %s", time.Since(j.StartedAt).Round(time.Second))
+	} else {
+		j.logf("Copying has been completed in %s", time.Since(j.StartedAt).Round(time.Second))
+		if j.DeltaSkipped > 0 {
+			j.logf("Delta sync skipped rewriting %s of unchanged data", formatBytes(j.DeltaSkipped))
+		}
+		if j.Profile.RemindRestart > 0 {
+			go remindRestart(j, time.Now())
 		}
 	}
+
+	saveRecord(j, err == nil)
+
+	finished := Event{Type: EventFinished, Success: err == nil}
+	if err != nil {
+		finished.Message = err.Error()
+	}
+	j.finish()
+	j.emit(finished)
+
+	success <- err == nil
 }
 
-func copy(success chan bool, delete bool) {
-	if _, err := os.Stat(dstSrvDir); os.IsNotExist(err) {
-		log.Printf("Destination directory %s does not exist", dstSrvDir)
-		success <- false
-		return
+func saveRecord(j *job, success bool) {
+	// Dry runs copy nothing, so they would skew the sizes and rates of
+	// the profile.
+	if !j.DryRun {
+		err := appendHistory(j.record(success))
+		if err != nil {
+			j.logf("Error saving job history: %s", err)
+		}
 	}
 
-	if delete {
-		err := removeFiles(dstSrvDir)
+	err := appendJobRecord(j, success)
+	if err != nil {
+		j.logf("Error writing job record: %s", err)
+	}
+}
+
+func release(ctx context.Context, j *job) error {
+	srcDir, dstDir := j.srcDir, j.Profile.dstDir
+
+	if j.Priority == priorityBackground {
+		_, span := j.startPhase(ctx, "queued")
+		leave, err := waitForTurn(j)
+		endSpan(span, err)
+		defer leave()
 		if err != nil {
-			log.Printf("Error removing destination files: %s", err)
-			success <- false
-			return
+			return err
 		}
+	} else {
+		leave, _ := waitForTurn(j)
+		defer leave()
 	}
 
-	if _, err := os.Stat(srcSrvDir); os.IsNotExist(err) {
-		log.Printf("Source directory %s does not exist", srcSrvDir)
-		success <- false
-		return
+	if err := j.Profile.mount(); err != nil {
+		j.logf("Error mounting remote node: %s", err)
+		return withCode("E_NODE", err)
+	}
+	if j.Profile.Git == nil && j.Profile.Artifact == nil {
+		j.logf("Reading source over %s, writing destination over %s", transport(j.Profile.Source), transport(j.Profile.Destination))
+	}
+
+	if _, err := os.Stat(dstDir); os.IsNotExist(err) {
+		j.logf("Destination directory %s does not exist", dstDir)
+		return withCode("E_DST_MISSING", err)
+	}
+
+	if j.Profile.Git != nil {
+		_, span := j.startPhase(ctx, "git")
+		rev, err := j.Profile.Git.checkout(srcDir)
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error checking out %s: %s", j.Profile.Git, err)
+			return withCode("E_GIT", err)
+		}
+		j.logf("Checked out %s at %s", j.Profile.Git, rev)
+	}
+
+	if j.Profile.Artifact != nil {
+		_, span := j.startPhase(ctx, "artifact")
+		var url string
+		if j.Release != nil {
+			url = j.Release.ArtifactURL
+		}
+		err := j.Profile.Artifact.fetch(srcDir, url, j.Build, j.SHA256)
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error fetching artifact: %s", err)
+			return withCode("E_ARTIFACT", err)
+		}
+		j.logf("Extracted artifact %s", j.Profile.Artifact)
+	}
+
+	if err := checkSource(j); err != nil {
+		j.logf("Refusing to release source: %s", err)
+		return withCode("E_SOURCE_INVALID", err)
+	}
+
+	if j.DryRun {
+		return runDryRun(ctx, j)
+	}
+
+	if loadLimits != nil {
+		_, span := j.startPhase(ctx, "load")
+		err := waitForLoad(j)
+		endSpan(span, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	// resumeSource turns saving back on at the source once it has been
+	// read, or once the snapshot has been taken.
+	var resumeSource func()
+	if j.Profile.Quiesce != nil {
+		var err error
+		resumeSource, err = quiesceSource(ctx, j)
+		if err != nil {
+			j.logf("Error quiescing source: %s", err)
+			return withCode("E_QUIESCE", err)
+		}
+		defer func() {
+			if resumeSource != nil {
+				resumeSource()
+			}
+		}()
+	}
+
+	if j.Profile.SourceSnapshot != nil {
+		_, span := j.startPhase(ctx, "source_snapshot")
+		dir, remove, err := j.Profile.SourceSnapshot.take(j, srcDir)
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error taking snapshot of source: %s", err)
+			return withCode("E_SRC_SNAPSHOT", err)
+		}
+		defer func() {
+			err := remove()
+			if err != nil {
+				j.logf("Error removing snapshot of source: %s", err)
+			}
+		}()
+
+		j.logf("Reading source from snapshot %s", dir)
+		srcDir = dir
+		j.srcDir = dir
+
+		if resumeSource != nil {
+			resumeSource()
+			resumeSource = nil
+		}
 	}
 
-	err := copyFiles(srcSrvDir, dstSrvDir)
+	if changelog, err := readChangelog(j, srcDir); err != nil {
+		j.warnf("Error reading changelog: %s", err)
+	} else {
+		j.Changelog = changelog
+	}
+
+	if j.Profile.Rollback {
+		_, span := j.startPhase(ctx, "snapshot")
+		err := takeSnapshot(j)
+		endSpan(span, err)
+		defer removeSnapshot(j)
+		if err != nil {
+			j.logf("Error taking snapshot of destination: %s", err)
+			return withCode("E_SNAPSHOT", err)
+		}
+	}
+
+	if j.Profile.Proxy != nil {
+		err := drainDestination(ctx, j)
+		if err != nil {
+			j.logf("Error draining destination: %s", err)
+			return withCode("E_DRAIN", err)
+		}
+		defer undrainDestination(ctx, j)
+	}
+
+	// The source is scanned before anything is deleted, so a source going
+	// beyond the limits of the profile leaves the destination untouched.
+	if _, err := os.Stat(srcDir); os.IsNotExist(err) {
+		j.logf("Source directory %s does not exist", srcDir)
+		return withCode("E_SRC_MISSING", err)
+	}
+
+	j.Concurrency = jobConcurrency(srcDir, dstDir)
+	if j.Priority == priorityBackground && background != nil && background.Concurrency > 0 {
+		j.Concurrency.Copy = min(j.Concurrency.Copy, background.Concurrency)
+	}
+	if n := buffers.concurrency(); n > 0 {
+		j.Concurrency.Copy = min(j.Concurrency.Copy, n)
+		j.Concurrency.Hash = min(j.Concurrency.Hash, n)
+	}
+	if n := cpuConcurrency(); n > 0 {
+		j.Concurrency.Hash = min(j.Concurrency.Hash, n)
+	}
+	j.logf("Using concurrency scan=%d copy=%d hash=%d", j.Concurrency.Scan, j.Concurrency.Copy, j.Concurrency.Hash)
+
+	_, span := j.startPhase(ctx, "scan")
+	err := scanFiles(j, srcDir)
+	endSpan(span, err)
 	if err != nil {
-		log.Printf("Error copying files: %s", err)
-		success <- false
-		return
+		j.logf("Error scanning source files: %s", err)
+		return withCode("E_SCAN", err)
+	}
+	j.logf("Found %d files (%s) to copy", j.TotalFiles, formatBytes(j.TotalBytes))
+	j.checkSizeBudget()
+
+	if j.Profile.JarSync {
+		_, span = j.startPhase(ctx, "jars")
+		j.Jars, j.replacedJars, err = planJars(j, srcDir, dstDir)
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error comparing plugin jars: %s", err)
+			return withCode("E_SCAN", err)
+		}
+	}
+
+	if j.Delete || len(j.replacedJars) > 0 {
+		err := checkDeleteCap(j)
+		if err != nil {
+			j.logf("Refusing to delete destination files: %s", err)
+			return withCode("E_DELETE_CAP", err)
+		}
+	}
+
+	if (j.Delete && !j.Profile.DeleteAfter) || len(j.replacedJars) > 0 {
+		_, span := j.startPhase(ctx, "delete")
+		err := removeJars(j)
+		if err == nil && j.Delete && !j.Profile.DeleteAfter {
+			err = removeFiles(j, srcDir, dstDir)
+		}
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error removing destination files: %s", err)
+			return withCode("E_DELETE", err)
+		}
+	}
+
+	if j.Profile.Manifest {
+		_, span = j.startPhase(ctx, "manifest")
+		j.manifest, err = takeManifest(j, srcDir)
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error recording source manifest: %s", err)
+			return withCode("E_SCAN", err)
+		}
+		j.logf("Recorded manifest of %d source files", len(j.manifest))
+	}
+
+	j.caseInsensitive, err = caseInsensitive(dstDir)
+	if err != nil {
+		j.logf("Error probing destination for case sensitivity: %s", err)
+		return withCode("E_COPY", err)
+	} else if j.caseInsensitive {
+		j.logf("Destination is case-insensitive")
+	}
+
+	j.uid, j.gid, j.chownFiles, err = j.Profile.owner()
+	if err != nil {
+		j.logf("Error finding owner for copied files: %s", err)
+		return withCode("E_COPY", err)
+	}
+
+	j.seeded, err = loadSeeded(j.Profile.Name)
+	if err != nil {
+		j.logf("Error loading seeded files: %s", err)
+		return withCode("E_STATE", err)
+	}
+
+	j.historicalRate = historicalRate(j.Profile.Name)
+	_, span = j.startPhase(ctx, "copy")
+	stopWatching := j.watchCopyBudget()
+	stopWatchingSource := func() {}
+	if srcDir == j.Profile.srcDir {
+		// A snapshot cannot change under the copy.
+		stopWatchingSource = j.watchSource(srcDir)
+	}
+	p := newPool(j.Concurrency.Copy)
+	err = copyFiles(j, p, nil, srcDir, dstDir)
+	if werr := p.Wait(); err == nil {
+		err = werr
+	}
+	j.mu.Lock()
+	j.copyBytes = j.Bytes
+	j.copyDuration = time.Since(j.phaseStarted) - j.pausedFor
+	j.mu.Unlock()
+	stopWatchingSource()
+	stopWatching()
+	if j.SkippedFiles > 0 {
+		j.logf("Skipped %d files (%s) by size or age", j.SkippedFiles, formatBytes(j.SkippedBytes))
+	}
+	endSpan(span, err)
+	if err != nil {
+		j.logf("Error copying files: %s", err)
+		return withCode("E_COPY", err)
+	}
+
+	if j.manifest != nil {
+		_, span = j.startPhase(ctx, "consistency")
+		later, err := takeManifest(j, srcDir)
+		endSpan(span, err)
+		if err != nil {
+			j.warnf("Error checking source for changes during the copy: %s", err)
+		} else if changes := j.manifest.diff(later); len(changes) > 0 {
+			j.mu.Lock()
+			j.Inconsistent = true
+			j.SourceChanges = changes
+			j.mu.Unlock()
+			j.warnf("Release is inconsistent: %d source files changed during the copy", len(changes))
+		}
+
+		err = takeReleaseNotes(j, srcDir)
+		if err != nil {
+			j.warnf("Error comparing with the last release: %s", err)
+		}
+	}
+
+	if resumeSource != nil {
+		resumeSource()
+		resumeSource = nil
+	}
+
+	err = saveSeeded(j.Profile.Name, j.seeded)
+	if err != nil {
+		j.warnf("Error saving seeded files: %s", err)
+	}
+
+	if j.Profile.WorldCheck != "" {
+		_, span = j.startPhase(ctx, "worlds")
+		problems, err := checkWorlds(j, dstDir)
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error checking world files: %s", err)
+			return withCode("E_WORLD_CORRUPT", err)
+		}
+
+		for _, problem := range problems {
+			j.warnf("Corrupted world file %s", problem)
+		}
+		if len(problems) > 0 && j.Profile.WorldCheck == worldCheckFail {
+			return withCode("E_WORLD_CORRUPT", fmt.Errorf("%d corrupted world files", len(problems)))
+		}
+	}
+
+	if j.Delete && j.Profile.DeleteAfter {
+		_, span = j.startPhase(ctx, "delete")
+		err = removeLeftovers(j, srcDir, dstDir)
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error removing leftover destination files: %s", err)
+			return withCode("E_DELETE", err)
+		}
+	}
+
+	if durability != durabilityNone {
+		_, span = j.startPhase(ctx, "sync")
+		err = syncWrites(j, dstDir)
+		endSpan(span, err)
+		if err != nil {
+			j.logf("Error syncing destination files: %s", err)
+			return withCode("E_SYNC", err)
+		}
+	}
+
+	if j.Profile.PanelSync != nil {
+		err = syncPanelSettings(ctx, j)
+		if err != nil {
+			j.logf("Error syncing panel settings: %s", err)
+			return withCode("E_PANEL", err)
+		}
+	}
+
+	if j.Profile.Restart {
+		err = restartDestination(ctx, j)
+		if err != nil {
+			j.logf("Error restarting destination server: %s", err)
+
+			if j.Profile.Rollback {
+				rerr := rollback(ctx, j)
+				if rerr != nil {
+					j.logf("Error rolling back destination: %s", rerr)
+					return withCode("E_ROLLBACK", fmt.Errorf("%w; rollback failed: %s", err, rerr))
+				}
+
+				j.logf("Destination has been rolled back")
+				j.RolledBack = true
+			}
+			return err
+		}
+
+		if j.Profile.Query != nil {
+			_, span = j.startPhase(ctx, "query")
+			status, qerr := queryDestination(j)
+			endSpan(span, qerr)
+			if qerr != nil {
+				j.warnf("Error querying destination server: %s", qerr)
+			} else {
+				j.logf("Destination server is up: %s", status)
+				j.mu.Lock()
+				j.Query = status
+				j.mu.Unlock()
+			}
+		}
+	}
+
+	if j.Profile.live != "" {
+		err = switchTraffic(ctx, j)
+		if err != nil {
+			j.logf("Error switching to released server: %s", err)
+			return withCode("E_SWITCH", err)
+		}
+		j.logf("Switched players from %s to %s", j.Profile.live, j.Profile.Destination)
+	}
+
+	if j.released != nil {
+		err = saveReleasedManifest(j.Profile.Name, j.released)
+		if err != nil {
+			j.warnf("Error saving manifest of the release: %s", err)
+		}
 	}
 
-	success <- true
+	return nil
 }
 
-func removeFiles(dirPath string) error {
-	files, err := os.ReadDir(dirPath)
+// scanFiles counts the files in srcDirPath that will be copied, and stops
+// at the first limit of the profile the source goes beyond.
+func scanFiles(j *job, srcDirPath string) error {
+	var files, bytes atomic.Int64
+	var mu sync.Mutex
+	dirTotals := map[string]int64{}
+	limits := j.Profile.sourceLimits()
+	walk := walkConcurrent
+	if j.Profile.FollowSymlinks {
+		walk = walkFollowing
+	}
+	err := walk(srcDirPath, j.Concurrency.Scan, func(path string, d fs.DirEntry) error {
+		if err := j.checkpoint(); err != nil {
+			return err
+		} else if d.IsDir() {
+			return limits.check(srcDirPath, path, files.Load(), bytes.Load())
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		err = limits.check(srcDirPath, path, files.Add(1), bytes.Add(info.Size()))
+		if err != nil {
+			return err
+		}
+
+		top := j.Profile.normalizeName(topDir(srcDirPath, path))
+		mu.Lock()
+		dirTotals[top] += info.Size()
+		mu.Unlock()
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
+	j.TotalFiles = int(files.Load())
+	j.TotalBytes = bytes.Load()
+
+	order := make([]string, 0, len(dirTotals))
+	for name := range dirTotals {
+		order = append(order, name)
+	}
+	sort.Strings(order)
+	j.setDirTotals(dirTotals, order)
+	return nil
+}
+
+// removeFiles removes everything in dstDirPath except preserved, excluded and
+// merged files and files that will be delta synced from their counterpart in
+// srcDirPath.
+func removeFiles(j *job, srcDirPath string, dstDirPath string) error {
+	files, err := os.ReadDir(dstDirPath)
+	if err != nil {
+		return j.tolerate(j.Profile.dstDir, dstDirPath, err)
+	}
+
 	for _, file := range files {
-		fullpath := filepath.Join(dirPath, file.Name())
+		if err := j.checkpoint(); err != nil {
+			return err
+		}
+
+		srcFullpath := filepath.Join(srcDirPath, file.Name())
+		fullpath := filepath.Join(dstDirPath, file.Name())
 
-		if !isKeepFile(fullpath) {
+		if !j.Profile.isPreserved(fullpath) && !j.Profile.isExcluded(j.Profile.dstDir, fullpath) && !j.Profile.isMerged(fullpath) {
 			if file.IsDir() {
-				err := removeFiles(fullpath)
+				err := removeFiles(j, srcFullpath, fullpath)
 				if err != nil {
 					return err
 				}
+			} else if srcInfo, err := os.Stat(srcFullpath); err == nil && file.Type().IsRegular() && j.useDelta(srcInfo) {
+				continue
 			}
 
-			os.Remove(fullpath)
+			err := removePath(j, fullpath, file.IsDir())
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-func copyFiles(srcDirPath string, dstDirPath string) error {
-	srcFiles, err := os.ReadDir(srcDirPath)
+// removePath removes a file, or a directory emptied by removeFiles.
+// Directories still holding keep files stay in place.
+func removePath(j *job, fullpath string, dir bool) error {
+	err := os.Remove(fullpath)
+	if err == nil && !dir {
+		j.emit(Event{Type: EventFileDeleted, Path: fullpath})
+	} else if err != nil && !os.IsNotExist(err) && !(dir && isNotEmpty(err)) {
+		return j.tolerate(j.Profile.dstDir, fullpath, err)
+	}
+	return nil
+}
+
+// removeLeftovers removes everything in dstDirPath that is not in srcDirPath
+// after the source has been copied over it, except preserved, excluded and
+// merged files.
+func removeLeftovers(j *job, srcDirPath string, dstDirPath string) error {
+	files, err := os.ReadDir(dstDirPath)
 	if err != nil {
+		return j.tolerate(j.Profile.dstDir, dstDirPath, err)
+	}
+
+	for _, file := range files {
+		if err := j.checkpoint(); err != nil {
+			return err
+		}
+
+		srcFullpath := filepath.Join(srcDirPath, j.Profile.sourceName(srcDirPath, file.Name()))
+		fullpath := filepath.Join(dstDirPath, file.Name())
+
+		if j.Profile.isPreserved(fullpath) || j.Profile.isExcluded(j.Profile.dstDir, fullpath) || j.Profile.isMerged(fullpath) {
+			continue
+		}
+
+		srcInfo, err := os.Lstat(srcFullpath)
+		if err == nil {
+			if srcInfo.IsDir() && file.IsDir() {
+				err := removeLeftovers(j, srcFullpath, fullpath)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		} else if !os.IsNotExist(err) {
+			if err := j.tolerate(j.srcDir, srcFullpath, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if file.IsDir() {
+			err := removeFiles(j, srcFullpath, fullpath)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = removePath(j, fullpath, file.IsDir())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearConflict removes what is in the way of copying srcPath to dstPath
+// when copying over a live destination: symlinks, which would be written
+// through, and entries of the wrong type.
+func clearConflict(j *job, srcPath string, dstPath string, dir bool) error {
+	info, err := os.Lstat(dstPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
 		return err
 	}
 
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+	case info.IsDir() == dir:
+		return nil
+	case info.IsDir():
+		err := removeFiles(j, srcPath, dstPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return removePath(j, dstPath, info.IsDir())
+}
+
+func isNotEmpty(err error) bool {
+	return errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST)
+}
+
+// copyFiles copies the directory srcDirPath, reached through the directories
+// of parents, to dstDirPath.
+func copyFiles(j *job, p *pool, parents *dirChain, srcDirPath string, dstDirPath string) error {
+	srcFiles, err := os.ReadDir(srcDirPath)
+	if err != nil {
+		return j.tolerate(j.srcDir, srcDirPath, err)
+	}
+
+	if parents == nil {
+		info, err := os.Stat(srcDirPath)
+		if err != nil {
+			return err
+		}
+		parents, _ = parents.enter(srcDirPath, info)
+	}
+
+	// The top-level directories are done once the loop moves on from them.
+	root, top := srcDirPath == j.srcDir, ""
+	if root {
+		defer func() {
+			j.dirDispatched(top)
+			j.dirDispatched(rootFiles)
+		}()
+	}
+
+	names, forms := map[string]string{}, map[string]string{}
 	for _, srcFile := range srcFiles {
+		if err := p.Err(); err != nil {
+			return err
+		} else if err := j.checkpoint(); err != nil {
+			return err
+		}
+
+		dstName := j.Profile.normalizeName(srcFile.Name())
 		srcFullpath := filepath.Join(srcDirPath, srcFile.Name())
-		dstFullpath := filepath.Join(dstDirPath, srcFile.Name())
+		dstFullpath := filepath.Join(dstDirPath, dstName)
+
+		if j.Profile.FollowSymlinks && srcFile.Type()&fs.ModeSymlink != 0 {
+			if info, err := os.Stat(srcFullpath); err == nil {
+				srcFile = fs.FileInfoToDirEntry(info)
+			}
+		}
 
-		if !isKeepFile(dstFullpath) {
+		if root && srcFile.IsDir() {
+			if top != "" {
+				j.dirDispatched(top)
+			}
+			top = dstName
+		}
+
+		// Copying both would overwrite the first with the second.
+		if other, ok := caseCollision(names, srcFile.Name()); ok {
+			if j.caseInsensitive {
+				j.warnf("Skipped %s: its name differs only by case from %s, which the destination cannot tell apart", srcFullpath, other)
+				continue
+			}
+			j.warnf("%s differs only by case from %s", srcFullpath, other)
+		}
+		if other, ok := formCollision(forms, srcFile.Name()); ok {
+			if dstName != srcFile.Name() || j.Profile.normalizeName(other) != other {
+				j.warnf("Skipped %s: its name normalizes to the same as %s", srcFullpath, other)
+				continue
+			}
+			j.warnf("%s is the same name as %s in another Unicode normalization form", srcFullpath, other)
+		}
+		if j.Profile.NormalizeNames == "" {
+			if other := otherForm(dstDirPath, srcFile.Name()); other != "" {
+				j.warnf("%s is the same name as %s at the destination in another Unicode normalization form", srcFullpath, other)
+			}
+		}
+
+		if !j.Profile.isKeepFile(dstFullpath) && !j.Profile.isExcluded(j.srcDir, srcFullpath) && !j.skipSeed(dstFullpath) {
 			srcFileInfo, err := srcFile.Info()
 			if err != nil {
-				return err
+				if err := j.tolerate(j.srcDir, srcFullpath, err); err != nil {
+					return err
+				}
+				continue
 			}
 
-			if srcFile.IsDir() {
-				err := os.MkdirAll(dstFullpath, srcFileInfo.Mode())
+			if reason := j.Profile.skipReason(j.srcDir, srcFullpath, srcFileInfo, j.StartedAt); reason != "" {
+				j.logf("Skipped %s: %s", srcFullpath, reason)
+				j.addSkipped(srcFileInfo.Size())
+				continue
+			}
+
+			if j.Profile.DeleteAfter {
+				err := clearConflict(j, srcFullpath, dstFullpath, srcFile.IsDir())
 				if err != nil {
-					return err
+					if err := j.tolerate(j.srcDir, srcFullpath, err); err != nil {
+						return err
+					}
+					continue
 				}
+			}
 
-				err = copyFiles(srcFullpath, dstFullpath)
-				if err != nil {
-					return err
+			if dstName != srcFile.Name() {
+				j.addRenamed(srcFullpath, dstFullpath)
+			}
+
+			if srcFile.IsDir() {
+				parents, loop := parents.enter(srcFullpath, srcFileInfo)
+				if loop != "" {
+					j.warnf("Skipped %s: it leads back to %s", srcFullpath, loop)
+					continue
+				}
+
+				err := os.MkdirAll(dstFullpath, srcFileInfo.Mode())
+				if err == nil {
+					err = j.chown(dstFullpath)
+				}
+				if err == nil {
+					err = j.chmod(dstFullpath, srcFileInfo.Mode())
+				}
+				if err == nil {
+					err = j.copyXattrs(srcFullpath, dstFullpath)
 				}
-			} else {
-				data, err := os.ReadFile(srcFullpath)
 				if err != nil {
-					return err
+					if err := j.tolerate(j.srcDir, srcFullpath, err); err != nil {
+						return err
+					}
+					continue
 				}
 
-				err = os.WriteFile(dstFullpath, data, srcFileInfo.Mode())
+				err = copyFiles(j, p, parents, srcFullpath, dstFullpath)
 				if err != nil {
 					return err
 				}
+			} else {
+				dir := topDir(j.Profile.dstDir, dstFullpath)
+				j.dirPending(dir, 1)
+				p.Go(func() error {
+					defer j.dirPending(dir, -1)
+
+					err := j.withOpenFiles(func() error {
+						return copyFile(j, srcFullpath, dstFullpath, srcFileInfo)
+					})
+					if err == nil {
+						err = j.chown(dstFullpath)
+					}
+					if err == nil {
+						err = j.chmod(dstFullpath, srcFileInfo.Mode())
+					}
+					if err == nil {
+						err = j.copyXattrs(srcFullpath, dstFullpath)
+					}
+					return j.tolerate(j.srcDir, srcFullpath, err)
+				})
 			}
 		}
 	}
 
+	j.writtenDirs = append(j.writtenDirs, dstDirPath)
 	return nil
 }
 
-func isKeepFile(file string) bool {
-	absFile, err := filepath.Abs(file)
-	if err != nil {
-		log.Printf("Error getting absolute path of %s: %s", file, err)
-		return false
+func copyFile(j *job, srcPath string, dstPath string, info fs.FileInfo) error {
+	if writeAtomically(dstPath) {
+		n, err := replaceFile(srcPath, dstPath, info.Mode())
+		if err == nil {
+			err = j.throttle(n)
+		}
+		if err != nil {
+			return err
+		}
+
+		j.addCopied(dstPath, n)
+		return nil
 	}
 
-	for _, v := range keepFiles {
-		absV, err := filepath.Abs(filepath.Join(dstSrvDir, v))
+	if j.useDelta(info) {
+		n, err := deltaCopyFile(srcPath, dstPath, info)
+		if err == nil {
+			err = j.throttle(n)
+		}
+		if err == nil {
+			j.addCopied(dstPath, info.Size())
+			j.addDeltaSkipped(info.Size() - n)
+			return nil
+		} else if err == errNoReflink {
+			j.noReflink.Do(func() {
+				j.logf("Copying files in full, as delta sync needs a destination filesystem with reflinks")
+			})
+		} else if err != errNoDelta {
+			return err
+		}
+	}
+
+	if j.remote() {
+		n, err := copyResumable(j, srcPath, dstPath, info.Mode())
 		if err != nil {
-			log.Printf("Error getting absolute path of %s: %s", v, err)
-			continue
+			return err
+		}
+
+		j.addCopied(dstPath, n)
+		return nil
+	}
+
+	if largeFileSize > 0 && info.Size() >= largeFileSize {
+		n, err := copyLargeFile(j, srcPath, dstPath, info.Size(), info.Mode())
+		if err != nil {
+			return err
 		}
 
-		if absFile == absV {
-			return true
+		j.addCopied(dstPath, n)
+		return nil
+	}
+
+	if buffers != nil {
+		n, err := streamFile(j, srcPath, dstPath, info)
+		if err != nil {
+			return err
 		}
+
+		j.addCopied(dstPath, n)
+		return nil
 	}
 
-	return false
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+
+	err = j.throttle(int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	err = writeFile(dstPath, data, info.Mode())
+	if err != nil {
+		return err
+	}
+
+	j.addCopied(dstPath, int64(len(data)))
+	return nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// manifest maps the paths of the source files of a release, relative to the
+// source, to the SHA-256 of their content.
+type manifest map[string]string
+
+// ignoredSource reports whether path in srcDir is left out of the release
+// of j because it is excluded or kept at the destination.
+func (j *job) ignoredSource(srcDir string, path string) bool {
+	rel, err := filepath.Rel(srcDir, path)
+	if err != nil {
+		return false
+	}
+	dst := filepath.Join(j.Profile.dstDir, j.Profile.normalizeName(rel))
+	return j.Profile.isExcluded(srcDir, path) || j.Profile.isKeepFile(dst)
+}
+
+// takeManifest hashes every file of srcDir that j releases, using up to
+// j.Concurrency.Hash files at a time.
+func takeManifest(j *job, srcDir string) (manifest, error) {
+	paths := []string{}
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if err := j.canceled(); err != nil {
+			return err
+		} else if path == srcDir {
+			return nil
+		}
+
+		if j.ignoredSource(srcDir, path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	m := manifest{}
+	p := newPool(j.Concurrency.Hash)
+	for _, path := range paths {
+		path := path
+		p.Go(func() error {
+			if err := j.canceled(); err != nil {
+				return err
+			}
+
+			hash, err := hashFile(j.ctx, path)
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			rel, _ := filepath.Rel(srcDir, path)
+			mu.Lock()
+			m[filepath.ToSlash(rel)] = hash
+			mu.Unlock()
+			return nil
+		})
+	}
+	err = p.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// hashBufferSize is the size of the buffer files are hashed through.
+const hashBufferSize = 1 << 20
+
+func hashFile(ctx context.Context, path string) (string, error) {
+	size, release, err := reserveBuffer(ctx, hashBufferSize)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	done, err := useCPU(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.CopyBuffer(h, f, make([]byte, size))
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diff lists the files that differ between m and a later manifest, sorted
+// by path and prefixed with - for removed, ~ for changed and + for added
+// files.
+func (m manifest) diff(later manifest) []string {
+	changes := []string{}
+	for path, hash := range m {
+		l, ok := later[path]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("- %s", path))
+		case l != hash:
+			changes = append(changes, fmt.Sprintf("~ %s", path))
+		}
+	}
+	for path := range later {
+		if _, ok := m[path]; !ok {
+			changes = append(changes, fmt.Sprintf("+ %s", path))
+		}
+	}
+
+	sort.Slice(changes, func(a, b int) bool {
+		return changes[a][2:] < changes[b][2:]
+	})
+	return changes
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// matrixFrontend accepts commands as "!<name>" messages in a single Matrix room.
+type matrixFrontend struct {
+	homeserver string
+	token      string
+	roomID     string
+	userID     string
+	client     *http.Client
+	commands   map[string]*Command
+	txnID      atomic.Int64
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func newMatrixFrontend(homeserver, token, roomID string) *matrixFrontend {
+	return &matrixFrontend{
+		homeserver: strings.TrimSuffix(homeserver, "/"),
+		token:      token,
+		roomID:     roomID,
+		client:     &http.Client{Timeout: 60 * time.Second},
+		commands:   map[string]*Command{},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (m *matrixFrontend) Open() error {
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	err := m.do(http.MethodGet, "/_matrix/client/v3/account/whoami", nil, &whoami)
+	if err != nil {
+		return err
+	}
+	m.userID = whoami.UserID
+
+	// Skip the room history so old commands are not executed again.
+	since, err := m.sync("", 0)
+	if err != nil {
+		return err
+	}
+
+	go m.run(since)
+
+	return nil
+}
+
+func (m *matrixFrontend) RegisterCommands(cmds []*Command) error {
+	for _, c := range cmds {
+		m.commands[c.Name] = c
+	}
+
+	return nil
+}
+
+func (m *matrixFrontend) Close() error {
+	close(m.stop)
+	<-m.done
+
+	return nil
+}
+
+func (m *matrixFrontend) run(since string) {
+	defer close(m.done)
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		next, err := m.sync(since, 30*time.Second)
+		if err != nil {
+			log.Printf("Error syncing with Matrix: %s", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		since = next
+	}
+}
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	EventID string `json:"event_id"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+func (m *matrixFrontend) sync(since string, timeout time.Duration) (string, error) {
+	filter := fmt.Sprintf(`{"room":{"rooms":[%q],"timeline":{"types":["m.room.message"]}}}`, m.roomID)
+
+	query := url.Values{}
+	query.Set("filter", filter)
+	query.Set("timeout", fmt.Sprint(timeout.Milliseconds()))
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	var resp struct {
+		NextBatch string `json:"next_batch"`
+		Rooms     struct {
+			Join map[string]struct {
+				Timeline struct {
+					Events []matrixEvent `json:"events"`
+				} `json:"timeline"`
+			} `json:"join"`
+		} `json:"rooms"`
+	}
+	err := m.do(http.MethodGet, "/_matrix/client/v3/sync?"+query.Encode(), nil, &resp)
+	if err != nil {
+		return since, err
+	}
+
+	if since != "" {
+		for _, ev := range resp.Rooms.Join[m.roomID].Timeline.Events {
+			m.handleEvent(ev)
+		}
+	}
+
+	return resp.NextBatch, nil
+}
+
+func (m *matrixFrontend) handleEvent(ev matrixEvent) {
+	if ev.Type != "m.room.message" || ev.Sender == m.userID || ev.Content.MsgType != "m.text" {
+		return
+	}
+
+	name, ok := strings.CutPrefix(strings.TrimSpace(ev.Content.Body), "!")
+	if !ok {
+		return
+	}
+
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return
+	}
+
+	cmd, ok := m.commands[fields[0]]
+	if !ok {
+		return
+	}
+
+	route := cmd.Name
+	if len(cmd.Subcommands) > 0 {
+		if len(fields) < 2 {
+			return
+		}
+		i := slices.IndexFunc(cmd.Subcommands, func(s *Command) bool { return s.Name == fields[1] })
+		if i < 0 {
+			return
+		}
+		cmd = cmd.Subcommands[i]
+		route += " " + cmd.Name
+	}
+
+	go interactions.command(route, &matrixCommandContext{frontend: m, sender: ev.Sender, options: parseMatrixOptions(cmd, name)})
+}
+
+// parseMatrixOptions parses the arguments of a command message. Options are
+// given as name:value or name=value, values may be double-quoted, and a bare
+// name sets a boolean option to true.
+func parseMatrixOptions(cmd *Command, body string) map[string]string {
+	options := map[string]string{}
+
+	args := []string{}
+	var arg strings.Builder
+	quoted := false
+	for _, r := range body {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+		default:
+			arg.WriteRune(r)
+		}
+	}
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+
+	// The first argument is the command name. The subcommand name that may
+	// follow does not match any option.
+	for _, a := range args[1:] {
+		name, value, ok := strings.Cut(a, ":")
+		if !ok {
+			name, value, ok = strings.Cut(a, "=")
+		}
+
+		for _, opt := range cmd.Options {
+			if opt.Name != name {
+				continue
+			}
+
+			if !ok && opt.Type == OptionBool {
+				value = "true"
+			}
+			options[name] = value
+		}
+	}
+
+	return options
+}
+
+func (m *matrixFrontend) send(content map[string]any) (string, error) {
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%d-%d",
+		url.PathEscape(m.roomID), time.Now().UnixNano(), m.txnID.Add(1))
+
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	err := m.do(http.MethodPut, path, content, &resp)
+	return resp.EventID, err
+}
+
+func (m *matrixFrontend) do(method, path string, body any, out any) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, m.homeserver+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type matrixCommandContext struct {
+	frontend *matrixFrontend
+	sender   string
+	options  map[string]string
+}
+
+func (c *matrixCommandContext) Reply(n *Notification) (Reply, error) {
+	eventID, err := c.frontend.send(matrixContent(n))
+	if err != nil {
+		return nil, err
+	}
+
+	return &matrixReply{frontend: c.frontend, eventID: eventID}, nil
+}
+
+func (c *matrixCommandContext) Notifier() Notifier {
+	return &matrixNotifier{frontend: c.frontend}
+}
+
+func (c *matrixCommandContext) Option(name string) string {
+	return c.options[name]
+}
+
+func (c *matrixCommandContext) User() string {
+	return c.sender
+}
+
+func (c *matrixCommandContext) ShowForm(f *Form) error {
+	return errFormsUnsupported
+}
+
+func (c *matrixCommandContext) Update(n *Notification) error {
+	_, err := c.Reply(n)
+	return err
+}
+
+type matrixReply struct {
+	frontend *matrixFrontend
+	eventID  string
+}
+
+func (r *matrixReply) Edit(n *Notification) error {
+	content := matrixContent(n)
+	content["body"] = "* " + content["body"].(string)
+	content["m.new_content"] = matrixContent(n)
+	content["m.relates_to"] = map[string]string{
+		"rel_type": "m.replace",
+		"event_id": r.eventID,
+	}
+
+	_, err := r.frontend.send(content)
+	return err
+}
+
+func (r *matrixReply) Links() (string, string) {
+	room := "https://matrix.to/#/" + url.PathEscape(r.frontend.roomID)
+	return room + "/" + url.PathEscape(r.eventID), room
+}
+
+type matrixNotifier struct {
+	frontend *matrixFrontend
+}
+
+func (m *matrixNotifier) Notify(n *Notification) error {
+	_, err := m.frontend.send(matrixContent(n))
+	return err
+}
+
+func matrixContent(n *Notification) map[string]any {
+	return map[string]any{
+		"msgtype": "m.notice",
+		"body":    emoji.Replace(n.text()),
+	}
+}
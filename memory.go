@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+const (
+	// minBufferSize is the smallest buffer handed out while the budget is
+	// short. Larger buffers are whole multiples of it, which keeps them
+	// aligned for direct IO.
+	minBufferSize = 64 << 10
+
+	// expectedCopyMemory is about what a single copy or hash needs, used to
+	// lower the concurrency of jobs to what the budget can serve.
+	expectedCopyMemory = 1 << 20
+)
+
+// memoryLimit is the memory the bot tries to stay within, set through
+// MEMORY_LIMIT; zero means no limit.
+var memoryLimit int64
+
+// buffers is the budget all copy and hash buffers are taken from, nil
+// without a memory limit. It gets half of the limit, leaving the rest to the
+// runtime, the chat and panel connections and the file lists of jobs.
+var buffers *memoryBudget
+
+// setMemoryLimit applies limit to buffers and to the garbage collector, which
+// then collects more eagerly as the heap approaches it.
+func setMemoryLimit(limit int64) {
+	memoryLimit = limit
+	buffers = &memoryBudget{size: limit / 2, freed: make(chan struct{})}
+	debug.SetMemoryLimit(limit)
+}
+
+// memoryBudget hands out bytes of buffers up to its size.
+type memoryBudget struct {
+	mu   sync.Mutex
+	size int64
+	used int64
+
+	// freed is closed and replaced whenever bytes are released.
+	freed chan struct{}
+}
+
+// acquire reserves up to want bytes. If the budget is short it settles for
+// less, down to minBufferSize, and waits for buffers to be released if even
+// that is not free. Anything less than want is a multiple of minBufferSize.
+func (b *memoryBudget) acquire(ctx context.Context, want int64) (int64, error) {
+	want = min(want, max(b.size-b.size%minBufferSize, minBufferSize))
+	least := min(want, minBufferSize)
+	for {
+		b.mu.Lock()
+		free := b.size - b.used
+		n := want
+		if free < want {
+			n = free - free%minBufferSize
+		}
+		if n >= least || b.used == 0 {
+			n = max(n, least)
+			b.used += n
+			b.mu.Unlock()
+			return n, nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return 0, context.Cause(ctx)
+		}
+	}
+}
+
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// concurrency returns how many copies or hashes the budget can serve at
+// once, or zero without a budget.
+func (b *memoryBudget) concurrency() int {
+	if b == nil {
+		return 0
+	}
+	return max(int(b.size/expectedCopyMemory), 1)
+}
+
+// reserveBuffer returns the size of buffer to use for up to size bytes, and
+// a function giving it back to the budget once the buffer is no longer used.
+// Without a memory limit the size is always granted.
+func reserveBuffer(ctx context.Context, size int) (int, func(), error) {
+	if buffers == nil {
+		return size, func() {}, nil
+	}
+
+	n, err := buffers.acquire(ctx, int64(size))
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(n), func() { buffers.release(n) }, nil
+}
+
+// streamFile copies srcPath to dstPath through a buffer from the budget,
+// instead of reading the whole file into memory. It returns the number of
+// bytes copied.
+func streamFile(j *job, srcPath string, dstPath string, info os.FileInfo) (int64, error) {
+	size, release, err := reserveBuffer(j.ctx, int(min(max(info.Size(), 1), largeFileBufferSize)))
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	buf := make([]byte, size)
+	var written int64
+	for {
+		n, rerr := io.ReadFull(src, buf)
+		if n > 0 {
+			err = j.throttle(int64(n))
+			if err == nil {
+				_, err = dst.Write(buf[:n])
+			}
+			if err != nil {
+				return written, err
+			}
+			written += int64(n)
+		}
+
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			return written, rerr
+		}
+	}
+
+	if durability == durabilityFile {
+		err = dst.Sync()
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, dst.Close()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsSink exports job progress as Prometheus metrics.
+type metricsSink struct {
+	jobs         *prometheus.CounterVec
+	running      prometheus.Gauge
+	duration     *prometheus.HistogramVec
+	files        *prometheus.CounterVec
+	bytes        *prometheus.CounterVec
+	deltaSkipped *prometheus.CounterVec
+	warnings     *prometheus.CounterVec
+}
+
+func newMetricsSink() *metricsSink {
+	s := &metricsSink{
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "releaser_jobs_total",
+			Help: "Finished jobs by profile and result.",
+		}, []string{"profile", "result"}),
+		running: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "releaser_jobs_running",
+			Help: "Jobs currently running.",
+		}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "releaser_job_duration_seconds",
+			Help:    "Duration of finished jobs.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+		}, []string{"profile"}),
+		files: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "releaser_files_copied_total",
+			Help: "Files copied to destinations.",
+		}, []string{"profile"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "releaser_bytes_copied_total",
+			Help: "Bytes copied to destinations.",
+		}, []string{"profile"}),
+		deltaSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "releaser_delta_skipped_bytes_total",
+			Help: "Bytes delta syncing did not have to rewrite.",
+		}, []string{"profile"}),
+		warnings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "releaser_warnings_total",
+			Help: "Warnings raised by jobs.",
+		}, []string{"profile"}),
+	}
+
+	prometheus.MustRegister(s.jobs, s.running, s.duration, s.files, s.bytes, s.deltaSkipped, s.warnings)
+	return s
+}
+
+func (s *metricsSink) Handle(j *job, e *Event) {
+	switch e.Type {
+	case EventStarted:
+		s.running.Inc()
+	case EventFileCopied:
+		s.files.WithLabelValues(e.Profile).Inc()
+		s.bytes.WithLabelValues(e.Profile).Add(float64(e.Bytes))
+	case EventDelta:
+		s.deltaSkipped.WithLabelValues(e.Profile).Add(float64(e.Bytes))
+	case EventWarning:
+		s.warnings.WithLabelValues(e.Profile).Inc()
+	case EventFinished:
+		s.running.Dec()
+		result := "failure"
+		if e.Success {
+			result = "success"
+		}
+		s.jobs.WithLabelValues(e.Profile, result).Inc()
+		s.duration.WithLabelValues(e.Profile).Observe(e.Time.Sub(j.StartedAt).Seconds())
+	}
+}
+
+// serveMetrics serves the Prometheus metrics on addr.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		log.Fatalf("Error serving metrics: %s", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runningLogAge is how recently logs/latest.log must have been written to
+// for the server to be considered running.
+var runningLogAge = 2 * time.Minute
+
+// detectRunningServer looks for signs of a running Minecraft server in dir
+// and returns a description of what it found, or an empty string.
+func detectRunningServer(dir string) string {
+	locks, _ := filepath.Glob(filepath.Join(dir, "*", "session.lock"))
+	locks = append(locks, filepath.Join(dir, "session.lock"))
+	for _, lock := range locks {
+		if isLocked(lock) {
+			rel, _ := filepath.Rel(dir, lock)
+			return fmt.Sprintf("`%s` is locked by a running process", rel)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "logs", "latest.log"))
+	if err == nil {
+		if age := time.Since(info.ModTime()); age < runningLogAge {
+			return fmt.Sprintf("`logs/latest.log` was written %s ago", age.Round(time.Second))
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// isLocked reports whether another process holds a lock on path, as the
+// Minecraft server does on session.lock while a world is loaded.
+func isLocked(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	lock := unix.Flock_t{Type: unix.F_WRLCK}
+	err = unix.FcntlFlock(f.Fd(), unix.F_GETLK, &lock)
+	if err != nil {
+		return false
+	}
+
+	return lock.Type != unix.F_UNLCK
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func isLocked(path string) bool {
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// parseMode parses an octal permission mode such as 0664.
+func parseMode(s string) (fs.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	return fs.FileMode(n), nil
+}
+
+// initModes parses the mode overrides of p.
+func (p *profile) initModes() error {
+	for _, m := range []struct {
+		name  string
+		value string
+		mode  *fs.FileMode
+	}{
+		{"file_mode", p.FileMode, &p.fileMode},
+		{"dir_mode", p.DirMode, &p.dirMode},
+		{"umask", p.Umask, &p.umask},
+	} {
+		if m.value == "" {
+			continue
+		}
+
+		mode, err := parseMode(m.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.name, err)
+		}
+		*m.mode = mode
+	}
+
+	return nil
+}
+
+// destMode returns the permissions a copied file or directory with the
+// source mode src gets at the destination, or ok false if it keeps the
+// mode it was written with.
+func (p *profile) destMode(src fs.FileMode) (mode fs.FileMode, ok bool) {
+	switch {
+	case src.IsDir() && p.DirMode != "":
+		return p.dirMode, true
+	case !src.IsDir() && p.FileMode != "":
+		return p.fileMode, true
+	case p.Umask != "":
+		return src.Perm() &^ p.umask, true
+	default:
+		return 0, false
+	}
+}
+
+// chmod applies the mode overrides of the job's profile to path, copied
+// from a source with the given mode. Unlike the mode files are created
+// with, this is not affected by the umask of the bot and also changes files
+// that already existed.
+func (j *job) chmod(path string, src fs.FileMode) error {
+	mode, ok := j.Profile.destMode(src)
+	if !ok {
+		return nil
+	}
+	return os.Chmod(path, mode)
+}
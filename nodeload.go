@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// loadPollInterval is how often the node load is checked while a job waits
+// for it to drop.
+const loadPollInterval = 15 * time.Second
+
+// nodeLoad limits how busy the node may be when a copy starts. The servers
+// are read from the local disk, so the load is that of the machine the bot
+// runs on.
+type nodeLoad struct {
+	// MaxLoad is the highest 1-minute load average.
+	MaxLoad float64 `yaml:"max_load"`
+
+	// MaxCPU and MaxIO are the highest share of time, in percent, that
+	// tasks spent waiting for CPU and for IO over the last 10 seconds, as
+	// reported by pressure stall information.
+	MaxCPU float64 `yaml:"max_cpu"`
+	MaxIO  float64 `yaml:"max_io"`
+
+	// Wait is how long a job waits for the load to drop before copying
+	// anyway. Without it, the job only warns.
+	Wait time.Duration `yaml:"wait"`
+}
+
+func (l *nodeLoad) init() error {
+	if l.MaxLoad == 0 && l.MaxCPU == 0 && l.MaxIO == 0 {
+		return fmt.Errorf("node_load: one of max_load, max_cpu and max_io is required")
+	} else if l.MaxLoad < 0 || l.MaxCPU < 0 || l.MaxIO < 0 || l.Wait < 0 {
+		return fmt.Errorf("node_load: limits must not be negative")
+	}
+	return nil
+}
+
+// loadLimits is nil unless node_load is configured.
+var loadLimits *nodeLoad
+
+// loadStats is the current load of the node. CPU and IO are negative if the
+// kernel does not report pressure stall information.
+type loadStats struct {
+	Load float64
+	CPU  float64
+	IO   float64
+}
+
+func (s loadStats) String() string {
+	parts := []string{fmt.Sprintf("load %.2f", s.Load)}
+	if s.CPU >= 0 {
+		parts = append(parts, fmt.Sprintf("CPU pressure %.1f%%", s.CPU))
+	}
+	if s.IO >= 0 {
+		parts = append(parts, fmt.Sprintf("IO pressure %.1f%%", s.IO))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// exceeded returns which limits s is over, or "" if none.
+func (l *nodeLoad) exceeded(s loadStats) string {
+	over := []string{}
+	if l.MaxLoad > 0 && s.Load > l.MaxLoad {
+		over = append(over, fmt.Sprintf("load %.2f > %.2f", s.Load, l.MaxLoad))
+	}
+	if l.MaxCPU > 0 && s.CPU > l.MaxCPU {
+		over = append(over, fmt.Sprintf("CPU pressure %.1f%% > %.1f%%", s.CPU, l.MaxCPU))
+	}
+	if l.MaxIO > 0 && s.IO > l.MaxIO {
+		over = append(over, fmt.Sprintf("IO pressure %.1f%% > %.1f%%", s.IO, l.MaxIO))
+	}
+	return strings.Join(over, ", ")
+}
+
+// waitForLoad holds j back while the node is busier than loadLimits allow,
+// for up to their Wait, and warns if it starts copying on a busy node.
+func waitForLoad(j *job) error {
+	l := loadLimits
+	if l == nil {
+		return nil
+	}
+
+	deadline := time.Now().Add(l.Wait)
+	waiting := false
+	for {
+		s, err := readLoad()
+		if err != nil {
+			j.warnf("Error reading node load: %s", err)
+			return nil
+		}
+
+		over := l.exceeded(s)
+		if over == "" {
+			if waiting {
+				j.logf("Node load dropped (%s)", s)
+			}
+			return nil
+		} else if !time.Now().Before(deadline) {
+			j.warnf("Copying while the node is under heavy load (%s)", over)
+			return nil
+		}
+
+		if !waiting {
+			j.logf("Node is under heavy load (%s), waiting up to %s", over, l.Wait)
+			waiting = true
+		}
+
+		select {
+		case <-j.ctx.Done():
+			return j.canceled()
+		case <-time.After(min(loadPollInterval, time.Until(deadline))):
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readLoad reads the load average and the CPU and IO pressure from /proc.
+func readLoad() (loadStats, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return loadStats{}, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return loadStats{}, fmt.Errorf("/proc/loadavg: unexpected format")
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return loadStats{}, fmt.Errorf("/proc/loadavg: %w", err)
+	}
+
+	return loadStats{
+		Load: load,
+		CPU:  readPressure("/proc/pressure/cpu"),
+		IO:   readPressure("/proc/pressure/io"),
+	}, nil
+}
+
+// readPressure returns the avg10 of the "some" line of a pressure file, or
+// -1 if it cannot be read.
+func readPressure(path string) float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+
+		v, ok := strings.CutPrefix(fields[1], "avg10=")
+		if !ok {
+			return -1
+		}
+		avg, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return -1
+		}
+		return avg
+	}
+
+	return -1
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func readLoad() (loadStats, error) {
+	return loadStats{}, errors.New("node load is only available on Linux")
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Node transports: local nodes hold their servers on a disk of the machine
+// the bot runs on, sftp nodes are mounted over SSH and wings nodes through
+// the SFTP server of Wings, one server at a time.
+const (
+	transportLocal = "local"
+	transportSFTP  = "sftp"
+	transportWings = "wings"
+)
+
+// wingsSFTPPort is the port the SFTP server of Wings listens on by default.
+const wingsSFTPPort = 2022
+
+// node is a machine servers run on. Servers not attached to any node are
+// local, below SERVER_BASE_DIR.
+type node struct {
+	Name      string `yaml:"name"`
+	Transport string `yaml:"transport"`
+
+	// BaseDir is where the server directories are on the node. It defaults
+	// to SERVER_BASE_DIR for local nodes and to the Pterodactyl volumes
+	// directory for sftp nodes. Wings nodes expose each server on its own.
+	BaseDir string `yaml:"base_dir"`
+
+	// Host, Port and User are where remote nodes are reached. Wings nodes
+	// log in as the panel user User with Password; sftp nodes preferably
+	// with IdentityFile.
+	Host         string `yaml:"host"`
+	Port         int    `yaml:"port"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	IdentityFile string `yaml:"identity_file"`
+
+	// Streams is the number of SSH connections remote nodes are mounted
+	// with, which sshfs spreads open files across. Over links with a high
+	// round trip time, more streams copy small files faster. It needs sshfs
+	// 3.7 or later.
+	Streams int `yaml:"streams"`
+
+	// Servers are the UUIDs of the servers on this node.
+	Servers []string `yaml:"servers"`
+}
+
+// nodes are the configured nodes.
+var nodes []*node
+
+func (n *node) init() error {
+	if n.Name == "" {
+		return fmt.Errorf("no name")
+	}
+
+	switch n.Transport {
+	case "", transportLocal:
+		n.Transport = transportLocal
+		if n.BaseDir == "" {
+			n.BaseDir = baseDir
+		}
+		return nil
+	case transportSFTP:
+		if n.BaseDir == "" {
+			n.BaseDir = "/var/lib/pterodactyl/volumes"
+		}
+		if n.Port == 0 {
+			n.Port = 22
+		}
+	case transportWings:
+		if n.Port == 0 {
+			n.Port = wingsSFTPPort
+		}
+		if n.Password == "" {
+			return fmt.Errorf("%s: password is required for wings", n.Name)
+		}
+	default:
+		return fmt.Errorf("%s: unknown transport %q", n.Name, n.Transport)
+	}
+
+	if n.Streams < 0 {
+		return fmt.Errorf("%s: streams must not be negative", n.Name)
+	} else if n.Streams == 0 {
+		n.Streams = 1
+	}
+
+	if n.Host == "" || n.User == "" {
+		return fmt.Errorf("%s: host and user are required for %s", n.Name, n.Transport)
+	}
+
+	return nil
+}
+
+func (n *node) remote() bool {
+	return n.Transport != transportLocal
+}
+
+// mountDir is where remote nodes are mounted.
+func (n *node) mountDir() string {
+	dir, err := filepath.Abs(filepath.Join(dataDir, "nodes", n.Name))
+	if err != nil {
+		return filepath.Join(dataDir, "nodes", n.Name)
+	}
+	return dir
+}
+
+// dir returns the directory of server on n.
+func (n *node) dir(server string) string {
+	if n.remote() {
+		return filepath.Join(n.mountDir(), server)
+	}
+	return filepath.Join(n.BaseDir, server)
+}
+
+// root is the directory the servers of n are below.
+func (n *node) root() string {
+	if n.remote() {
+		return n.mountDir()
+	}
+	return n.BaseDir
+}
+
+// mount mounts the directory of server on n unless it already is.
+func (n *node) mount(server string) error {
+	var dir, remote, user string
+	switch n.Transport {
+	case transportSFTP:
+		dir, remote, user = n.mountDir(), n.BaseDir, n.User
+	case transportWings:
+		// Wings tells servers apart by the short ID in the user name.
+		dir, remote, user = n.dir(server), "/", fmt.Sprintf("%s.%s", n.User, shortID(server))
+	default:
+		return nil
+	}
+
+	if point, fsType := findMount(dir); point == dir && fsType == "fuse.sshfs" {
+		_, err := os.Stat(dir)
+		if err == nil {
+			return nil
+		}
+
+		// sshfs has given up on the connection, so mount it again.
+		err = exec.Command("fusermount", "-u", "-z", dir).Run()
+		if err != nil {
+			return fmt.Errorf("unmounting %s on node %s: %w", server, n.Name, err)
+		}
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	options := []string{
+		"reconnect",
+		"ServerAliveInterval=15",
+		"ServerAliveCountMax=3",
+		"port=" + strconv.Itoa(n.Port),
+	}
+	if n.IdentityFile != "" {
+		options = append(options, "IdentityFile="+n.IdentityFile)
+	}
+	if n.Password != "" {
+		options = append(options, "password_stdin")
+	}
+	if n.Streams > 1 {
+		options = append(options, "max_conns="+strconv.Itoa(n.Streams))
+	}
+
+	cmd := exec.Command("sshfs", "-o", strings.Join(options, ","), fmt.Sprintf("%s@%s:%s", user, n.Host, remote), dir)
+	if n.Password != "" {
+		cmd.Stdin = strings.NewReader(n.Password + "\n")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		return fmt.Errorf("mounting %s on node %s: %w: %s", server, n.Name, err, msg)
+	} else if err != nil {
+		return fmt.Errorf("mounting %s on node %s: %w", server, n.Name, err)
+	}
+
+	return nil
+}
+
+// shortID returns the identifier Pterodactyl shows for a server UUID.
+func shortID(server string) string {
+	id, _, _ := strings.Cut(server, "-")
+	return id
+}
+
+// findNode returns the node server is attached to, or nil if it is a local
+// server below SERVER_BASE_DIR.
+func findNode(server string) *node {
+	for _, n := range nodes {
+		for _, s := range n.Servers {
+			if s == server {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// serverRoot returns the directory server is expected below.
+func serverRoot(server string) string {
+	if n := findNode(server); n != nil {
+		return n.root()
+	}
+	return baseDir
+}
+
+// mountServer makes the directory of server available if it is on a remote
+// node.
+func mountServer(server string) error {
+	n := findNode(server)
+	if n == nil {
+		return nil
+	}
+	return n.mount(server)
+}
+
+// transport describes how server is reached, such as "local" or
+// "sftp via eu-2".
+func transport(server string) string {
+	n := findNode(server)
+	if n == nil || !n.remote() {
+		return transportLocal
+	}
+	return fmt.Sprintf("%s via %s", n.Transport, n.Name)
+}
+
+// mount makes the servers of p available.
+func (p *profile) mount() error {
+	servers := []string{p.Destination}
+	if p.Git == nil && p.Artifact == nil {
+		servers = append(servers, p.Source)
+	}
+	if p.Standby != "" {
+		servers = append(servers, p.Standby)
+	}
+
+	for _, server := range servers {
+		err := mountServer(server)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streams returns the number of connections path is reached through, which
+// is 1 for local paths.
+func streams(path string) int {
+	for _, n := range nodes {
+		if n.remote() && isWithin(n.mountDir(), path) {
+			return n.Streams
+		}
+	}
+	return 1
+}
+
+// unmountNodes unmounts the remote nodes, waiting for writes in flight to
+// finish. Mounts that are still busy are detached once they are not.
+func unmountNodes() {
+	for _, n := range nodes {
+		if !n.remote() {
+			continue
+		}
+
+		dirs := []string{n.mountDir()}
+		if n.Transport == transportWings {
+			dirs = dirs[:0]
+			for _, server := range n.Servers {
+				dirs = append(dirs, n.dir(server))
+			}
+		}
+
+		for _, dir := range dirs {
+			if point, fsType := findMount(dir); point != dir || fsType != "fuse.sshfs" {
+				continue
+			}
+
+			err := exec.Command("fusermount", "-u", dir).Run()
+			if err != nil {
+				log.Printf("Error unmounting %s, detaching it instead: %s", dir, err)
+				err = exec.Command("fusermount", "-u", "-z", dir).Run()
+			}
+			if err != nil {
+				log.Printf("Error detaching %s: %s", dir, err)
+			}
+		}
+	}
+}
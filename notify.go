@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Notification is a chat-agnostic message about the state of a copy.
+type Notification struct {
+	Color       int
+	Title       string
+	Description string
+	Fields      []NotificationField
+
+	// Files are attached by services that support attachments and left out
+	// by the others.
+	Files []NotificationFile
+
+	// Actions are shown as buttons by services that support them.
+	Actions []NotificationAction
+
+	// Selects are shown as select menus above the actions by services that
+	// support them.
+	Selects []NotificationSelect
+}
+
+type NotificationField struct {
+	Name  string
+	Value string
+}
+
+type NotificationFile struct {
+	Name string
+	Data []byte
+}
+
+// NotificationAction is a button routed to the component named by the
+// prefix of its ID.
+type NotificationAction struct {
+	Label string
+	ID    string
+}
+
+// NotificationSelect is a select menu routed to the component named by the
+// prefix of its ID.
+type NotificationSelect struct {
+	ID          string
+	Placeholder string
+	Choices     []NotificationChoice
+}
+
+type NotificationChoice struct {
+	Label       string
+	Value       string
+	Description string
+	Default     bool
+}
+
+// Notifier delivers notifications to a chat service.
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func notifyAll(notifiers []Notifier, n *Notification) {
+	for _, notifier := range notifiers {
+		err := notifier.Notify(n)
+		if err != nil {
+			log.Printf("Error sending notification: %s", err)
+		}
+	}
+}
+
+// emoji replaces the Discord emoji shortcodes used in notifications for
+// services that do not understand them.
+var emoji = strings.NewReplacer(
+	":warning:", "⚠️",
+	":white_check_mark:", "✅",
+	":x:", "❌",
+	":hourglass:", "⌛",
+	":leftwards_arrow_with_hook:", "↩️",
+)
+
+func (n *Notification) text() string {
+	var b strings.Builder
+	if n.Title != "" {
+		fmt.Fprintf(&b, "%s\n", n.Title)
+	}
+	if n.Description != "" {
+		fmt.Fprintf(&b, "%s\n", n.Description)
+	}
+	for _, f := range n.Fields {
+		fmt.Fprintf(&b, "\n%s\n%s\n", f.Name, f.Value)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+type slackNotifier struct {
+	webhookURL string
+}
+
+func (s *slackNotifier) Notify(n *Notification) error {
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	type attachment struct {
+		Color  string  `json:"color"`
+		Title  string  `json:"title,omitempty"`
+		Text   string  `json:"text,omitempty"`
+		Fields []field `json:"fields,omitempty"`
+	}
+
+	a := attachment{
+		Color: fmt.Sprintf("#%06x", n.Color),
+		Title: n.Title,
+		Text:  n.Description,
+	}
+	for _, f := range n.Fields {
+		a.Fields = append(a.Fields, field{Title: f.Name, Value: f.Value})
+	}
+
+	return postJSON(s.webhookURL, map[string]any{"attachments": []attachment{a}})
+}
+
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+func (t *telegramNotifier) Notify(n *Notification) error {
+	// HTML only needs <, > and & escaped, unlike Markdown, which rejects
+	// messages with an unpaired _ or * in a name.
+	var b strings.Builder
+	if n.Title != "" {
+		fmt.Fprintf(&b, "<b>%s</b>\n", html.EscapeString(n.Title))
+	}
+	if n.Description != "" {
+		fmt.Fprintf(&b, "%s\n", html.EscapeString(n.Description))
+	}
+	for _, f := range n.Fields {
+		fmt.Fprintf(&b, "\n<b>%s</b>\n%s\n", html.EscapeString(f.Name), html.EscapeString(f.Value))
+	}
+
+	return postJSON(fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", t.token), map[string]any{
+		"chat_id":    t.chatID,
+		"text":       emoji.Replace(b.String()),
+		"parse_mode": "HTML",
+	})
+}
+
+// postJSON posts body to endpoint. Errors leave out endpoint, as webhook and
+// bot API URLs carry their credentials.
+func postJSON(endpoint string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Post(endpoint, "application/json", bytes.NewReader(data))
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request failed: %w", urlErr.Op, urlErr.Err)
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
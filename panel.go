@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// panelClient talks to the Pterodactyl client API.
+type panelClient struct {
+	url string
+	key string
+}
+
+// panel is nil unless PANEL_URL and PANEL_API_KEY are set.
+var panel *panelClient
+
+func newPanelClient(url string, key string) *panelClient {
+	return &panelClient{url: strings.TrimSuffix(url, "/"), key: key}
+}
+
+func (c *panelClient) do(method string, path string, body any, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: unexpected status %s", method, path, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// power sends a power signal (start, stop, restart or kill) to server.
+func (c *panelClient) power(server string, signal string) error {
+	return c.do(http.MethodPost, fmt.Sprintf("/api/client/servers/%s/power", server), map[string]string{"signal": signal}, nil)
+}
+
+// websocket returns the credentials for the console websocket of server.
+func (c *panelClient) websocket(server string) (token string, socket string, err error) {
+	var resp struct {
+		Data struct {
+			Token  string `json:"token"`
+			Socket string `json:"socket"`
+		} `json:"data"`
+	}
+	err = c.do(http.MethodGet, fmt.Sprintf("/api/client/servers/%s/websocket", server), nil, &resp)
+	return resp.Data.Token, resp.Data.Socket, err
+}
+
+// state returns the current power state of server, such as "running" or
+// "offline".
+func (c *panelClient) state(server string) (string, error) {
+	state, _, err := c.resources(server)
+	return state, err
+}
+
+// resources returns the current power state of server and how long it has
+// been up.
+func (c *panelClient) resources(server string) (string, time.Duration, error) {
+	var resp struct {
+		Attributes struct {
+			CurrentState string `json:"current_state"`
+			Resources    struct {
+				Uptime int64 `json:"uptime"`
+			} `json:"resources"`
+		} `json:"attributes"`
+	}
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/client/servers/%s/resources", server), nil, &resp)
+	return resp.Attributes.CurrentState, time.Duration(resp.Attributes.Resources.Uptime) * time.Millisecond, err
+}
+
+// waitForState polls server until it reaches state or timeout elapses.
+func (c *panelClient) waitForState(server string, state string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := c.state(server)
+		if err != nil {
+			return err
+		} else if current == state {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server is still %s after %s", current, timeout)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// command runs a console command on server.
+func (c *panelClient) command(server string, command string) error {
+	return c.do(http.MethodPost, fmt.Sprintf("/api/client/servers/%s/command", server), map[string]string{"command": command}, nil)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// defaultPanelPermission is the permission a user needs on the destination
+// server to release to it, which is writing files.
+const defaultPanelPermission = "file.update"
+
+// panelPerms is nil unless releases are checked against the panel.
+var panelPerms *panelPermissions
+
+// panelPermissions lets users release only to servers they could change the
+// files of in the panel themselves, as the owner, an admin or a subuser.
+type panelPermissions struct {
+	// Users maps the users of the bot, such as Discord user IDs, to the IDs
+	// of their panel users. Accounts linked with /link take precedence.
+	Users map[string]int `yaml:"users"`
+
+	// Require refuses users that are not mapped. Otherwise they are only
+	// checked against ALLOWED_USERS.
+	Require bool `yaml:"require"`
+
+	// Permission is the subuser permission needed, file.update by default.
+	Permission string `yaml:"permission"`
+}
+
+func (pp *panelPermissions) init() error {
+	if panel == nil || panelApp == nil {
+		return fmt.Errorf("panel_permissions requires PANEL_URL, PANEL_API_KEY and PANEL_APPLICATION_KEY")
+	}
+
+	if pp.Permission == "" {
+		pp.Permission = defaultPanelPermission
+	}
+	return nil
+}
+
+// check returns an error if user may not release to server.
+func (pp *panelPermissions) check(user string, server string) error {
+	panelUser, ok := pp.Users[user]
+	if link := findLink(user); link != nil {
+		panelUser, ok = link.PanelUserID, true
+	}
+	if !ok {
+		if pp.Require {
+			return fmt.Errorf("your account is not linked to a panel user")
+		}
+		return nil
+	}
+
+	id, err := internalID(server)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Attributes struct {
+			User          int `json:"user"`
+			Relationships struct {
+				Subusers struct {
+					Data []struct {
+						Attributes struct {
+							UserID      int      `json:"user_id"`
+							Permissions []string `json:"permissions"`
+						} `json:"attributes"`
+					} `json:"data"`
+				} `json:"subusers"`
+			} `json:"relationships"`
+		} `json:"attributes"`
+	}
+	err = panelApp.do(http.MethodGet, fmt.Sprintf("/api/application/servers/%d?include=subusers", id), nil, &resp)
+	if err != nil {
+		return err
+	} else if resp.Attributes.User == panelUser {
+		return nil
+	}
+
+	for _, s := range resp.Attributes.Relationships.Subusers.Data {
+		if s.Attributes.UserID == panelUser && slices.Contains(s.Attributes.Permissions, pp.Permission) {
+			return nil
+		}
+	}
+
+	var u struct {
+		Attributes struct {
+			RootAdmin bool `json:"root_admin"`
+		} `json:"attributes"`
+	}
+	err = panelApp.do(http.MethodGet, "/api/application/users/"+strconv.Itoa(panelUser), nil, &u)
+	if err != nil {
+		return err
+	} else if u.Attributes.RootAdmin {
+		return nil
+	}
+
+	return fmt.Errorf("your panel user does not have the %s permission on server `%s`", pp.Permission, server)
+}
+
+// authorizeRelease reports whether the user of ctx may release to the
+// destination of p according to the panel, and replies with an error if
+// not.
+func authorizeRelease(ctx CommandContext, p *profile) bool {
+	if panelPerms == nil || filepath.IsAbs(p.Destination) {
+		return true
+	}
+
+	err := panelPerms.check(ctx.User(), p.Destination)
+	if err != nil {
+		log.Printf("Refused release of %s for %s: %s", p.Name, ctx.User(), err)
+		replyError(ctx, "You may not release `%s`: %s", p.Name, err)
+		return false
+	}
+	return true
+}
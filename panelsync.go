@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// panelSync copies settings of the source server that are kept by the panel
+// rather than in files, such as the JVM flags of the startup command.
+type panelSync struct {
+	// Startup copies the startup command.
+	Startup bool `yaml:"startup"`
+
+	// Variables are the egg variables to copy, such as JAVA_ARGS.
+	Variables []string `yaml:"variables"`
+}
+
+func (s *panelSync) init(p *profile) error {
+	if !s.Startup && len(s.Variables) == 0 {
+		return fmt.Errorf("panel_sync: startup or variables is required")
+	} else if panel == nil || panelApp == nil {
+		return fmt.Errorf("panel_sync requires PANEL_URL, PANEL_API_KEY and PANEL_APPLICATION_KEY")
+	} else if p.Git != nil || p.Artifact != nil || filepath.IsAbs(p.Source) || filepath.IsAbs(p.Destination) {
+		return fmt.Errorf("panel_sync requires the source and destination to be server UUIDs")
+	}
+	return nil
+}
+
+// environment returns the egg variables of s, leaving out those the panel
+// adds to every server itself.
+func (s *panelServer) environment() map[string]any {
+	env := map[string]any{}
+	for k, v := range s.Container.Environment {
+		if k != "STARTUP" && !strings.HasPrefix(k, "P_SERVER_") {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// syncPanelSettings copies the startup command and egg variables selected by
+// the profile of j from the source to the destination server, and records
+// what changed.
+func syncPanelSettings(ctx context.Context, j *job) error {
+	s := j.Profile.PanelSync
+
+	_, span := j.startPhase(ctx, "panel_sync")
+	changes, err := func() ([]string, error) {
+		src, err := lookupServer(j.Profile.Source)
+		if err != nil {
+			return nil, fmt.Errorf("source: %w", err)
+		}
+		dst, err := lookupServer(j.Profile.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("destination: %w", err)
+		}
+		if src.Egg != dst.Egg {
+			return nil, fmt.Errorf("source uses egg %d but destination uses egg %d", src.Egg, dst.Egg)
+		}
+
+		changes := []string{}
+		startup := dst.Container.StartupCommand
+		if s.Startup && startup != src.Container.StartupCommand {
+			startup = src.Container.StartupCommand
+			changes = append(changes, "startup command")
+		}
+
+		srcEnv, env := src.environment(), dst.environment()
+		for _, name := range s.Variables {
+			v, ok := srcEnv[name]
+			if !ok {
+				return nil, fmt.Errorf("source has no variable %s", name)
+			} else if fmt.Sprint(env[name]) != fmt.Sprint(v) {
+				env[name] = v
+				changes = append(changes, name)
+			}
+		}
+
+		if len(changes) == 0 {
+			return changes, nil
+		}
+
+		body := map[string]any{
+			"startup":      startup,
+			"environment":  env,
+			"egg":          dst.Egg,
+			"image":        dst.Container.Image,
+			"skip_scripts": true,
+		}
+		return changes, panelApp.do(http.MethodPatch, fmt.Sprintf("/api/application/servers/%d/startup", dst.ID), body, nil)
+	}()
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.PanelChanges = changes
+	j.mu.Unlock()
+	if len(changes) > 0 {
+		j.logf("Synced %s from the source server", strings.Join(changes, ", "))
+	}
+	return nil
+}
+
+// lookupServer returns server as the application API describes it.
+func lookupServer(server string) (*panelServer, error) {
+	id, err := internalID(server)
+	if err != nil {
+		return nil, err
+	}
+	return panelApp.applicationServer(id)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// jobOption selects a running job by ID.
+var jobOption = &CommandOption{
+	Name:        "job",
+	Description: "ID of the job, if more than one is running",
+	Type:        OptionString,
+}
+
+// Pause halts j before its next file or chunk until it is resumed. It
+// returns false if j was already paused.
+func (j *job) Pause() bool {
+	j.mu.Lock()
+	if j.paused != nil {
+		j.mu.Unlock()
+		return false
+	}
+	j.paused = make(chan struct{})
+	j.pausedAt = time.Now()
+	j.mu.Unlock()
+
+	j.emit(Event{Type: EventPaused})
+	return true
+}
+
+// Resume continues j after Pause. It returns false if j was not paused.
+func (j *job) Resume() bool {
+	j.mu.Lock()
+	if j.paused == nil {
+		j.mu.Unlock()
+		return false
+	}
+	close(j.paused)
+	j.paused = nil
+	if j.Phase == "copy" {
+		j.pausedFor += time.Since(j.pausedAt)
+	}
+	j.mu.Unlock()
+
+	j.emit(Event{Type: EventResumed})
+	return true
+}
+
+// checkpoint waits while j is paused and returns an error once j has been
+// canceled.
+func (j *job) checkpoint() error {
+	j.mu.Lock()
+	paused := j.paused
+	j.mu.Unlock()
+
+	if paused != nil {
+		select {
+		case <-paused:
+		case <-j.ctx.Done():
+		}
+	}
+	return j.canceled()
+}
+
+// selectJob returns the job given by the job option of ctx, or the only
+// running job without it. It replies with an error and returns nil if there
+// is none.
+func selectJob(ctx CommandContext) *job {
+	if id := ctx.Option("job"); id != "" {
+		j := findJob(id)
+		if j == nil || !canSee(ctx, j.Profile) {
+			replyError(ctx, "Job `%s` not found!", id)
+			return nil
+		}
+		return j
+	}
+
+	running := []*job{}
+	for _, j := range runningJobs() {
+		if canSee(ctx, j.Profile) {
+			running = append(running, j)
+		}
+	}
+	switch len(running) {
+	case 0:
+		replyError(ctx, "No job is running!")
+		return nil
+	case 1:
+		return running[0]
+	default:
+		replyError(ctx, "%d jobs are running, please select one with the `job` option.", len(running))
+		return nil
+	}
+}
+
+// runningJobs returns the jobs that have not finished yet.
+func runningJobs() []*job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	running := []*job{}
+	for _, j := range jobs {
+		j.mu.Lock()
+		done := j.Done
+		j.mu.Unlock()
+		if !done {
+			running = append(running, j)
+		}
+	}
+	return running
+}
+
+func handlePause(ctx CommandContext) {
+	if j := selectJob(ctx); j != nil {
+		pauseJob(ctx, j)
+	}
+}
+
+func handleResume(ctx CommandContext) {
+	if j := selectJob(ctx); j != nil {
+		resumeJob(ctx, j)
+	}
+}
+
+func handlePauseButton(ctx CommandContext, id string) {
+	if j := findJob(id); j == nil {
+		replyError(ctx, "Job `%s` not found!", id)
+	} else {
+		pauseJob(ctx, j)
+	}
+}
+
+func handleResumeButton(ctx CommandContext, id string) {
+	if j := findJob(id); j == nil {
+		replyError(ctx, "Job `%s` not found!", id)
+	} else {
+		resumeJob(ctx, j)
+	}
+}
+
+func pauseJob(ctx CommandContext, j *job) {
+	j.mu.Lock()
+	done := j.Done
+	j.mu.Unlock()
+	if done {
+		replyError(ctx, "Job `%s` has already finished!", j.ID)
+		return
+	} else if !j.Pause() {
+		replyError(ctx, "Job `%s` is already paused!", j.ID)
+		return
+	}
+
+	j.logf("Paused by %s", ctx.User())
+	_, err := ctx.Reply(&Notification{
+		Color:       0xffff00,
+		Description: fmt.Sprintf(":pause_button: Paused job `%s`. It holds off on reading and writing files until it is resumed.", j.ID),
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+func resumeJob(ctx CommandContext, j *job) {
+	if !j.Resume() {
+		replyError(ctx, "Job `%s` is not paused!", j.ID)
+		return
+	}
+
+	j.logf("Resumed by %s", ctx.User())
+	_, err := ctx.Reply(&Notification{
+		Color:       0x00ff00,
+		Description: fmt.Sprintf(":arrow_forward: Resumed job `%s`.", j.ID),
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
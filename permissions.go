@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+)
+
+// everyone matches all users in the permissions.
+const everyone = "*"
+
+// permissions is nil unless a permission matrix is configured.
+var permissions *permissionMatrix
+
+// permissionMatrix decides who may do what, per command and per profile.
+// Users are listed by their ID on the frontend, by group name, or as "*" for
+// everyone.
+type permissionMatrix struct {
+	// Groups name sets of users.
+	Groups map[string][]string `yaml:"groups"`
+
+	// Commands are who may use each command, by name, such as "stats" or
+	// "profile export". Buttons are listed by name too, such as "cancel".
+	// The "*" entry applies to those not listed, and without it they are
+	// open to everyone.
+	Commands map[string][]string `yaml:"commands"`
+
+	// DryRun and Release are who may dry-run and release the profiles
+	// matching each glob, such as "staging-*". A user may if any matching
+	// glob lists them. Without any globs, everyone who may use the command
+	// may.
+	DryRun  map[string][]string `yaml:"dry_run"`
+	Release map[string][]string `yaml:"release"`
+
+	// ManageProfiles are who may create, import, bootstrap and provision
+	// profiles. Without it, everyone who may use the command may.
+	ManageProfiles []string `yaml:"manage_profiles"`
+}
+
+// manageRoutes are the commands and components that add profiles.
+var manageRoutes = []string{
+	"bootstrap",
+	"provision",
+	"profile create",
+	"profile import",
+	"profile-wizard",
+	"profile-preset",
+	"profile-power",
+	"profile-create",
+	"profile-cancel",
+}
+
+func (m *permissionMatrix) init() error {
+	if len(allowedUsers) > 0 {
+		return fmt.Errorf("cannot be combined with ALLOWED_USERS")
+	}
+
+	for _, rules := range []map[string][]string{m.DryRun, m.Release} {
+		for pattern := range rules {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid glob %q: %w", pattern, err)
+			}
+		}
+	}
+
+	for name, members := range m.Groups {
+		if name == everyone || len(members) == 0 {
+			return fmt.Errorf("groups.%s: no users", name)
+		}
+	}
+	return nil
+}
+
+// includes reports whether user is one of the entries.
+func (m *permissionMatrix) includes(entries []string, user string) bool {
+	for _, e := range entries {
+		if e == everyone || e == user || slices.Contains(m.Groups[e], user) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayUse reports whether user may use the command or component route.
+func (m *permissionMatrix) mayUse(route string, user string) bool {
+	entries, ok := m.Commands[route]
+	if !ok {
+		entries, ok = m.Commands[everyone]
+	}
+	if ok && !m.includes(entries, user) {
+		return false
+	}
+
+	if slices.Contains(manageRoutes, route) && m.ManageProfiles != nil {
+		return m.includes(m.ManageProfiles, user)
+	}
+	return true
+}
+
+// mayRelease reports whether user may release p, or dry-run it if dryRun
+// is set.
+func (m *permissionMatrix) mayRelease(p *profile, dryRun bool, user string) bool {
+	rules := m.Release
+	if dryRun {
+		rules = m.DryRun
+	}
+	if len(rules) == 0 {
+		return true
+	}
+
+	for pattern, entries := range rules {
+		if ok, _ := path.Match(pattern, p.Name); ok && m.includes(entries, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseTargets returns the profiles a release command would release, as
+// the handler resolves them. Profiles it would refuse are left out, so the
+// handler can report them.
+func releaseTargets(route string, ctx CommandContext) []*profile {
+	names := []string{}
+	switch route {
+	case "copy":
+		if name := ctx.Option("profile"); name != "" {
+			names = append(names, name)
+		} else if visible := contextProfiles(ctx); len(visible) == 1 {
+			names = append(names, visible[0].Name)
+		}
+	case "release-all":
+		names = groups[ctx.Option("group")]
+	}
+
+	targets := []*profile{}
+	for _, name := range names {
+		if p := findProfile(name); p != nil && canSee(ctx, p) {
+			targets = append(targets, p.target())
+		}
+	}
+	return targets
+}
+
+// checkPermissions is the middleware refusing what the permission matrix
+// does not allow.
+func checkPermissions(route string, next HandlerFunc) HandlerFunc {
+	return func(ctx CommandContext) {
+		m := permissions
+		if m == nil {
+			next(ctx)
+			return
+		}
+
+		user := ctx.User()
+		if !m.mayUse(route, user) {
+			log.Printf("Refused %s for %s", route, user)
+			replyError(ctx, "You are not allowed to use `%s`!", route)
+			return
+		}
+
+		for _, p := range releaseTargets(route, ctx) {
+			dryRun := p.dryRun(ctx.Option("dry-run"))
+			if m.mayRelease(p, dryRun, user) {
+				continue
+			}
+
+			action := "release"
+			if dryRun {
+				action = "dry-run"
+			}
+			log.Printf("Refused %s of %s for %s", action, p.Name, user)
+			replyError(ctx, "You are not allowed to %s `%s`!", action, p.Name)
+			return
+		}
+
+		next(ctx)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// presenceInterval is how often the presence of a bot is updated at most,
+// well within what Discord allows.
+const presenceInterval = 15 * time.Second
+
+// presenceSink shows what the bots are doing as their Discord status, such
+// as "Copying lobby 43%" or "2 jobs queued", so staff can tell whether a
+// release is in flight without asking.
+type presenceSink struct {
+	mu   sync.Mutex
+	bots []*presenceBot
+
+	// changed wakes up run when a job started, changed phase or finished.
+	changed chan struct{}
+}
+
+type presenceBot struct {
+	frontend *discordFrontend
+
+	// status is the status last shown.
+	status string
+}
+
+func newPresenceSink() *presenceSink {
+	return &presenceSink{changed: make(chan struct{}, 1)}
+}
+
+// add shows the jobs d can see as its presence.
+func (s *presenceSink) add(d *discordFrontend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bots = append(s.bots, &presenceBot{frontend: d})
+}
+
+func (s *presenceSink) Handle(j *job, e *Event) {
+	switch e.Type {
+	case EventStarted, EventPhase, EventPaused, EventResumed, EventFinished:
+	default:
+		return
+	}
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// run updates the presences whenever jobs change, and every
+// presenceInterval for the copy progress. Changes right after an update wait
+// until presenceInterval has passed since.
+func (s *presenceSink) run() {
+	for {
+		s.update()
+		updated := time.Now()
+
+		select {
+		case <-s.changed:
+		case <-time.After(presenceInterval):
+		}
+		time.Sleep(time.Until(updated.Add(presenceInterval)))
+	}
+}
+
+func (s *presenceSink) update() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, b := range s.bots {
+		status, busy := presenceStatus(b.frontend.allows)
+		if status == b.status {
+			continue
+		}
+
+		idle := "idle"
+		if busy {
+			idle = "online"
+		}
+		err := b.frontend.session.UpdateStatusComplex(discordgo.UpdateStatusData{
+			Status: idle,
+			Activities: []*discordgo.Activity{{
+				Name:  "Custom Status",
+				Type:  discordgo.ActivityTypeCustom,
+				State: status,
+			}},
+		})
+		if err != nil {
+			log.Printf("Error updating Discord presence: %s", err)
+			continue
+		}
+		b.status = status
+	}
+}
+
+// presenceStatus describes the unfinished jobs of the profiles allows lets
+// through, and whether any of them is running.
+func presenceStatus(allows func(*profile) bool) (string, bool) {
+	jobsMu.Lock()
+	all := make([]*job, 0, len(jobs))
+	for _, j := range jobs {
+		if allows == nil || allows(j.Profile) {
+			all = append(all, j)
+		}
+	}
+	jobsMu.Unlock()
+
+	sort.Slice(all, func(a, b int) bool { return all[a].started().Before(all[b].started()) })
+
+	var running []string
+	queued := 0
+	for _, j := range all {
+		j.mu.Lock()
+		done := j.Done
+		j.mu.Unlock()
+		if done {
+			continue
+		}
+
+		// Jobs waiting for their turn or for other jobs of a group have
+		// not started a phase yet.
+		p := j.progress()
+		if p.Phase == "" || p.Phase == "queued" {
+			queued++
+			continue
+		}
+		running = append(running, presenceJob(j, p))
+	}
+
+	var status string
+	switch {
+	case len(running) == 1:
+		status = running[0]
+	case len(running) > 1:
+		status = fmt.Sprintf("%s (+%d more)", running[0], len(running)-1)
+	}
+
+	if queued > 0 {
+		if status != "" {
+			status += ", "
+		}
+		if queued == 1 {
+			status += "1 job queued"
+		} else {
+			status += fmt.Sprintf("%d jobs queued", queued)
+		}
+	}
+
+	if status == "" {
+		return "Idle", false
+	}
+	return status, len(running) > 0
+}
+
+// presenceJob describes a running job, with its progress while copying.
+func presenceJob(j *job, p progress) string {
+	verb := "Releasing"
+	if p.Paused {
+		verb = "Paused"
+	} else if p.Phase == "copy" {
+		verb = "Copying"
+	}
+
+	if p.Phase == "copy" && p.TotalBytes > 0 {
+		return fmt.Sprintf("%s %s %d%%", verb, j.Profile.Name, p.Bytes*100/p.TotalBytes)
+	}
+	return fmt.Sprintf("%s %s (%s)", verb, j.Profile.Name, p.Phase)
+}
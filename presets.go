@@ -0,0 +1,82 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed presets/*.yml
+var presetFiles embed.FS
+
+// preset is a named set of rules for a kind of game server that profiles can
+// select and extend.
+type preset struct {
+	Description  string   `yaml:"description"`
+	Extends      string   `yaml:"extends"`
+	Keep         []string `yaml:"keep"`
+	KeepIfExists []string `yaml:"keep_if_exists"`
+	SeedOnce     []string `yaml:"seed_once"`
+	Exclude      []string `yaml:"exclude"`
+	Merge        []string `yaml:"merge"`
+}
+
+// presets are loaded from the presets directory, keyed by file name. They
+// are loaded as a variable rather than in init so that they are available
+// when profiles are loaded.
+var presets = loadPresets()
+
+func loadPresets() map[string]*preset {
+	presets := map[string]*preset{}
+	entries, err := presetFiles.ReadDir("presets")
+	if err != nil {
+		log.Fatalf("Error loading presets: %s", err)
+	}
+
+	for _, entry := range entries {
+		p := &preset{}
+		data, err := presetFiles.ReadFile(path.Join("presets", entry.Name()))
+		if err == nil {
+			err = yaml.Unmarshal(data, p)
+		}
+		if err != nil {
+			log.Fatalf("Error loading preset %s: %s", entry.Name(), err)
+		}
+
+		presets[strings.TrimSuffix(entry.Name(), ".yml")] = p
+	}
+
+	return presets
+}
+
+// resolvePreset returns the rules of the named preset including those of the
+// presets it extends.
+func resolvePreset(name string) (*preset, error) {
+	resolved := &preset{}
+
+	seen := map[string]bool{}
+	for name != "" {
+		if seen[name] {
+			return nil, fmt.Errorf("preset %q extends itself", name)
+		}
+		seen[name] = true
+
+		p, ok := presets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q", name)
+		}
+
+		resolved.Keep = append(resolved.Keep, p.Keep...)
+		resolved.KeepIfExists = append(resolved.KeepIfExists, p.KeepIfExists...)
+		resolved.SeedOnce = append(resolved.SeedOnce, p.SeedOnce...)
+		resolved.Exclude = append(resolved.Exclude, p.Exclude...)
+		resolved.Merge = append(resolved.Merge, p.Merge...)
+		name = p.Extends
+	}
+
+	return resolved, nil
+}
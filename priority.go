@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Job priorities: interactive jobs, started by someone waiting for them,
+// start right away at full speed. Background jobs, such as scheduled syncs,
+// wait until no other job runs and copy at the background limits.
+const (
+	priorityInteractive = "interactive"
+	priorityBackground  = "background"
+)
+
+// backgroundLimits caps the background jobs.
+type backgroundLimits struct {
+	// Limit is the rate background jobs copy at most, such as 10MiB/s, in
+	// addition to the bandwidth limits.
+	Limit string `yaml:"limit"`
+
+	// Concurrency is the number of files a background job copies at once.
+	Concurrency int `yaml:"concurrency"`
+
+	limit int64
+}
+
+func (b *backgroundLimits) init() error {
+	var err error
+	b.limit, err = parseRate(b.Limit)
+	if err != nil {
+		return fmt.Errorf("background.limit: %w", err)
+	} else if b.Concurrency < 0 {
+		return fmt.Errorf("background.concurrency must not be negative")
+	}
+	return nil
+}
+
+// background is nil unless background limits are configured. Background
+// jobs are still queued without them.
+var background *backgroundLimits
+
+// backgroundLimiter is shared by the background jobs.
+var backgroundLimiter limiter
+
+func parsePriority(s string) (string, error) {
+	switch s {
+	case "", priorityInteractive:
+		return priorityInteractive, nil
+	case priorityBackground:
+		return priorityBackground, nil
+	default:
+		return "", fmt.Errorf("priority must be %q or %q", priorityInteractive, priorityBackground)
+	}
+}
+
+// queue tracks the running jobs by priority.
+var queue = struct {
+	sync.Mutex
+	interactive int
+	background  int
+
+	// changed is closed and replaced whenever a job leaves.
+	changed chan struct{}
+}{changed: make(chan struct{})}
+
+// waitForTurn blocks a background job until no other job is running, so
+// interactive jobs started in the meantime go first. Interactive jobs never
+// wait. The returned function must be called once j is done.
+func waitForTurn(j *job) (func(), error) {
+	for {
+		queue.Lock()
+		if j.Priority != priorityBackground {
+			queue.interactive++
+			queue.Unlock()
+			return func() { leaveQueue(&queue.interactive) }, nil
+		} else if queue.interactive == 0 && queue.background == 0 {
+			queue.background++
+			queue.Unlock()
+			return func() { leaveQueue(&queue.background) }, nil
+		}
+		changed := queue.changed
+		queue.Unlock()
+
+		select {
+		case <-j.ctx.Done():
+			return func() {}, j.canceled()
+		case <-changed:
+		}
+	}
+}
+
+func leaveQueue(count *int) {
+	queue.Lock()
+	defer queue.Unlock()
+
+	*count--
+	close(queue.changed)
+	queue.changed = make(chan struct{})
+}
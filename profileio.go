@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxImportSize is the largest profile file /profile import accepts.
+const maxImportSize = 1 << 20
+
+// importHostSettings lets imported profiles use the settings listed by
+// hostSettings.
+var importHostSettings bool
+
+// hostSettings returns the settings of p that run commands or mount volumes
+// on the machine of the bot, which only the config file can set unless
+// import_host_settings allows imports to.
+func (p *profile) hostSettings() []string {
+	settings := []string{}
+	if p.Git != nil {
+		settings = append(settings, "git")
+	}
+	if p.Artifact != nil {
+		settings = append(settings, "artifact")
+	}
+	if p.SourceSnapshot != nil {
+		settings = append(settings, "source_snapshot")
+	}
+	if p.Chown != "" {
+		settings = append(settings, "chown")
+	}
+	for _, server := range []string{p.Source, p.Destination, p.Standby} {
+		if n := findNode(server); server != "" && n != nil && n.remote() {
+			settings = append(settings, fmt.Sprintf("servers on node %s", n.Name))
+			break
+		}
+	}
+	return settings
+}
+
+// UnmarshalYAML keeps the YAML of the profile, so that it can be exported
+// with its comments and in the order it was written.
+func (p *profile) UnmarshalYAML(n *yaml.Node) error {
+	type plain profile
+	err := n.Decode((*plain)(p))
+	if err != nil {
+		return err
+	}
+
+	p.yaml = resolveAliases(n)
+	return nil
+}
+
+// resolveAliases returns a copy of n with the aliases replaced by what they
+// refer to, so that it can be written on its own.
+func resolveAliases(n *yaml.Node) *yaml.Node {
+	if n.Kind == yaml.AliasNode {
+		return resolveAliases(n.Alias)
+	}
+
+	c := *n
+	c.Anchor = ""
+	c.Content = nil
+	for _, child := range n.Content {
+		c.Content = append(c.Content, resolveAliases(child))
+	}
+	return &c
+}
+
+// marshal returns p as YAML, as it was written if it was read from YAML.
+func (p *profile) marshal() ([]byte, error) {
+	var v any = p.entry()
+	if p.yaml != nil {
+		v = p.yaml
+	}
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	err := enc.Encode(v)
+	if err == nil {
+		err = enc.Close()
+	}
+	return b.Bytes(), err
+}
+
+// rename changes the name of p, also in its YAML.
+func (p *profile) rename(name string) {
+	p.Name = name
+	if p.yaml == nil || p.yaml.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(p.yaml.Content); i += 2 {
+		if p.yaml.Content[i].Value == "name" {
+			p.yaml.Content[i+1].Value = name
+			p.yaml.Content[i+1].Style = 0
+		}
+	}
+}
+
+func handleProfileExport(ctx CommandContext) {
+	p := selectProfile(ctx)
+	if p == nil {
+		return
+	}
+
+	data, err := p.marshal()
+	if err != nil {
+		log.Printf("Error exporting profile %s: %s", p.Name, err)
+		replyError(ctx, "Failed to export `%s`!", p.Name)
+		return
+	}
+
+	_, err = ctx.Reply(&Notification{
+		Color:       0x87ceeb,
+		Description: fmt.Sprintf("Profile `%s`. Use `/profile import` to add it to another bot.", p.Name),
+		Files:       []NotificationFile{{Name: p.Name + ".yml", Data: data}},
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+// attachmentHosts serve the files attached on Discord, the only files
+// /profile import downloads. Other frontends pass the file as plain text,
+// which must not make the bot fetch arbitrary URLs.
+var attachmentHosts = map[string]bool{
+	"cdn.discordapp.com":   true,
+	"media.discordapp.net": true,
+}
+
+// errInvalidProfile is returned for files that do not hold a valid profile.
+// The parser errors quote the file, so they are only logged.
+var errInvalidProfile = errors.New("the file is not a valid profile, the logs of the bot have the details")
+
+// downloadProfile reads the profile in the YAML file attached at rawURL.
+func downloadProfile(rawURL string) (*profile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || !attachmentHosts[u.Hostname()] {
+		return nil, errors.New("profiles can only be imported from files attached on Discord")
+	}
+
+	resp, err := httpClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading file: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImportSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > maxImportSize {
+		return nil, fmt.Errorf("file is larger than %s", formatBytes(maxImportSize))
+	}
+
+	p := &profile{}
+	err = yaml.Unmarshal(data, p)
+	if err == nil {
+		err = checkSchema(data, p)
+	}
+	if err != nil {
+		log.Printf("Error parsing imported profile: %s", err)
+		return nil, errInvalidProfile
+	}
+	return p, nil
+}
+
+func handleProfileImport(ctx CommandContext) {
+	p, err := downloadProfile(ctx.Option("file"))
+	if err != nil {
+		log.Printf("Error importing profile: %s", err)
+		replyError(ctx, "Cannot read the profile: %s", err)
+		return
+	}
+
+	if name := ctx.Option("name"); name != "" {
+		p.rename(name)
+	}
+	err = func() error {
+		switch {
+		case p.Name == "":
+			return fmt.Errorf("the profile has no name")
+		case findProfile(p.Name) != nil:
+			return fmt.Errorf("profile `%s` already exists, use the `name` option to import it under another name", p.Name)
+		case p.AllowOutsideBaseDir:
+			return fmt.Errorf("allow_outside_base_dir can only be set in the config file")
+		}
+
+		if settings := p.hostSettings(); len(settings) > 0 && !importHostSettings {
+			return fmt.Errorf("%s can only be set in the config file unless import_host_settings is enabled", strings.Join(settings, ", "))
+		}
+
+		for _, dep := range p.DependsOn {
+			if findProfile(dep) == nil {
+				return fmt.Errorf("depends_on: unknown profile %q", dep)
+			}
+		}
+
+		err := p.init(defaultRules)
+		if err != nil {
+			return err
+		}
+
+		err = p.mount()
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(p.dstDir); err != nil {
+			return fmt.Errorf("destination: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		replyError(ctx, "Cannot import profile `%s`: %s", p.Name, err)
+		return
+	}
+
+	// Another import may have taken the name meanwhile.
+	if err := addProfile(p); err != nil {
+		replyError(ctx, "Cannot import profile `%s`: %s", p.Name, err)
+		return
+	}
+
+	n := profileNotification(p)
+	n.Color = 0x00ff00
+	n.Title = fmt.Sprintf("Imported profile %s", p.Name)
+	if err := saveProfile(p); err != nil {
+		log.Printf("Error saving profile %s: %s", p.Name, err)
+		n.Color = 0xffff00
+		n.Description = fmt.Sprintf(":warning: Profile `%s` is available until the bot restarts: %s", p.Name, err)
+	} else {
+		log.Printf("%s imported profile %s", ctx.User(), p.Name)
+	}
+
+	_, err = ctx.Reply(n)
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// draftTimeout is how long a profile started with /profile create can be
+// finished.
+const draftTimeout = 15 * time.Minute
+
+// Choices of the profile wizard.
+const (
+	noPreset = "none"
+
+	powerNone     = "none"
+	powerRestart  = "restart"
+	powerSmoke    = "smoke"
+	powerRollback = "rollback"
+)
+
+// profileDraft is a profile being set up with /profile create.
+type profileDraft struct {
+	ID          string
+	User        string
+	Name        string
+	Source      string
+	Destination string
+	Preset      string
+	Power       string
+	expires     time.Time
+}
+
+var (
+	draftsMu sync.Mutex
+	drafts   = map[string]*profileDraft{}
+)
+
+// findDraft returns the draft with id if it has not expired.
+func findDraft(id string) *profileDraft {
+	draftsMu.Lock()
+	defer draftsMu.Unlock()
+
+	for k, d := range drafts {
+		if time.Now().After(d.expires) {
+			delete(drafts, k)
+		}
+	}
+	return drafts[id]
+}
+
+// profile returns the profile the draft describes, initialized and mounted.
+func (d *profileDraft) profile() (*profile, error) {
+	if findProfile(d.Name) != nil {
+		return nil, fmt.Errorf("profile `%s` already exists", d.Name)
+	}
+
+	p := &profile{Name: d.Name, Source: d.Source, Destination: d.Destination, Preset: d.Preset}
+	switch d.Power {
+	case powerRollback:
+		p.Rollback = true
+		fallthrough
+	case powerSmoke:
+		p.SmokeCheck = &smokeCheck{}
+		fallthrough
+	case powerRestart:
+		p.Restart = true
+	}
+
+	err := p.init(defaultRules)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.mount()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(p.srcDir); err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	} else if _, err := os.Stat(p.dstDir); err != nil {
+		return nil, fmt.Errorf("destination: %w", err)
+	}
+	return p, nil
+}
+
+// notification shows the choices of the draft as select menus.
+func (d *profileDraft) notification() *Notification {
+	n := &Notification{
+		Color:       0x87ceeb,
+		Title:       fmt.Sprintf("New profile %s", d.Name),
+		Description: "Choose the keep rules and what to do with the destination server after copying, then create the profile.",
+		Fields: []NotificationField{
+			{Name: "Source", Value: fmt.Sprintf("`%s`", d.Source)},
+			{Name: "Destination", Value: fmt.Sprintf("`%s`", d.Destination)},
+		},
+	}
+
+	presetSelect := NotificationSelect{
+		ID:          "profile-preset:" + d.ID,
+		Placeholder: "Keep rules",
+		Choices: []NotificationChoice{
+			{Label: "No preset", Value: noPreset, Description: "Only keep the default keep files", Default: d.Preset == ""},
+		},
+	}
+	names := []string{}
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		presetSelect.Choices = append(presetSelect.Choices, NotificationChoice{
+			Label:       name,
+			Value:       name,
+			Description: truncateRunes(presets[name].Description, 100),
+			Default:     d.Preset == name,
+		})
+	}
+	n.Selects = append(n.Selects, presetSelect)
+
+	// Power actions need the panel and a destination server UUID.
+	if panel != nil && !filepath.IsAbs(d.Destination) {
+		power := d.Power
+		if power == "" {
+			power = powerNone
+		}
+		n.Selects = append(n.Selects, NotificationSelect{
+			ID:          "profile-power:" + d.ID,
+			Placeholder: "After copying",
+			Choices: []NotificationChoice{
+				{Label: "Leave the server as it is", Value: powerNone, Default: power == powerNone},
+				{Label: "Restart the server", Value: powerRestart, Default: power == powerRestart},
+				{Label: "Restart and check that it starts", Value: powerSmoke, Default: power == powerSmoke},
+				{Label: "Restart, check and roll back on failure", Value: powerRollback, Default: power == powerRollback},
+			},
+		})
+	}
+
+	n.Actions = []NotificationAction{
+		{Label: "Create", ID: "profile-create:" + d.ID},
+		{Label: "Cancel", ID: "profile-cancel:" + d.ID},
+	}
+	return n
+}
+
+// truncateRunes shortens s to at most n characters.
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
+
+func handleProfileCreate(ctx CommandContext) {
+	err := ctx.ShowForm(&Form{
+		ID:    "profile-wizard",
+		Title: "New profile",
+		Fields: []FormField{
+			{Name: "name", Label: "Name", Placeholder: "survival", Required: true},
+			{Name: "source", Label: "Source server UUID or directory", Placeholder: "8a5e1c3b", Required: true},
+			{Name: "destination", Label: "Destination server UUID or directory", Placeholder: "f2d4b6a8", Required: true},
+		},
+	})
+	if errors.Is(err, errFormsUnsupported) {
+		replyError(ctx, "Creating profiles interactively is only available on Discord.")
+	} else if err != nil {
+		log.Printf("Error showing form: %s", err)
+	}
+}
+
+// handleProfileWizard starts a draft from the submitted form.
+func handleProfileWizard(ctx CommandContext, _ string) {
+	id, err := randomToken()
+	if err != nil {
+		replyError(ctx, "Failed to start the profile: %s", err)
+		return
+	}
+
+	d := &profileDraft{
+		ID:          id[:12],
+		User:        ctx.User(),
+		Name:        ctx.Option("name"),
+		Source:      ctx.Option("source"),
+		Destination: ctx.Option("destination"),
+		expires:     time.Now().Add(draftTimeout),
+	}
+	if _, err := d.profile(); err != nil {
+		replyError(ctx, "Cannot create profile `%s`: %s", d.Name, err)
+		return
+	}
+
+	draftsMu.Lock()
+	drafts[d.ID] = d
+	draftsMu.Unlock()
+
+	_, err = ctx.Reply(d.notification())
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+// draftOf returns the draft of a wizard component if the user started it,
+// replying with an error otherwise.
+func draftOf(ctx CommandContext, id string) *profileDraft {
+	d := findDraft(id)
+	if d == nil {
+		replyError(ctx, "This profile has expired, please start again with `/profile create`.")
+		return nil
+	} else if d.User != ctx.User() {
+		replyError(ctx, "Only the user that started this profile can change it.")
+		return nil
+	}
+	return d
+}
+
+func handleProfilePreset(ctx CommandContext, id string) {
+	d := draftOf(ctx, id)
+	if d == nil {
+		return
+	}
+
+	draftsMu.Lock()
+	d.Preset = ctx.Option("value")
+	if d.Preset == noPreset {
+		d.Preset = ""
+	}
+	n := d.notification()
+	draftsMu.Unlock()
+
+	err := ctx.Update(n)
+	if err != nil {
+		log.Printf("Error updating message: %s", err)
+	}
+}
+
+func handleProfilePower(ctx CommandContext, id string) {
+	d := draftOf(ctx, id)
+	if d == nil {
+		return
+	}
+
+	draftsMu.Lock()
+	d.Power = ctx.Option("value")
+	n := d.notification()
+	draftsMu.Unlock()
+
+	err := ctx.Update(n)
+	if err != nil {
+		log.Printf("Error updating message: %s", err)
+	}
+}
+
+func handleProfileCreateButton(ctx CommandContext, id string) {
+	d := draftOf(ctx, id)
+	if d == nil {
+		return
+	}
+
+	draftsMu.Lock()
+	delete(drafts, id)
+	draftsMu.Unlock()
+
+	p, err := d.profile()
+	if err != nil {
+		replyError(ctx, "Cannot create profile `%s`: %s", d.Name, err)
+		return
+	}
+
+	if err := addProfile(p); err != nil {
+		replyError(ctx, "Cannot create profile `%s`: %s", p.Name, err)
+		return
+	}
+
+	n := profileNotification(p)
+	n.Color = 0x00ff00
+	n.Title = fmt.Sprintf("Created profile %s", p.Name)
+	n.Description = fmt.Sprintf(":white_check_mark: Use `/copy profile:%s` to release it.", p.Name)
+	if err := saveProfile(p); err != nil {
+		log.Printf("Error saving profile %s: %s", p.Name, err)
+		n.Color = 0xffff00
+		n.Description = fmt.Sprintf(":warning: Profile `%s` is available until the bot restarts: %s", p.Name, err)
+	} else {
+		log.Printf("%s created profile %s", ctx.User(), p.Name)
+	}
+
+	err = ctx.Update(n)
+	if err != nil {
+		log.Printf("Error updating message: %s", err)
+	}
+}
+
+func handleProfileCancel(ctx CommandContext, id string) {
+	d := draftOf(ctx, id)
+	if d == nil {
+		return
+	}
+
+	draftsMu.Lock()
+	delete(drafts, id)
+	draftsMu.Unlock()
+
+	err := ctx.Update(&Notification{Color: 0xff0000, Description: fmt.Sprintf(":x: Profile `%s` was not created.", d.Name)})
+	if err != nil {
+		log.Printf("Error updating message: %s", err)
+	}
+}
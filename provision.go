@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// installTimeout is how long /provision waits for the panel to install a new
+// server.
+const installTimeout = 15 * time.Minute
+
+// panelServer is a server as the application API describes it.
+type panelServer struct {
+	ID            int            `json:"id"`
+	UUID          string         `json:"uuid"`
+	Name          string         `json:"name"`
+	User          int            `json:"user"`
+	Node          int            `json:"node"`
+	Egg           int            `json:"egg"`
+	Status        *string        `json:"status"`
+	Limits        map[string]any `json:"limits"`
+	FeatureLimits map[string]any `json:"feature_limits"`
+	Container     struct {
+		StartupCommand string         `json:"startup_command"`
+		Image          string         `json:"image"`
+		Environment    map[string]any `json:"environment"`
+	} `json:"container"`
+}
+
+// applicationServer returns the server with the application API ID id.
+func (c *panelClient) applicationServer(id int) (*panelServer, error) {
+	var resp struct {
+		Attributes panelServer `json:"attributes"`
+	}
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/application/servers/%d", id), nil, &resp)
+	return &resp.Attributes, err
+}
+
+// createServer creates a server named name like template, with the same
+// owner, egg, image, startup command, variables and limits, on a free
+// allocation of the same node. It does not start once installed.
+func (c *panelClient) createServer(template *panelServer, name string) (*panelServer, error) {
+	allocation, err := c.freeAllocation(template.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]any{
+		"name":                name,
+		"user":                template.User,
+		"egg":                 template.Egg,
+		"docker_image":        template.Container.Image,
+		"startup":             template.Container.StartupCommand,
+		"environment":         template.environment(),
+		"limits":              template.Limits,
+		"feature_limits":      template.FeatureLimits,
+		"allocation":          map[string]int{"default": allocation},
+		"start_on_completion": false,
+	}
+
+	var resp struct {
+		Attributes panelServer `json:"attributes"`
+	}
+	err = c.do(http.MethodPost, "/api/application/servers", body, &resp)
+	return &resp.Attributes, err
+}
+
+// waitForInstall polls the server with the application API ID id until the
+// panel has installed it.
+func (c *panelClient) waitForInstall(id int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		s, err := c.applicationServer(id)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case s.Status == nil:
+			return nil
+		case *s.Status == "install_failed":
+			return fmt.Errorf("installation failed")
+		case time.Now().After(deadline):
+			return fmt.Errorf("server is still %s after %s", *s.Status, timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// provisionServer creates a new server like template on the panel and waits
+// until it is installed. Progress is reported through reply.
+func provisionServer(template string, name string, reply func(string)) (*panelServer, error) {
+	id, err := internalID(template)
+	if err != nil {
+		return nil, fmt.Errorf("looking up template server: %w", err)
+	}
+
+	t, err := panelApp.applicationServer(id)
+	if err != nil {
+		return nil, fmt.Errorf("looking up template server: %w", err)
+	}
+
+	reply(fmt.Sprintf("Creating server `%s` like `%s`...", name, t.Name))
+	s, err := panelApp.createServer(t, name)
+	if err != nil {
+		return nil, fmt.Errorf("creating server: %w", err)
+	}
+	log.Printf("Created server %s (%s) from template %s", s.Name, s.UUID, template)
+
+	reply(fmt.Sprintf("Waiting for the panel to install `%s` (`%s`)...", name, s.UUID))
+	err = panelApp.waitForInstall(s.ID, installTimeout)
+	if err != nil {
+		return s, fmt.Errorf("installing server %s: %w", s.UUID, err)
+	}
+
+	return s, nil
+}
+
+func handleProvision(ctx CommandContext) {
+	name := ctx.Option("name")
+	if panel == nil || panelApp == nil {
+		replyError(ctx, "Provisioning requires PANEL_URL, PANEL_API_KEY and PANEL_APPLICATION_KEY!")
+		return
+	} else if findProfile(name) != nil {
+		replyError(ctx, "Profile `%s` already exists!", name)
+		return
+	}
+
+	reply, err := ctx.Reply(&Notification{
+		Color:       0xffff00,
+		Description: "Looking up the template server...",
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+	progress := func(msg string) {
+		if reply == nil {
+			return
+		}
+		err := reply.Edit(&Notification{Color: 0xffff00, Description: msg})
+		if err != nil {
+			log.Printf("Error editing reply: %s", err)
+		}
+	}
+
+	template := ctx.Option("template")
+	s, err := provisionServer(template, name, progress)
+	var p *profile
+	if err == nil {
+		p, err = newBootstrapProfile(name, template, s.UUID, ctx.Option("preset"))
+	}
+	if err != nil {
+		log.Printf("Error provisioning %s: %s", name, err)
+		n := &Notification{
+			Color:       0xff0000,
+			Description: fmt.Sprintf(":x: Failed to provision `%s`!\n```\n%s\n```", name, err),
+		}
+		if reply == nil {
+			_, err = ctx.Reply(n)
+		} else {
+			err = reply.Edit(n)
+		}
+		if err != nil {
+			log.Printf("Error replying to command: %s", err)
+		}
+		return
+	}
+
+	// The installed files are replaced by those of the template.
+	bootstrap(ctx, reply, p, true)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// defaultDrainCommand moves every player on the backend to the fallback
+// server. Both Velocity and BungeeCord accept a server name for send.
+const defaultDrainCommand = "send {backend} {fallback}"
+
+// proxyConfig describes the Velocity or BungeeCord proxy in front of the
+// destination. Commands are run on the proxy console through the panel and
+// may use the {backend} and {fallback} placeholders.
+type proxyConfig struct {
+	Server   string   `yaml:"server"`
+	Backend  string   `yaml:"backend"`
+	Fallback string   `yaml:"fallback"`
+	Drain    []string `yaml:"drain"`
+	Undrain  []string `yaml:"undrain"`
+}
+
+func (c *proxyConfig) init() error {
+	if c.Server == "" {
+		return fmt.Errorf("proxy.server: no proxy server UUID found")
+	} else if c.Backend == "" {
+		return fmt.Errorf("proxy.backend: no backend name found")
+	} else if c.Fallback == "" {
+		return fmt.Errorf("proxy.fallback: no fallback server name found")
+	}
+
+	if c.Drain == nil {
+		c.Drain = []string{defaultDrainCommand}
+	}
+
+	return nil
+}
+
+func (c *proxyConfig) run(commands []string) error {
+	r := strings.NewReplacer("{backend}", c.Backend, "{fallback}", c.Fallback)
+	for _, command := range commands {
+		err := panel.command(c.Server, r.Replace(command))
+		if err != nil {
+			return fmt.Errorf("%s: %w", command, err)
+		}
+	}
+
+	return nil
+}
+
+// drainDestination moves players off the destination before copying.
+func drainDestination(ctx context.Context, j *job) error {
+	_, span := j.startPhase(ctx, "drain")
+	err := j.Profile.Proxy.run(j.Profile.Proxy.Drain)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	j.logf("Moved players from %s to %s", j.Profile.Proxy.Backend, j.Profile.Proxy.Fallback)
+	return nil
+}
+
+// undrainDestination registers the destination with the proxy again.
+func undrainDestination(ctx context.Context, j *job) {
+	_, span := j.startPhase(ctx, "undrain")
+	err := j.Profile.Proxy.run(j.Profile.Proxy.Undrain)
+	endSpan(span, err)
+	if err != nil {
+		j.warnf("Error re-registering %s with the proxy: %s", j.Profile.Proxy.Backend, err)
+	}
+}
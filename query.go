@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocols the destination can be queried with.
+const (
+	queryMinecraft = "minecraft"
+	queryA2S       = "a2s"
+)
+
+// defaultQueryTimeout is how long the destination is queried for after it
+// was restarted, as it may still be starting without a smoke check.
+const defaultQueryTimeout = 2 * time.Minute
+
+// serverQuery asks the destination how it is doing once it was restarted,
+// with the Minecraft server list ping or the A2S query of Source games.
+type serverQuery struct {
+	// Protocol is "minecraft", the default, or "a2s".
+	Protocol string `yaml:"protocol"`
+
+	// Address is the host and port to query. By default it is the primary
+	// allocation of the destination in the panel.
+	Address string `yaml:"address"`
+
+	// Timeout is how long to keep trying, 2m by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (q *serverQuery) init() error {
+	switch q.Protocol {
+	case "":
+		q.Protocol = queryMinecraft
+	case queryMinecraft, queryA2S:
+	default:
+		return fmt.Errorf("query.protocol must be %q or %q", queryMinecraft, queryA2S)
+	}
+
+	if q.Address != "" {
+		if _, _, err := net.SplitHostPort(q.Address); err != nil {
+			return fmt.Errorf("query.address: %w", err)
+		}
+	}
+
+	if q.Timeout < 0 {
+		return fmt.Errorf("query.timeout must not be negative")
+	} else if q.Timeout == 0 {
+		q.Timeout = defaultQueryTimeout
+	}
+	return nil
+}
+
+// serverStatus is what a game server reports about itself.
+type serverStatus struct {
+	MOTD       string `json:"motd"`
+	Version    string `json:"version"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"`
+}
+
+func (s *serverStatus) String() string {
+	return fmt.Sprintf("%s, %d/%d players", s.Version, s.Players, s.MaxPlayers)
+}
+
+// queryDestination queries the destination of j until it answers or the
+// timeout of the query runs out.
+func queryDestination(j *job) (*serverStatus, error) {
+	q := j.Profile.Query
+	addr := q.Address
+	if addr == "" {
+		var err error
+		addr, err = j.panel().address(j.Profile.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("looking up address: %w", err)
+		}
+	}
+
+	query := pingMinecraft
+	if q.Protocol == queryA2S {
+		query = queryA2SInfo
+	}
+
+	deadline := time.Now().Add(q.Timeout)
+	for {
+		status, err := query(addr)
+		if err == nil {
+			return status, nil
+		} else if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+
+		select {
+		case <-j.ctx.Done():
+			return nil, j.canceled()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// address returns the host and port of the primary allocation of server.
+// Allocations on all interfaces are reached over the loopback interface, as
+// the bot runs on the node.
+func (c *panelClient) address(server string) (string, error) {
+	var resp struct {
+		Attributes struct {
+			Relationships struct {
+				Allocations struct {
+					Data []struct {
+						Attributes struct {
+							IP        string `json:"ip"`
+							Alias     string `json:"ip_alias"`
+							Port      int    `json:"port"`
+							IsDefault bool   `json:"is_default"`
+						} `json:"attributes"`
+					} `json:"data"`
+				} `json:"allocations"`
+			} `json:"relationships"`
+		} `json:"attributes"`
+	}
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/client/servers/%s", server), nil, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range resp.Attributes.Relationships.Allocations.Data {
+		if !a.Attributes.IsDefault {
+			continue
+		}
+
+		host := a.Attributes.IP
+		if a.Attributes.Alias != "" {
+			host = a.Attributes.Alias
+		} else if host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		return net.JoinHostPort(host, strconv.Itoa(a.Attributes.Port)), nil
+	}
+	return "", errors.New("server has no primary allocation")
+}
+
+// queryTimeout bounds a single query.
+const queryTimeout = 5 * time.Second
+
+// pingMinecraft asks the Minecraft server at addr for its status with the
+// server list ping of Minecraft 1.7 and later.
+func pingMinecraft(addr string) (*serverStatus, error) {
+	conn, err := net.DialTimeout("tcp", addr, queryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	// Handshake with protocol version -1 and the next state status,
+	// followed by a status request.
+	var handshake bytes.Buffer
+	handshake.WriteByte(0x00)
+	handshake.Write(binary.AppendUvarint(nil, uint64(uint32(0xffffffff))))
+	handshake.Write(binary.AppendUvarint(nil, uint64(len(host))))
+	handshake.WriteString(host)
+	handshake.Write(binary.BigEndian.AppendUint16(nil, uint16(port)))
+	handshake.WriteByte(0x01)
+
+	var req bytes.Buffer
+	req.Write(binary.AppendUvarint(nil, uint64(handshake.Len())))
+	req.Write(handshake.Bytes())
+	req.Write([]byte{0x01, 0x00})
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	if _, err := binary.ReadUvarint(r); err != nil {
+		return nil, err
+	}
+	if id, err := binary.ReadUvarint(r); err != nil {
+		return nil, err
+	} else if id != 0x00 {
+		return nil, fmt.Errorf("unexpected packet %#x", id)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	} else if n > 1<<20 {
+		return nil, fmt.Errorf("status of %d bytes is too large", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Version struct {
+			Name string `json:"name"`
+		} `json:"version"`
+		Players struct {
+			Max    int `json:"max"`
+			Online int `json:"online"`
+		} `json:"players"`
+		Description json.RawMessage `json:"description"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serverStatus{
+		MOTD:       stripFormatting(chatText(resp.Description)),
+		Version:    resp.Version.Name,
+		Players:    resp.Players.Online,
+		MaxPlayers: resp.Players.Max,
+	}, nil
+}
+
+// chatText returns the plain text of a Minecraft chat component, which is
+// either a string or an object with text and extra components.
+func chatText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+
+	var c struct {
+		Text  string            `json:"text"`
+		Extra []json.RawMessage `json:"extra"`
+	}
+	if json.Unmarshal(raw, &c) != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Text)
+	for _, extra := range c.Extra {
+		b.WriteString(chatText(extra))
+	}
+	return b.String()
+}
+
+// formattingCode matches the § color and style codes of Minecraft.
+var formattingCode = regexp.MustCompile(`§.`)
+
+func stripFormatting(s string) string {
+	return strings.TrimSpace(formattingCode.ReplaceAllString(s, ""))
+}
+
+// queryField shows what the destination reported once it was restarted.
+func queryField(s *serverStatus) NotificationField {
+	value := fmt.Sprintf(":green_circle: %s\n%d/%d players online", s.Version, s.Players, s.MaxPlayers)
+	if s.MOTD != "" {
+		value = fmt.Sprintf("%s\n```\n%s\n```", value, truncateRunes(s.MOTD, 500))
+	}
+	return NotificationField{Name: "Live Server", Value: value}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Defaults for a Minecraft server.
+const (
+	defaultSaveOff        = "save-off"
+	defaultSaveAll        = "save-all flush"
+	defaultSavedPattern   = `Saved the game|Saved the world`
+	defaultSaveOn         = "save-on"
+	defaultQuiesceTimeout = time.Minute
+)
+
+// quiesce stops the running source server from writing its world while it
+// is read, through console commands sent via the panel.
+type quiesce struct {
+	SaveOff string        `yaml:"save_off"`
+	SaveAll string        `yaml:"save_all"`
+	Saved   string        `yaml:"saved"`
+	SaveOn  string        `yaml:"save_on"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	saved *regexp.Regexp
+}
+
+func (q *quiesce) init() error {
+	if q.SaveOff == "" {
+		q.SaveOff = defaultSaveOff
+	}
+	if q.SaveAll == "" {
+		q.SaveAll = defaultSaveAll
+	}
+	if q.Saved == "" {
+		q.Saved = defaultSavedPattern
+	}
+	if q.SaveOn == "" {
+		q.SaveOn = defaultSaveOn
+	}
+	if q.Timeout == 0 {
+		q.Timeout = defaultQuiesceTimeout
+	}
+
+	var err error
+	q.saved, err = regexp.Compile(q.Saved)
+	if err != nil {
+		return fmt.Errorf("quiesce.saved: %w", err)
+	}
+
+	return nil
+}
+
+// quiesceSource turns off saving on the source server and flushes the world
+// to disk. The returned function turns saving back on.
+func quiesceSource(ctx context.Context, j *job) (func(), error) {
+	p := j.Profile
+	q := p.Quiesce
+
+	_, span := j.startPhase(ctx, "quiesce")
+	err := func() error {
+		c, err := openConsole(p.Source)
+		if err != nil {
+			return fmt.Errorf("opening console: %w", err)
+		}
+		defer c.Close()
+
+		err = panel.command(p.Source, q.SaveOff)
+		if err != nil {
+			return err
+		}
+
+		err = panel.command(p.Source, q.SaveAll)
+		if err != nil {
+			return err
+		}
+
+		return c.waitForLine(q.saved, q.Timeout)
+	}()
+	endSpan(span, err)
+
+	resume := func() {
+		err := panel.command(p.Source, q.SaveOn)
+		if err != nil {
+			j.warnf("Error turning saving back on at the source: %s", err)
+			return
+		}
+		j.logf("Turned saving back on at the source")
+	}
+
+	if err != nil {
+		resume()
+		return nil, err
+	}
+
+	j.logf("Saved and paused the world of the source")
+	return resume, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxNotesShown is the length of the changed files and plugins listed in
+// the completion notification, which stays below the 1024 characters
+// Discord allows per field. All files are in the attached diff.
+const (
+	maxNotesShown   = 500
+	maxPluginsShown = 300
+)
+
+// releasedManifest is the source of the last successful release of a
+// profile, which the next release is compared with.
+type releasedManifest struct {
+	Job     string             `json:"job"`
+	Time    time.Time          `json:"time"`
+	Files   manifest           `json:"files"`
+	Plugins map[string]jarInfo `json:"plugins"`
+}
+
+// releasedManifestPath is where the manifest of the last successful release
+// of a profile is stored.
+func releasedManifestPath(name string) string {
+	return filepath.Join(dataDir, "manifests", name+".json")
+}
+
+// loadReleasedManifest returns the manifest of the last successful release
+// of a profile, or nil if none was stored.
+func loadReleasedManifest(name string) (*releasedManifest, error) {
+	data, err := os.ReadFile(releasedManifestPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	m := &releasedManifest{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func saveReleasedManifest(name string, m *releasedManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(releasedManifestPath(name)), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := releasedManifestPath(name) + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, releasedManifestPath(name))
+}
+
+// readPlugins returns the plugins and mods of dir keyed by their directory
+// and name, such as plugins/luckperms.
+func readPlugins(dir string) (map[string]jarInfo, error) {
+	plugins := map[string]jarInfo{}
+	for _, jarDir := range jarDirs {
+		jars, err := readJars(filepath.Join(dir, jarDir))
+		if err != nil {
+			return nil, err
+		}
+
+		for name, info := range jars {
+			plugins[jarDir+"/"+name] = info
+		}
+	}
+	return plugins, nil
+}
+
+// pluginChanges returns how the plugins and mods changed from before to
+// after.
+func pluginChanges(before map[string]jarInfo, after map[string]jarInfo) *jarChanges {
+	changes := &jarChanges{}
+	for key, info := range after {
+		old, ok := before[key]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, info)
+		case old.Version != info.Version:
+			changes.Upgraded = append(changes.Upgraded, [2]jarInfo{old, info})
+		}
+	}
+	for key, info := range before {
+		if _, ok := after[key]; !ok {
+			changes.Removed = append(changes.Removed, info)
+		}
+	}
+
+	sort.Slice(changes.Added, func(a, b int) bool { return changes.Added[a].Name < changes.Added[b].Name })
+	sort.Slice(changes.Removed, func(a, b int) bool { return changes.Removed[a].Name < changes.Removed[b].Name })
+	sort.Slice(changes.Upgraded, func(a, b int) bool { return changes.Upgraded[a][1].Name < changes.Upgraded[b][1].Name })
+	return changes
+}
+
+// releaseNotes are what a release changes compared with the last successful
+// release of the profile.
+type releaseNotes struct {
+	// Since and SinceTime are the ID and start of the last release.
+	Since     string
+	SinceTime time.Time
+
+	// Files are the changed source files, prefixed with - for removed, ~
+	// for modified and + for added files.
+	Files []string
+
+	// Plugins are the plugins and mods added, removed or changed in
+	// version.
+	Plugins *jarChanges
+}
+
+// counts returns the number of added, removed and modified files.
+func (n *releaseNotes) counts() (added int, removed int, modified int) {
+	for _, change := range n.Files {
+		switch change[0] {
+		case '+':
+			added++
+		case '-':
+			removed++
+		default:
+			modified++
+		}
+	}
+	return added, removed, modified
+}
+
+// takeReleaseNotes compares the manifest of j with the last successful
+// release of the profile. The manifest of j is kept to be stored once the
+// release succeeded.
+func takeReleaseNotes(j *job, srcDir string) error {
+	plugins, err := readPlugins(srcDir)
+	if err != nil {
+		return err
+	}
+	j.released = &releasedManifest{Job: j.ID, Time: j.StartedAt, Files: j.manifest, Plugins: plugins}
+
+	last, err := loadReleasedManifest(j.Profile.Name)
+	if err != nil || last == nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.Notes = &releaseNotes{
+		Since:     last.Job,
+		SinceTime: last.Time,
+		Files:     last.Files.diff(j.manifest),
+		Plugins:   pluginChanges(last.Plugins, plugins),
+	}
+	j.mu.Unlock()
+	return nil
+}
+
+// notesField summarizes the release notes of a release.
+func notesField(n *releaseNotes) NotificationField {
+	added, removed, modified := n.counts()
+	value := fmt.Sprintf("%d added, %d removed, %d modified since job `%s` of %s", added, removed, modified, n.Since, n.SinceTime.Format("2006-01-02 15:04"))
+
+	if len(n.Files) > 0 {
+		value += fmt.Sprintf("\n```diff\n%s```", shownLines(n.Files, maxNotesShown))
+	}
+	if !n.Plugins.empty() {
+		value += fmt.Sprintf("\n```diff\n%s```", shownLines(strings.Split(n.Plugins.String(), "\n"), maxPluginsShown))
+	}
+
+	return NotificationField{Name: "Changed Since Last Release", Value: value}
+}
+
+// shownLines joins as many of lines as fit in limit characters, and counts
+// the rest.
+func shownLines(lines []string, limit int) string {
+	var b strings.Builder
+	for i, line := range lines {
+		if b.Len()+len(line)+1 > limit {
+			fmt.Fprintf(&b, "...and %d more\n", len(lines)-i)
+			break
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,5 @@
+// Package releaserpb is the gRPC API of releaser, generated from
+// releaser.proto.
+package releaserpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative releaser.proto
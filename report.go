@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// maxReports is the number of release reports kept in the data directory.
+const maxReports = 100
+
+// maxLargest is the number of largest files and directories listed in a
+// report.
+const maxLargest = 20
+
+// reportSink collects the files a job copied and deleted and writes them to
+// a report once it finishes. Entries are sorted by path and carry no
+// timestamps, so the reports of two releases of the same source can be
+// diffed and compared against golden files.
+type reportSink struct {
+	mu      sync.Mutex
+	copied  map[string]int64
+	deleted []string
+}
+
+func newReportSink() *reportSink {
+	return &reportSink{copied: map[string]int64{}}
+}
+
+func (s *reportSink) Handle(j *job, e *Event) {
+	switch e.Type {
+	case EventFileCopied:
+		s.mu.Lock()
+		s.copied[reportPath(j, e.Path)] = e.Bytes
+		s.mu.Unlock()
+	case EventFileDeleted:
+		s.mu.Lock()
+		s.deleted = append(s.deleted, reportPath(j, e.Path))
+		s.mu.Unlock()
+	case EventFinished:
+		err := s.write(j, e)
+		if err != nil {
+			j.logf("Error writing release report: %s", err)
+		}
+	}
+}
+
+// reportPath returns path relative to the destination of j.
+func reportPath(j *job, path string) string {
+	rel, err := filepath.Rel(j.Profile.dstDir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func reportsDir() string {
+	return filepath.Join(dataDir, "reports")
+}
+
+func reportFile(id string) string {
+	return filepath.Join(reportsDir(), id+".txt")
+}
+
+func (s *reportSink) write(j *job, finished *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.MkdirAll(reportsDir(), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(reportFile(j.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	copied := make([]string, 0, len(s.copied))
+	var bytes int64
+	for path, n := range s.copied {
+		copied = append(copied, path)
+		bytes += n
+	}
+	sort.Strings(copied)
+	sort.Strings(s.deleted)
+	warnings := j.sortedWarnings()
+
+	j.mu.Lock()
+	renamed := append([]string{}, j.Renamed...)
+	j.mu.Unlock()
+
+	largest := largestCopied(s.copied, maxLargest)
+	j.mu.Lock()
+	j.Largest = largest
+	j.mu.Unlock()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "profile: %s\n", j.Profile.Name)
+	fmt.Fprintf(w, "source: %s\n", j.Profile.Source)
+	fmt.Fprintf(w, "destination: %s\n", j.Profile.Destination)
+	if finished.Success {
+		fmt.Fprintf(w, "result: success\n")
+	} else {
+		fmt.Fprintf(w, "result: %s\n", errorCodeOf(j.Err).Code)
+	}
+	fmt.Fprintf(w, "copied: %d files, %d bytes\n", len(copied), bytes)
+	fmt.Fprintf(w, "deleted: %d files\n", len(s.deleted))
+	fmt.Fprintf(w, "renamed: %d files\n", len(renamed))
+	fmt.Fprintf(w, "warnings: %d\n", len(warnings))
+	if j.manifest != nil {
+		if j.Inconsistent {
+			fmt.Fprintf(w, "consistency: inconsistent, %d source files changed\n", len(j.SourceChanges))
+		} else {
+			fmt.Fprintf(w, "consistency: consistent\n")
+		}
+	}
+
+	fmt.Fprintf(w, "\n[largest files]\n")
+	for _, e := range largest.Files {
+		fmt.Fprintf(w, "%d\t%s\n", e.Bytes, e.Path)
+	}
+	fmt.Fprintf(w, "\n[largest directories]\n")
+	for _, e := range largest.Dirs {
+		fmt.Fprintf(w, "%d\t%s\n", e.Bytes, e.Path)
+	}
+
+	fmt.Fprintf(w, "\n[copied]\n")
+	for _, path := range copied {
+		fmt.Fprintf(w, "%d\t%s\n", s.copied[path], path)
+	}
+	fmt.Fprintf(w, "\n[deleted]\n")
+	for _, path := range s.deleted {
+		fmt.Fprintf(w, "%s\n", path)
+	}
+	fmt.Fprintf(w, "\n[renamed]\n")
+	for _, r := range renamed {
+		fmt.Fprintf(w, "%s\n", r)
+	}
+	if j.Inconsistent {
+		fmt.Fprintf(w, "\n[source changes]\n")
+		for _, change := range j.SourceChanges {
+			fmt.Fprintf(w, "%s\n", change)
+		}
+	}
+	fmt.Fprintf(w, "\n[warnings]\n")
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "%s\n", warning)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	j.logf("Wrote release report to %s", reportFile(j.ID))
+	pruneReports()
+	return nil
+}
+
+// sizeEntry is a file or directory and the bytes copied into it.
+type sizeEntry struct {
+	Path  string
+	Bytes int64
+}
+
+// largest lists the files and directories that most of a release was
+// spent on, so that things like a backups folder left in the source stand
+// out.
+type largest struct {
+	Files []sizeEntry
+	Dirs  []sizeEntry
+}
+
+// largestCopied returns the n largest of the copied files and of the
+// directories holding them, counting everything below a directory towards
+// it. Ties are broken by path to keep reports stable.
+func largestCopied(copied map[string]int64, n int) *largest {
+	files := make([]sizeEntry, 0, len(copied))
+	dirSizes := map[string]int64{}
+	for file, bytes := range copied {
+		files = append(files, sizeEntry{file, bytes})
+		for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+			dirSizes[dir] += bytes
+		}
+	}
+
+	dirs := make([]sizeEntry, 0, len(dirSizes))
+	for dir, bytes := range dirSizes {
+		dirs = append(dirs, sizeEntry{dir, bytes})
+	}
+
+	return &largest{Files: topSizes(files, n), Dirs: topSizes(dirs, n)}
+}
+
+func topSizes(entries []sizeEntry, n int) []sizeEntry {
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Bytes != entries[b].Bytes {
+			return entries[a].Bytes > entries[b].Bytes
+		}
+		return entries[a].Path < entries[b].Path
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// pruneReports removes the oldest reports beyond maxReports.
+func pruneReports() {
+	entries, err := os.ReadDir(reportsDir())
+	if err != nil || len(entries) <= maxReports {
+		return
+	}
+
+	type report struct {
+		path    string
+		modTime int64
+	}
+	reports := []report{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		reports = append(reports, report{filepath.Join(reportsDir(), entry.Name()), info.ModTime().UnixNano()})
+	}
+	sort.Slice(reports, func(a, b int) bool {
+		return reports[a].modTime < reports[b].modTime
+	})
+
+	for _, r := range reports[:len(reports)-maxReports] {
+		os.Remove(r.path)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// remindRestart waits for the remind_restart delay of the profile of j,
+// which finished at finished, and reminds the channel of j if the
+// destination does not seem to run the released files yet. A panic is
+// reported instead of taking the bot down.
+func remindRestart(j *job, finished time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := panicError(r)
+			j.logf("Panic while reminding to restart: %s", err)
+			reportError(err, j.reportTags(map[string]string{"job": j.ID, "profile": j.Profile.Name}))
+		}
+	}()
+
+	time.Sleep(time.Until(finished.Add(j.Profile.RemindRestart)))
+
+	reason, err := notRestarted(j, finished)
+	if err != nil {
+		j.logf("Error checking whether the destination was restarted: %s", err)
+		return
+	} else if reason == "" {
+		return
+	}
+	j.logf("Reminding to restart the destination, which %s", reason)
+
+	targets := j.notifiers
+	if targets == nil {
+		targets = notifiers
+	}
+	notifyAll(targets, &Notification{
+		Color:       0xffa500,
+		Title:       ":alarm_clock: Destination not restarted",
+		Description: fmt.Sprintf("`%s` %s, %s after the release finished. Start or restart it to load the released files.", j.Profile.Destination, reason, j.Profile.RemindRestart),
+		Fields: []NotificationField{
+			{
+				Name:  "Job ID",
+				Value: fmt.Sprintf("`%s`", j.ID),
+			},
+			{
+				Name:  "Profile",
+				Value: fmt.Sprintf("`%s`", j.Profile.Name),
+			},
+		},
+	})
+}
+
+// notRestarted returns why the destination of j seems to still run the
+// files from before j finished at finished, or an empty string if it was
+// started since. Without the panel, a running server cannot be told apart
+// from one started before the release.
+func notRestarted(j *job, finished time.Time) (string, error) {
+	if panel == nil || filepath.IsAbs(j.Profile.Destination) {
+		if detectRunningServer(j.Profile.dstDir) == "" {
+			return "does not appear to be running", nil
+		}
+		return "", nil
+	}
+
+	state, uptime, err := j.panel().resources(j.Profile.Destination)
+	switch {
+	case err != nil:
+		return "", err
+	case state == "offline":
+		return "is offline", nil
+	case state == "running" && uptime > time.Since(finished):
+		return fmt.Sprintf("has been running for %s, since before the release", uptime.Round(time.Minute)), nil
+	}
+	return "", nil
+}
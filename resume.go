@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxTransferRetries is how often a file copied over a remote node is
+	// resumed after the connection dropped before the copy fails.
+	maxTransferRetries = 5
+
+	// transferRetryDelay is the wait before the first retry, doubled after
+	// every further one up to maxTransferRetryDelay.
+	transferRetryDelay    = 2 * time.Second
+	maxTransferRetryDelay = time.Minute
+
+	// resumeBufferSize is the size of the chunks files are copied in over
+	// remote nodes, and so the most that is sent again after a drop.
+	resumeBufferSize = 4 << 20
+)
+
+// isTransient reports whether err is caused by a dropped connection to a
+// remote node rather than by the file itself.
+func isTransient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ENOTCONN, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ETIMEDOUT, syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.EPIPE, syscall.EIO} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// remote reports whether j reads from or writes to a remote node.
+func (j *job) remote() bool {
+	for _, server := range []string{j.Profile.Source, j.Profile.Destination} {
+		if n := findNode(server); n != nil && n.remote() {
+			return true
+		}
+	}
+	return false
+}
+
+// copyResumable copies srcPath to dstPath in chunks. If the connection to a
+// remote node drops, it remounts the servers of j and continues from the
+// last chunk written rather than from the start of the file.
+func copyResumable(j *job, srcPath string, dstPath string, mode os.FileMode) (int64, error) {
+	var offset int64
+	delay := transferRetryDelay
+	for retries := 0; ; retries++ {
+		var err error
+		offset, err = copyFrom(j, srcPath, dstPath, mode, offset)
+		if err == nil || !isTransient(err) || retries == maxTransferRetries {
+			return offset, err
+		}
+
+		j.logf("Connection dropped copying %s at %s, resuming in %s: %s", srcPath, formatBytes(offset), delay, err)
+		select {
+		case <-j.ctx.Done():
+			return offset, j.canceled()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxTransferRetryDelay)
+
+		if err := j.Profile.mount(); err != nil {
+			j.logf("Error remounting: %s", err)
+		}
+	}
+}
+
+// copyFrom copies srcPath to dstPath from offset on and returns how far it
+// got. It continues from the end of dstPath instead if that is shorter, as
+// writes in flight when the connection dropped may not have arrived.
+func copyFrom(j *job, srcPath string, dstPath string, mode os.FileMode, offset int64) (int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return offset, err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE, mode)
+	if err != nil {
+		return offset, err
+	}
+
+	info, err := dst.Stat()
+	if err == nil {
+		offset = min(offset, info.Size())
+		err = dst.Truncate(offset)
+	}
+	if err == nil {
+		_, err = dst.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		_, err = src.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		dst.Close()
+		return offset, err
+	}
+
+	size, release, err := reserveBuffer(j.ctx, resumeBufferSize)
+	if err != nil {
+		dst.Close()
+		return offset, err
+	}
+	defer release()
+
+	buf := make([]byte, size)
+	for {
+		n, rerr := io.ReadFull(src, buf)
+		if n > 0 {
+			err := j.throttle(int64(n))
+			if err == nil {
+				_, err = dst.Write(buf[:n])
+			}
+			if err != nil {
+				dst.Close()
+				return offset, err
+			}
+			offset += int64(n)
+		}
+
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			dst.Close()
+			return offset, rerr
+		}
+	}
+
+	if durability == durabilityFile {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return offset, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"strconv"
+)
+
+// dryRun reports whether a release of p only reports what it would do.
+// option is the dry-run option of the command, which sandbox profiles
+// default to when it is not given.
+func (p *profile) dryRun(option string) bool {
+	if option == "" {
+		return p.Sandbox
+	}
+	b, _ := strconv.ParseBool(option)
+	return b
+}
+
+// runDryRun scans the source and destination of j like a release would and
+// records what it would do, without touching either.
+func runDryRun(ctx context.Context, j *job) error {
+	_, span := j.startPhase(ctx, "dry_run")
+	e, err := estimateRelease(j.Profile, j.srcDir)
+	endSpan(span, err)
+	if err != nil {
+		j.logf("Error scanning server files: %s", err)
+		return withCode("E_SCAN", err)
+	}
+
+	j.mu.Lock()
+	j.Plan = e
+	j.mu.Unlock()
+	j.logf("Dry run: would copy %d files (%s), %d changed, and delete %d files", e.Files, formatBytes(e.Bytes), e.Changed, len(e.Deleted))
+	return nil
+}
+
+// sandboxContext is an interaction in a test guild, where only sandbox
+// profiles can be released.
+type sandboxContext struct {
+	CommandContext
+}
+
+func (c *sandboxContext) Profiles() []*profile {
+	visible := []*profile{}
+	for _, p := range allProfiles() {
+		if p.Sandbox {
+			visible = append(visible, p)
+		}
+	}
+	return visible
+}
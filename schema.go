@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkSchema reports the keys in the YAML document data that the type of v
+// does not have, such as misspelled options that would otherwise be ignored.
+// All of them are returned, with where they are and the closest known key.
+func checkSchema(data []byte, v any) error {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	} else if len(doc.Content) == 0 {
+		return nil
+	}
+
+	errs := []error{}
+	checkNode(doc.Content[0], reflect.TypeOf(v), "", &errs)
+	return errors.Join(errs...)
+}
+
+func checkNode(n *yaml.Node, t reflect.Type, path string, errs *[]error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Value == "<<" {
+				checkNode(value, t, path, errs)
+				continue
+			}
+
+			field, ok := fields[key.Value]
+			if !ok {
+				*errs = append(*errs, unknownKey(path, key, fields))
+				continue
+			}
+			checkNode(value, field.Type, joinKey(path, key.Value), errs)
+		}
+	case t.Kind() == reflect.Map && n.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			checkNode(n.Content[i+1], t.Elem(), joinKey(path, n.Content[i].Value), errs)
+		}
+	case t.Kind() == reflect.Slice && n.Kind == yaml.SequenceNode:
+		for i, item := range n.Content {
+			checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// yamlFields returns the fields of the struct type t by their YAML key,
+// including those of inlined structs.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		} else if strings.Contains(opts, "inline") {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		} else if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func joinKey(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func unknownKey(path string, key *yaml.Node, fields map[string]reflect.StructField) error {
+	msg := fmt.Sprintf("line %d: unknown key %q", key.Line, key.Value)
+	if path != "" {
+		msg = path + ": " + msg
+	}
+
+	best, bestDist := "", 3
+	for name := range fields {
+		d := editDistance(key.Value, name)
+		if d < bestDist || (d == bestDist && best != "" && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	if best != "" {
+		msg += fmt.Sprintf(", did you mean %q?", best)
+	}
+
+	return errors.New(msg)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkGlobs reports the first malformed pattern in rules, which are listed
+// under key in the config.
+func checkGlobs(key string, rules []string) error {
+	for i, rule := range rules {
+		_, err := filepath.Match(filepath.Clean(rule), "")
+		if err != nil {
+			return fmt.Errorf("%s[%d]: invalid glob %q", key, i, rule)
+		}
+	}
+	return nil
+}
+
+func (r *ruleSet) check() error {
+	lists := []struct {
+		key   string
+		rules []string
+	}{
+		{"keep", r.Keep},
+		{"keep_if_exists", r.KeepIfExists},
+		{"seed_once", r.SeedOnce},
+		{"exclude", r.Exclude},
+		{"merge", r.Merge},
+	}
+	for _, l := range lists {
+		err := checkGlobs(l.key, l.rules)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// vaultPrefix marks secrets that are read from HashiCorp Vault, as
+// vault:<path>#<field>, e.g. vault:secret/data/releaser#discord_token.
+const vaultPrefix = "vault:"
+
+// envSecret returns the secret in the environment variable key, or read from
+// the file named by key_FILE as mounted by Docker and Kubernetes secrets.
+// Either may refer to a secret in Vault instead.
+func envSecret(key string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		path := os.Getenv(key + "_FILE")
+		if path == "" {
+			return ""
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Error reading %s_FILE: %s", key, err)
+		}
+		v = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if ref, ok := strings.CutPrefix(v, vaultPrefix); ok {
+		var err error
+		v, err = readVaultSecret(ref)
+		if err != nil {
+			log.Fatalf("Error reading %s from Vault: %s", key, err)
+		}
+	}
+
+	return v
+}
+
+// readVaultSecret reads the field of a secret in Vault, referred to as
+// <path>#<field>. Both KV version 1 and 2 secrets are supported. Vault is
+// reached at VAULT_ADDR with VAULT_TOKEN.
+func readVaultSecret(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("%q is not of the form <path>#<field>", ref)
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if path := os.Getenv("VAULT_TOKEN_FILE"); token == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return "", fmt.Errorf("VAULT_TOKEN is not set")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("reading %s: %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+
+	// KV version 2 nests the fields of the secret in another data object.
+	data := body.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	v, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("%s has no string field %q", path, field)
+	}
+	return v, nil
+}
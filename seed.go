@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// seededPath is where the seed-once paths already copied for a profile are
+// recorded.
+func seededPath(name string) string {
+	return filepath.Join(dataDir, "seeded", name+".json")
+}
+
+func loadSeeded(name string) (map[string]bool, error) {
+	seeded := map[string]bool{}
+
+	data, err := os.ReadFile(seededPath(name))
+	if os.IsNotExist(err) {
+		return seeded, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	err = json.Unmarshal(data, &paths)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		seeded[path] = true
+	}
+	return seeded, nil
+}
+
+func saveSeeded(name string, seeded map[string]bool) error {
+	paths := []string{}
+	for path := range seeded {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	data, err := json.MarshalIndent(paths, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(seededPath(name)), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := seededPath(name) + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, seededPath(name))
+}
+
+// skipSeed reports whether dst matches a keep-if-exists or seed-once rule and
+// must not be copied, given the seed-once paths copied before. If dst is a
+// seed-once path that is copied now, seed is the path to record.
+func (p *profile) skipSeed(dst string, seeded map[string]bool) (skip bool, seed string) {
+	ifExists := matchRules(p.keepIfExists, p.dstDir, dst)
+	once := matchRules(p.seedOnce, p.dstDir, dst)
+	if !ifExists && !once {
+		return false, ""
+	}
+
+	if _, err := os.Lstat(dst); err == nil {
+		return true, ""
+	}
+
+	if once {
+		rel, err := filepath.Rel(p.dstDir, dst)
+		if err != nil || seeded[rel] {
+			return true, ""
+		}
+		return false, rel
+	}
+
+	return false, ""
+}
+
+// skipSeed is profile.skipSeed for the seed-once paths of j, recording the
+// ones copied now.
+func (j *job) skipSeed(dst string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	skip, seed := j.Profile.skipSeed(dst, j.seeded)
+	if seed != "" {
+		j.seeded[seed] = true
+	}
+	return skip
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// simulationSeed makes every simulated tree the same for the same size, so
+// rehearsals are repeatable.
+const simulationSeed = 1
+
+// simulation generates a fake source and destination server below a
+// temporary base directory and configures a single profile for them.
+type simulation struct {
+	dir   string
+	size  int64
+	files int
+	rand  *rand.Rand
+}
+
+// setupSimulation replaces the configured profiles with a generated pair of
+// servers of about size bytes in files files. Nothing outside of the
+// temporary directory is touched.
+func setupSimulation(size int64, files int) (*simulation, error) {
+	dir, err := os.MkdirTemp("", "releaser-simulation-")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &simulation{dir: dir, size: size, files: files, rand: rand.New(rand.NewSource(simulationSeed))}
+	err = s.generate()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	baseDir = dir
+	dataDir = filepath.Join(dir, "data")
+	if jobLogDir != "" {
+		jobLogDir = filepath.Join(dataDir, "logs")
+	}
+	panel = nil
+	panelApp = nil
+
+	p := &profile{
+		Name:        "simulation",
+		Source:      "source",
+		Destination: "destination",
+		Preset:      "paper",
+		Keep:        []string{"whitelist.json"},
+	}
+	err = p.init(ruleSet{Keep: keepFiles})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	profiles = []*profile{p}
+	groups = nil
+
+	log.Printf("Simulating with %d files (%s) in %s", files, formatBytes(size), dir)
+	return s, nil
+}
+
+func (s *simulation) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// simulatedDirs are where the generated files go, loosely shaped like a
+// Paper server.
+var simulatedDirs = []string{
+	"world/region",
+	"world_nether/DIM-1/region",
+	"world_the_end/DIM1/region",
+	"plugins",
+	"plugins/Essentials/userdata",
+	"plugins/LuckPerms",
+	"config",
+	"logs",
+}
+
+// generate writes the source and a destination that shares most of its
+// files: some are changed, some are only in the destination and will be
+// deleted, and some keep files differ on purpose.
+func (s *simulation) generate() error {
+	src := filepath.Join(s.dir, "source")
+	dst := filepath.Join(s.dir, "destination")
+
+	perFile := s.size / int64(max(s.files, 1))
+	for i := 0; i < s.files; i++ {
+		dir := simulatedDirs[s.rand.Intn(len(simulatedDirs))]
+		name := filepath.Join(dir, fmt.Sprintf("file-%05d.dat", i))
+
+		// Vary sizes around the average so large file handling and
+		// progress reporting get exercised too.
+		size := perFile/2 + s.rand.Int63n(perFile+1)
+		data := make([]byte, size)
+		s.rand.Read(data)
+
+		err := writeSimulated(filepath.Join(src, name), data)
+		if err != nil {
+			return err
+		}
+
+		switch n := s.rand.Intn(10); {
+		case n < 7:
+			err = writeSimulated(filepath.Join(dst, name), data)
+		case n < 9:
+			changed := append([]byte{}, data...)
+			if len(changed) > 0 {
+				changed[0] ^= 0xff
+			}
+			err = writeSimulated(filepath.Join(dst, name), changed)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < s.files/20; i++ {
+		err := writeSimulated(filepath.Join(dst, "plugins", fmt.Sprintf("removed-%03d.jar", i)), []byte("stale"))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range []string{src, dst} {
+		err := writeSimulated(filepath.Join(dir, "server.properties"), []byte("motd=Simulated server\n"))
+		if err == nil {
+			err = writeSimulated(filepath.Join(dir, "paper.jar"), []byte("jar"))
+		}
+		if err == nil {
+			err = writeSimulated(filepath.Join(dir, "whitelist.json"), []byte(fmt.Sprintf("[] # %s\n", filepath.Base(dir))))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeSimulated(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// runSimulation copies the simulated profile once without a chat service
+// and reports the result on stdout and the notifiers.
+func runSimulation() bool {
+	j := newJob(profiles[0], true)
+	ch := make(chan bool)
+	go copy(j, ch)
+
+	notifyAll(notifiers, startedNotification(j))
+	success := <-ch
+	n := resultNotification(j, success)
+	notifyAll(notifiers, n)
+
+	fmt.Println(emoji.Replace(n.text()))
+	return success
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// skipRule leaves files out of a release by their size or age rather than
+// by their path. Skipped files are not copied, and their counterparts at the
+// destination are removed like any other file that is not kept.
+type skipRule struct {
+	// LargerThan skips files over a size such as 5GiB.
+	LargerThan string `yaml:"larger_than"`
+
+	// OlderThan and NewerThan skip files last modified longer or less than
+	// the given time before the release started.
+	OlderThan time.Duration `yaml:"older_than"`
+	NewerThan time.Duration `yaml:"newer_than"`
+
+	// Paths limits the rule to files matching one of these patterns. The
+	// rule applies to every file without them.
+	Paths []string `yaml:"paths"`
+
+	largerThan int64
+}
+
+func (r *skipRule) init() error {
+	if r.LargerThan == "" && r.OlderThan == 0 && r.NewerThan == 0 {
+		return fmt.Errorf("one of larger_than, older_than and newer_than is required")
+	}
+
+	if r.LargerThan != "" {
+		var err error
+		r.largerThan, err = parseSize(r.LargerThan)
+		if err != nil {
+			return fmt.Errorf("larger_than: %w", err)
+		}
+	}
+
+	if r.OlderThan < 0 || r.NewerThan < 0 {
+		return fmt.Errorf("older_than and newer_than must not be negative")
+	}
+
+	return checkGlobs("paths", r.Paths)
+}
+
+// reason returns why the rule skips file, which lies below root, or "" if it
+// does not.
+func (r *skipRule) reason(root string, file string, info fs.FileInfo, now time.Time) string {
+	if len(r.Paths) > 0 && !matchRules(r.Paths, root, file) {
+		return ""
+	}
+
+	age := now.Sub(info.ModTime())
+	switch {
+	case r.largerThan > 0 && info.Size() > r.largerThan:
+		return fmt.Sprintf("larger than %s", r.LargerThan)
+	case r.OlderThan > 0 && age > r.OlderThan:
+		return fmt.Sprintf("older than %s", r.OlderThan)
+	case r.NewerThan > 0 && age < r.NewerThan:
+		return fmt.Sprintf("newer than %s", r.NewerThan)
+	}
+
+	return ""
+}
+
+// skipReason returns why the skip rules of p leave out the regular file
+// file, below root, from a release that started at now, or "" if it is
+// copied.
+func (p *profile) skipReason(root string, file string, info fs.FileInfo, now time.Time) string {
+	if info.IsDir() {
+		return ""
+	}
+
+	for _, r := range p.Skip {
+		if reason := r.reason(root, file, info, now); reason != "" {
+			return reason
+		}
+	}
+
+	return ""
+}
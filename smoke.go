@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Defaults for a Minecraft server.
+const (
+	defaultStartedPattern = `Done \(.+\)!`
+	defaultCrashPattern   = `Exception in server tick loop|This crash report has been saved to|Failed to start the minecraft server`
+	defaultSmokeTimeout   = 3 * time.Minute
+)
+
+// smokeCheck watches the console of the destination after it is restarted
+// to decide whether the release came up healthy.
+type smokeCheck struct {
+	Started string        `yaml:"started"`
+	Crash   string        `yaml:"crash"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	started *regexp.Regexp
+	crash   *regexp.Regexp
+}
+
+func (s *smokeCheck) init() error {
+	if s.Started == "" {
+		s.Started = defaultStartedPattern
+	}
+	if s.Crash == "" {
+		s.Crash = defaultCrashPattern
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaultSmokeTimeout
+	}
+
+	var err error
+	s.started, err = regexp.Compile(s.Started)
+	if err != nil {
+		return fmt.Errorf("smoke_check.started: %w", err)
+	}
+
+	s.crash, err = regexp.Compile(s.Crash)
+	if err != nil {
+		return fmt.Errorf("smoke_check.crash: %w", err)
+	}
+
+	return nil
+}
+
+// restartDestination restarts the destination server through the panel and,
+// if the profile has a smoke check, waits for it to come up healthy.
+func restartDestination(ctx context.Context, j *job) error {
+	p := j.Profile
+
+	var c *console
+	if p.SmokeCheck != nil {
+		// Connect before restarting so no console output is missed.
+		var err error
+		c, err = openConsole(p.Destination)
+		if err != nil {
+			return withCode("E_PANEL", fmt.Errorf("opening console: %w", err))
+		}
+		defer c.Close()
+	}
+
+	_, span := j.startPhase(ctx, "power")
+	err := j.panel().power(p.Destination, "restart")
+	endSpan(span, err)
+	if err != nil {
+		return withCode("E_PANEL", fmt.Errorf("restarting server: %w", err))
+	}
+	j.logf("Restarted destination server")
+
+	if c == nil {
+		return nil
+	}
+
+	_, span = j.startPhase(ctx, "smoke")
+	err = c.waitForStart(p.SmokeCheck.started, p.SmokeCheck.crash, p.SmokeCheck.Timeout)
+	endSpan(span, err)
+	if err != nil {
+		return withCode("E_SMOKE_CHECK", fmt.Errorf("smoke check failed: %w", err))
+	}
+	j.logf("Destination server started successfully")
+	j.Started = true
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stopTimeout is how long a crashed destination may take to go offline
+// before it is restored.
+const stopTimeout = time.Minute
+
+func snapshotDir(j *job) string {
+	return filepath.Join(dataDir, "snapshots", j.ID)
+}
+
+// takeSnapshot copies the destination as it is before the release, so that
+// it can be restored if the release does not come up.
+func takeSnapshot(j *job) error {
+	return copyTree(j.Profile.dstDir, snapshotDir(j))
+}
+
+func removeSnapshot(j *job) {
+	err := os.RemoveAll(snapshotDir(j))
+	if err != nil {
+		j.logf("Error removing snapshot: %s", err)
+	}
+}
+
+// rollback stops the destination, restores the snapshot taken before the
+// release and starts the server again.
+func rollback(ctx context.Context, j *job) error {
+	p := j.Profile
+
+	_, span := j.startPhase(ctx, "rollback")
+	err := func() error {
+		err := j.panel().power(p.Destination, "kill")
+		if err != nil {
+			return fmt.Errorf("killing server: %w", err)
+		}
+
+		err = j.panel().waitForState(p.Destination, "offline", stopTimeout)
+		if err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(p.dstDir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err := os.RemoveAll(filepath.Join(p.dstDir, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = copyTree(snapshotDir(j), p.dstDir)
+		if err != nil {
+			return fmt.Errorf("restoring snapshot: %w", err)
+		}
+
+		err = j.panel().power(p.Destination, "start")
+		if err != nil {
+			return fmt.Errorf("starting server: %w", err)
+		}
+
+		return nil
+	}()
+	endSpan(span, err)
+
+	return err
+}
+
+// copyTree recursively copies src into dst, preserving modes and symlinks.
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyRegularFile(path, target, info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+func copyRegularFile(src string, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkSource refuses to release a source that is missing, empty or lacks
+// one of the marker files of the profile, as happens when a network mount
+// silently comes up empty.
+func checkSource(j *job) error {
+	if j.AllowEmptySource {
+		return nil
+	}
+
+	p := j.Profile
+	entries, err := os.ReadDir(p.srcDir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("source directory %s does not exist", p.srcDir)
+	} else if err != nil {
+		return err
+	} else if len(entries) == 0 {
+		return fmt.Errorf("source directory %s is empty", p.srcDir)
+	}
+
+	for _, marker := range p.Markers {
+		matches, err := filepath.Glob(filepath.Join(p.srcDir, marker))
+		if err != nil {
+			return fmt.Errorf("marker %s: %w", marker, err)
+		} else if len(matches) == 0 {
+			return fmt.Errorf("marker file %s is missing from the source", marker)
+		}
+	}
+
+	return nil
+}
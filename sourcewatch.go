@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+)
+
+// Source watch modes: warn lists the files changed during the copy in the
+// warnings of the job, abort also cancels it at the first change.
+const (
+	watchSourceWarn  = "warn"
+	watchSourceAbort = "abort"
+)
+
+// maxSourceChanges is the number of changed source files listed in the
+// warnings of a job. The rest are only counted.
+const maxSourceChanges = 10
+
+var errSourceChanged = withCode("E_SOURCE_CHANGED", errors.New("source files were modified during the copy"))
+
+// watchSource watches the source of j, rooted at srcDir, for files being
+// modified while they are copied. Excluded and kept files are not
+// watched. The returned function stops watching and reports the changes.
+func (j *job) watchSource(srcDir string) func() {
+	mode := j.Profile.WatchSource
+	if mode == "" {
+		return func() {}
+	}
+
+	skip := func(path string) bool {
+		return j.ignoredSource(srcDir, path)
+	}
+
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	changed := []string{}
+	stop, missed, err := watchTree(srcDir, skip, func(path string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		changed = append(changed, path)
+
+		if len(changed) == 1 && mode == watchSourceAbort {
+			rel, _ := filepath.Rel(srcDir, path)
+			j.logf("Source file %s was modified during the copy, aborting", filepath.ToSlash(rel))
+			j.cancel(errSourceChanged)
+		}
+	})
+	if err != nil {
+		j.warnf("Could not watch the source for changes: %s", err)
+		return func() {}
+	} else if missed != nil {
+		j.warnf("Could not watch all of the source for changes: %s", missed)
+	}
+
+	return func() {
+		stop()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for i, path := range changed {
+			if i == maxSourceChanges {
+				j.warnf("%d more source files were modified during the copy", len(changed)-i)
+				break
+			}
+			rel, _ := filepath.Rel(srcDir, path)
+			j.warnf("Source file %s was modified during the copy", filepath.ToSlash(rel))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ONLYDIR
+
+// watchTree calls changed with every path below root that is modified,
+// created, removed or renamed until the returned function is called.
+// Directories for which skip returns true are not watched. Directories that
+// could not be watched, e.g. because the inotify watch limit was reached,
+// are reported through missed.
+func watchTree(root string, skip func(string) bool, changed func(string)) (stop func(), missed error, err error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Reading through os.File uses the poller, so closing it stops a
+	// blocked read.
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	var mu sync.Mutex
+	dirs := map[int32]string{}
+	watch := func(dir string) error {
+		var first error
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			} else if path != root && skip(path) {
+				return filepath.SkipDir
+			}
+
+			wd, err := unix.InotifyAddWatch(fd, path, inotifyMask)
+			if err != nil {
+				if first == nil {
+					first = &os.PathError{Op: "watch", Path: path, Err: err}
+				}
+				return nil
+			}
+
+			mu.Lock()
+			dirs[int32(wd)] = path
+			mu.Unlock()
+			return nil
+		})
+		return first
+	}
+
+	missed = watch(root)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for off := 0; off+unix.SizeofInotifyEvent <= n; {
+				e := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(e.Len)]
+				off += unix.SizeofInotifyEvent + int(e.Len)
+
+				mu.Lock()
+				dir, ok := dirs[e.Wd]
+				mu.Unlock()
+				if !ok || e.Mask&unix.IN_IGNORED != 0 {
+					continue
+				}
+
+				path := dir
+				if i := bytes.IndexByte(name, 0); i != 0 {
+					if i > 0 {
+						name = name[:i]
+					}
+					path = filepath.Join(dir, string(name))
+				}
+				if path != root && skip(path) {
+					continue
+				}
+
+				// Files written to new directories are changes as well.
+				if e.Mask&unix.IN_ISDIR != 0 && e.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+					watch(path)
+				}
+				changed(path)
+			}
+		}
+	}()
+
+	stop = func() {
+		f.Close()
+		<-done
+	}
+	return stop, missed, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func watchTree(root string, skip func(string) bool, changed func(string)) (stop func(), missed error, err error) {
+	return nil, nil, errors.New("watching files is only supported on Linux")
+}
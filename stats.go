@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// statsRuns is the number of most recent runs shown per profile.
+const statsRuns = 5
+
+// regressionFactor is how much slower than the average a run has to be to be
+// highlighted as a regression.
+const regressionFactor = 0.5
+
+func handleStats(ctx CommandContext) {
+	records, err := loadHistory()
+	if err != nil {
+		log.Printf("Error loading job history: %s", err)
+		_, err = ctx.Reply(&Notification{
+			Color:       0xff0000,
+			Description: ":x: Failed to load the job history!",
+		})
+		if err != nil {
+			log.Printf("Error replying to command: %s", err)
+		}
+		return
+	}
+
+	groups := map[string][]jobRecord{}
+	order := []string{}
+	for _, r := range records {
+		if !r.Success {
+			continue
+		}
+
+		// Jobs from before profiles existed are grouped by server pair.
+		key := r.Profile
+		if key == "" {
+			key = fmt.Sprintf("%s → %s", r.Source, r.Destination)
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], r)
+	}
+
+	n := &Notification{
+		Color: 0x87ceeb,
+		Title: "Copy Statistics",
+	}
+	if len(order) == 0 {
+		n.Description = "No successful copies have been recorded yet."
+	}
+
+	for _, key := range order {
+		n.Fields = append(n.Fields, NotificationField{
+			Name:  key,
+			Value: formatStats(groups[key]),
+		})
+	}
+
+	_, err = ctx.Reply(n)
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+func formatStats(runs []jobRecord) string {
+	var duration time.Duration
+	var rate float64
+	for _, r := range runs {
+		duration += r.Duration
+		rate += r.throughput()
+	}
+	avgDuration := duration / time.Duration(len(runs))
+	avgRate := rate / float64(len(runs))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d runs, average %s at %s/s\n", len(runs), avgDuration.Round(time.Second), formatBytes(int64(avgRate)))
+
+	recent := runs
+	if len(recent) > statsRuns {
+		recent = recent[len(recent)-statsRuns:]
+	}
+	for _, r := range recent {
+		fmt.Fprintf(&b, "`%s` %s: %s in %s at %s/s", r.ID, r.StartedAt.Format("2006-01-02 15:04"), formatBytes(r.Bytes), r.Duration.Round(time.Second), formatBytes(int64(r.throughput())))
+		if r.MessageURL != "" {
+			fmt.Fprintf(&b, " [message](%s)", r.MessageURL)
+		}
+		if len(runs) > 1 && r.throughput() < avgRate*regressionFactor {
+			b.WriteString(" :warning:")
+		}
+		b.WriteString("\n")
+	}
+
+	last := runs[len(runs)-1]
+	if len(runs) > 1 && last.throughput() < avgRate*regressionFactor {
+		b.WriteString(":warning: The last copy was much slower than usual!")
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
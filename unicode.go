@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization forms file names can be converted to on copy.
+// Sources uploaded from macOS often use NFD, while Linux tools and players
+// typing names produce NFC.
+const (
+	normalizeNFC = "nfc"
+	normalizeNFD = "nfd"
+)
+
+func (p *profile) initNormalize() error {
+	switch p.NormalizeNames {
+	case "", normalizeNFC, normalizeNFD:
+		return nil
+	default:
+		return fmt.Errorf("normalize_names must be %q or %q", normalizeNFC, normalizeNFD)
+	}
+}
+
+// normalizeName returns name in the normalization form of p, or unchanged if
+// p does not normalize names.
+func (p *profile) normalizeName(name string) string {
+	switch p.NormalizeNames {
+	case normalizeNFC:
+		return norm.NFC.String(name)
+	case normalizeNFD:
+		return norm.NFD.String(name)
+	default:
+		return name
+	}
+}
+
+// sourceName returns the name in the source directory dir that the
+// destination name was copied from, which may be in another normalization
+// form if p normalizes names.
+func (p *profile) sourceName(dir string, name string) string {
+	if p.NormalizeNames == "" {
+		return name
+	}
+
+	for _, n := range []string{name, norm.NFC.String(name), norm.NFD.String(name)} {
+		if _, err := os.Lstat(filepath.Join(dir, n)); err == nil {
+			return n
+		}
+	}
+	return name
+}
+
+// formCollision returns the name of an earlier entry of the same directory
+// that name is canonically equivalent to, remembering name in seen.
+func formCollision(seen map[string]string, name string) (string, bool) {
+	key := norm.NFC.String(name)
+	if other, ok := seen[key]; ok && other != name {
+		return other, true
+	}
+	seen[key] = name
+	return "", false
+}
+
+// otherForm returns a file in dstDir whose name is canonically equivalent
+// to name but encoded differently, or "" if there is none.
+func otherForm(dstDir string, name string) string {
+	if norm.NFC.IsNormalString(name) && norm.NFD.IsNormalString(name) {
+		return ""
+	}
+
+	for _, n := range []string{norm.NFC.String(name), norm.NFD.String(name)} {
+		if n == name {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dstDir, n)); err == nil {
+			return n
+		}
+	}
+	return ""
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxVerifyShown is the length of the differences listed in the
+// verification notification, which stays below the 1024 characters Discord
+// allows per field. All of them are in the attached diff.
+const maxVerifyShown = 900
+
+// verification is the outcome of comparing the destination of a profile
+// with its source. Paths are relative to the destination.
+type verification struct {
+	Files int
+
+	// Missing files are in the source but not at the destination, Changed
+	// files differ in content and Extra files are only at the destination.
+	Missing []string
+	Changed []string
+	Extra   []string
+}
+
+func (v *verification) ok() bool {
+	return len(v.Missing) == 0 && len(v.Changed) == 0 && len(v.Extra) == 0
+}
+
+// diff lists the differences one per line, prefixed with - for missing, ~
+// for changed and + for extra files.
+func (v *verification) diff() string {
+	var b strings.Builder
+	for _, path := range v.Missing {
+		fmt.Fprintf(&b, "- %s\n", path)
+	}
+	for _, path := range v.Changed {
+		fmt.Fprintf(&b, "~ %s\n", path)
+	}
+	for _, path := range v.Extra {
+		fmt.Fprintf(&b, "+ %s\n", path)
+	}
+	return b.String()
+}
+
+// verifyRelease compares the destination of p with its source by content,
+// applying the rules of the profile like a release would. It does not
+// modify either side.
+func verifyRelease(p *profile) (*verification, error) {
+	seeded, err := loadSeeded(p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files are compared like a release copies them: found by the
+	// concurrent walker, then read by as many workers as hashing may use.
+	c := jobConcurrency(p.srcDir, p.dstDir)
+	if n := cpuConcurrency(); n > 0 {
+		c.Hash = min(c.Hash, n)
+	}
+
+	var mu sync.Mutex
+	paths := []string{}
+	now := time.Now()
+	err = walkConcurrent(p.srcDir, c.Scan, func(path string, d fs.DirEntry) error {
+		rel, err := filepath.Rel(p.srcDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(p.dstDir, p.normalizeName(rel))
+
+		skip, _ := p.skipSeed(dst, seeded)
+		if p.isKeepFile(dst) || p.isExcluded(p.srcDir, path) || skip {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		} else if p.skipReason(p.srcDir, path, info, now) != "" {
+			return nil
+		}
+
+		mu.Lock()
+		paths = append(paths, path)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verification{Files: len(paths)}
+	pool := newPool(c.Hash)
+	for _, path := range paths {
+		path := path
+		pool.Go(func() error {
+			rel, err := filepath.Rel(p.srcDir, path)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(p.dstDir, p.normalizeName(rel))
+
+			same, err := sameContent(path, dst)
+			mu.Lock()
+			defer mu.Unlock()
+			if os.IsNotExist(err) {
+				v.Missing = append(v.Missing, filepath.ToSlash(rel))
+			} else if err != nil {
+				return err
+			} else if !same {
+				v.Changed = append(v.Changed, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+	}
+	err = pool.Wait()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(v.Missing)
+	sort.Strings(v.Changed)
+
+	_, v.Extra, err = countDeletions(p, p.srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// sameContent reports whether the files at a and b have the same content.
+func sameContent(a string, b string) (bool, error) {
+	done, err := useCPU(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer done()
+
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ia, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	ib, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if ib.IsDir() || ia.Size() != ib.Size() {
+		return false, nil
+	}
+
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		} else if errA != nil {
+			return false, errA
+		} else if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+	}
+}
+
+func handleVerifyRelease(ctx CommandContext) {
+	p := selectProfile(ctx)
+	if p == nil {
+		return
+	}
+	p = p.released()
+
+	reply, err := ctx.Reply(&Notification{
+		Color:       0xffff00,
+		Description: "Comparing the destination with the source...",
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+
+	n := verifyNotification(p)
+	log.Printf("Verification of %s by %s: %s", p.Name, ctx.User(), n.Title)
+
+	if reply == nil {
+		_, err = ctx.Reply(n)
+	} else {
+		err = reply.Edit(n)
+	}
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+	notifyAll(notifiers, n)
+}
+
+func verifyNotification(p *profile) *Notification {
+	v, err := verifyRelease(p)
+	if err != nil {
+		log.Printf("Error verifying release of %s: %s", p.Name, err)
+		return &Notification{
+			Color:       0xff0000,
+			Title:       fmt.Sprintf("Could not verify %s", p.Name),
+			Description: fmt.Sprintf(":x: Failed to compare server files!\n```\n%s\n```", err),
+		}
+	}
+
+	fields := []NotificationField{
+		{Name: "Source Server", Value: fmt.Sprintf("`%s`", p.Source)},
+		{Name: "Destination Server", Value: fmt.Sprintf("`%s`", p.Destination)},
+	}
+	if v.ok() {
+		return &Notification{
+			Color:       0x00ff00,
+			Title:       fmt.Sprintf("Verified %s", p.Name),
+			Description: fmt.Sprintf(":white_check_mark: All %d files at the destination match the source.", v.Files),
+			Fields:      fields,
+		}
+	}
+
+	diff := v.diff()
+	lines := strings.SplitAfter(strings.TrimSuffix(diff, "\n"), "\n")
+	var shown strings.Builder
+	for i, line := range lines {
+		if shown.Len()+len(line) > maxVerifyShown {
+			fmt.Fprintf(&shown, "\n...and %d more", len(lines)-i)
+			break
+		}
+		shown.WriteString(line)
+	}
+	fields = append(fields, NotificationField{
+		Name:  "Differences",
+		Value: fmt.Sprintf("%d missing, %d changed, %d extra of %d files\n```diff\n%s\n```", len(v.Missing), len(v.Changed), len(v.Extra), v.Files, shown.String()),
+	})
+
+	return &Notification{
+		Color:       0xff0000,
+		Title:       fmt.Sprintf("Verification of %s failed", p.Name),
+		Description: ":x: The destination does not match the source.",
+		Fields:      fields,
+		Files:       []NotificationFile{{Name: fmt.Sprintf("verify-%s.diff", p.Name), Data: []byte(diff)}},
+	}
+}
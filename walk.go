@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// walkConcurrent calls fn for every file and directory below root, reading up
+// to workers directories at the same time. Idle workers pick up any directory
+// discovered by the others, so a single huge subtree does not serialize the
+// walk. fn may be called concurrently and in any order, so callers that keep
+// what they see must sort it; symlinks are not followed. The first error
+// returned by fn or by reading a directory stops the walk and is returned,
+// except fs.SkipDir, which skips the directory fn was called for.
+// Directories leading back to one of their parents, such as bind mounts of
+// them, are skipped.
+func walkConcurrent(root string, workers int, fn func(path string, d fs.DirEntry) error) error {
+	return walk(root, workers, false, fn)
+}
+
+// walkFollowing is walkConcurrent following symlinks: fn sees what they point
+// to, and symlinked directories are walked too unless they lead back to one
+// of their parents. Broken symlinks are passed to fn as they are.
+func walkFollowing(root string, workers int, fn func(path string, d fs.DirEntry) error) error {
+	return walk(root, workers, true, fn)
+}
+
+func walk(root string, workers int, follow bool, fn func(path string, d fs.DirEntry) error) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	chain, _ := (*dirChain)(nil).enter(root, info)
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		cond     = sync.NewCond(&mu)
+		queue    = []walkItem{{root, chain}}
+		pending  = 1
+		firstErr error
+	)
+
+	worker := func() {
+		for {
+			mu.Lock()
+			for len(queue) == 0 && pending > 0 && firstErr == nil {
+				cond.Wait()
+			}
+			if len(queue) == 0 || firstErr != nil {
+				mu.Unlock()
+				return
+			}
+			dir := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			mu.Unlock()
+
+			subdirs, err := walkDirRecovering(dir, follow, fn)
+
+			mu.Lock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			queue = append(queue, subdirs...)
+			pending += len(subdirs) - 1
+			cond.Broadcast()
+			mu.Unlock()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// walkItem is a directory left to walk.
+type walkItem struct {
+	path    string
+	parents *dirChain
+}
+
+// walkDirRecovering is walkDir returning a panic as its error, so it stops
+// the walk instead of the process.
+func walkDirRecovering(dir walkItem, follow bool, fn func(path string, d fs.DirEntry) error) (subdirs []walkItem, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			subdirs, err = nil, panicError(r)
+		}
+	}()
+
+	return walkDir(dir, follow, fn)
+}
+
+func walkDir(dir walkItem, follow bool, fn func(path string, d fs.DirEntry) error) ([]walkItem, error) {
+	entries, err := os.ReadDir(dir.path)
+	if err != nil {
+		return nil, err
+	}
+
+	subdirs := []walkItem{}
+	for _, entry := range entries {
+		path := filepath.Join(dir.path, entry.Name())
+
+		if follow && entry.Type()&fs.ModeSymlink != 0 {
+			if info, err := os.Stat(path); err == nil {
+				entry = fs.FileInfoToDirEntry(info)
+			}
+		}
+
+		err := fn(path, entry)
+		if err == fs.SkipDir && entry.IsDir() {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if entry.IsDir() {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+
+			parents, loop := dir.parents.enter(path, info)
+			if loop == "" {
+				subdirs = append(subdirs, walkItem{path, parents})
+			}
+		}
+	}
+
+	return subdirs, nil
+}
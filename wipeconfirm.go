@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wipeTimeout is how long a release waits to be confirmed.
+const wipeTimeout = 10 * time.Minute
+
+// wipeOption confirms releasing profiles without keep rules.
+var wipeOption = &CommandOption{
+	Name:        "wipe-destination",
+	Description: "Confirm deleting all destination files of profiles without keep rules",
+	Type:        OptionBool,
+}
+
+// keepsNothing reports whether releasing p deletes or replaces every file of
+// the destination, as it has no rules keeping any.
+func (p *profile) keepsNothing() bool {
+	return len(p.keep) == 0 && len(p.keepIfExists) == 0 && len(p.merge) == 0
+}
+
+// pendingWipe is a release waiting for its user to confirm that all
+// destination files may be deleted.
+type pendingWipe struct {
+	user    string
+	options map[string]string
+	handler HandlerFunc
+	expires time.Time
+}
+
+var (
+	pendingWipesMu sync.Mutex
+	pendingWipes   = map[string]*pendingWipe{}
+)
+
+// optionsContext is an interaction with its options replaced, to run a
+// command again from a button.
+type optionsContext struct {
+	CommandContext
+	options map[string]string
+}
+
+func (c *optionsContext) Option(name string) string {
+	return c.options[name]
+}
+
+// Profiles keeps the profiles visible to the wrapped interaction, such as
+// those of an additional bot, as embedding the interface hides them.
+func (c *optionsContext) Profiles() []*profile {
+	return contextProfiles(c.CommandContext)
+}
+
+// confirmWipe asks the user of ctx to confirm that all destination files of
+// wiped may be deleted. Once confirmed, handler runs again with the same
+// options of the route command and wipe-destination set.
+func confirmWipe(ctx CommandContext, route string, wiped []*profile, handler HandlerFunc) {
+	options := map[string]string{}
+	if cmd := interactions.commands[route]; cmd != nil {
+		for _, o := range cmd.Options {
+			options[o.Name] = ctx.Option(o.Name)
+		}
+	}
+	options[wipeOption.Name] = "true"
+
+	token, err := randomToken()
+	if err != nil {
+		replyError(ctx, "Failed to ask for confirmation: %s", err)
+		return
+	}
+	token = token[:12]
+
+	pendingWipesMu.Lock()
+	for k, w := range pendingWipes {
+		if time.Now().After(w.expires) {
+			delete(pendingWipes, k)
+		}
+	}
+	pendingWipes[token] = &pendingWipe{user: ctx.User(), options: options, handler: handler, expires: time.Now().Add(wipeTimeout)}
+	pendingWipesMu.Unlock()
+
+	verb := "has"
+	if len(wiped) > 1 {
+		verb = "have"
+	}
+	names, dirs := []string{}, []string{}
+	for _, p := range wiped {
+		names = append(names, fmt.Sprintf("`%s`", p.Name))
+		dirs = append(dirs, fmt.Sprintf("`%s`", p.dstDir))
+	}
+	_, err = ctx.Reply(&Notification{
+		Color: 0xff0000,
+		Title: ":warning: ALL destination files will be deleted",
+		Description: fmt.Sprintf("%s %s no keep rules, so every file in %s that is not in the source will be deleted and the rest overwritten.\n"+
+			"Confirm below, or run `/%s` again with the `%s` option.",
+			strings.Join(names, ", "), verb, strings.Join(dirs, ", "), route, wipeOption.Name),
+		Actions: []NotificationAction{
+			{Label: "Delete everything and release", ID: "confirm-wipe:" + token},
+			{Label: "Cancel", ID: "cancel-wipe:" + token},
+		},
+	})
+	if err != nil {
+		log.Printf("Error replying to command: %s", err)
+	}
+}
+
+// takePendingWipe removes and returns the pending release of token if the
+// user of ctx started it, replying with an error otherwise.
+func takePendingWipe(ctx CommandContext, token string) *pendingWipe {
+	pendingWipesMu.Lock()
+	defer pendingWipesMu.Unlock()
+
+	w := pendingWipes[token]
+	if w == nil || time.Now().After(w.expires) {
+		replyError(ctx, "This confirmation has expired, please run the command again.")
+		return nil
+	} else if w.user != ctx.User() {
+		replyError(ctx, "Only the user that started this release can confirm it.")
+		return nil
+	}
+	delete(pendingWipes, token)
+	return w
+}
+
+func handleConfirmWipe(ctx CommandContext, token string) {
+	w := takePendingWipe(ctx, token)
+	if w == nil {
+		return
+	}
+
+	w.handler(&optionsContext{CommandContext: ctx, options: w.options})
+}
+
+func handleCancelWipe(ctx CommandContext, token string) {
+	if takePendingWipe(ctx, token) == nil {
+		return
+	}
+
+	err := ctx.Update(&Notification{Color: 0x00ff00, Description: "Release canceled, nothing was deleted."})
+	if err != nil {
+		log.Printf("Error updating message: %s", err)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// World check modes: warn lists corrupted files in the report, fail also
+// stops the release before the destination is restarted.
+const (
+	worldCheckWarn = "warn"
+	worldCheckFail = "fail"
+)
+
+const (
+	regionSector     = 4096
+	regionHeaderSize = 2 * regionSector
+	regionChunks     = 1024
+
+	// maxNBTDepth bounds the nesting of NBT tags, as Minecraft does.
+	maxNBTDepth = 512
+)
+
+// checkWorlds parses the region files and level.dat files below dstDirPath
+// for structural validity and returns the problems found, one per file.
+func checkWorlds(j *job, dstDirPath string) ([]string, error) {
+	problems := []string{}
+	err := filepath.WalkDir(dstDirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if err := j.canceled(); err != nil {
+			return err
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		var check func(string) error
+		switch {
+		case strings.HasSuffix(d.Name(), ".mca"):
+			check = checkRegion
+		case d.Name() == "level.dat":
+			check = checkLevel
+		default:
+			return nil
+		}
+
+		done, err := useCPU(j.ctx)
+		if err != nil {
+			return err
+		}
+		err = check(path)
+		done()
+		if err != nil {
+			rel, _ := filepath.Rel(dstDirPath, path)
+			problems = append(problems, fmt.Sprintf("%s: %s", filepath.ToSlash(rel), err))
+		}
+		return nil
+	})
+	return problems, err
+}
+
+// checkRegion validates the chunk table of an Anvil region file: every
+// chunk must lie after the header and within the file, must not overlap
+// another chunk and must start with a plausible length and compression.
+func checkRegion(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Minecraft creates empty region files and fills them in later.
+	if info.Size() == 0 {
+		return nil
+	} else if info.Size() < regionHeaderSize {
+		return fmt.Errorf("truncated header (%d bytes)", info.Size())
+	}
+
+	header := make([]byte, regionSector)
+	_, err = io.ReadFull(f, header)
+	if err != nil {
+		return err
+	}
+
+	sectors := (info.Size() + regionSector - 1) / regionSector
+	used := make([]bool, sectors)
+	chunk := make([]byte, 5)
+	for i := 0; i < regionChunks; i++ {
+		entry := binary.BigEndian.Uint32(header[i*4:])
+		offset, count := int64(entry>>8), int64(entry&0xff)
+		if entry == 0 {
+			continue
+		} else if offset < 2 || count == 0 || offset+count > sectors {
+			return fmt.Errorf("chunk %d lies outside the file", i)
+		}
+
+		for s := offset; s < offset+count; s++ {
+			if used[s] {
+				return fmt.Errorf("chunk %d overlaps another chunk", i)
+			}
+			used[s] = true
+		}
+
+		_, err := f.ReadAt(chunk, offset*regionSector)
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
+		}
+
+		length := int64(binary.BigEndian.Uint32(chunk))
+		compression := chunk[4] &^ 0x80
+		if length == 0 || length > count*regionSector-4 {
+			return fmt.Errorf("chunk %d has an invalid length", i)
+		} else if (compression < 1 || compression > 4) && compression != 127 {
+			return fmt.Errorf("chunk %d has unknown compression %d", i, compression)
+		}
+	}
+
+	return nil
+}
+
+// checkLevel parses a gzipped level.dat and checks that it holds a Data
+// compound.
+func checkLevel(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(gz)
+	tag, err := r.ReadByte()
+	if err != nil {
+		return err
+	} else if tag != nbtCompound {
+		return fmt.Errorf("root tag is %d, not a compound", tag)
+	}
+	if _, err := readNBTString(r); err != nil {
+		return err
+	}
+
+	names, err := skipNBTCompound(r, 1)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("truncated NBT")
+	} else if err != nil {
+		return err
+	} else if names["Data"] != nbtCompound {
+		return fmt.Errorf("no Data compound")
+	}
+
+	return nil
+}
+
+// NBT tag types.
+const (
+	nbtEnd byte = iota
+	nbtByte
+	nbtShort
+	nbtInt
+	nbtLong
+	nbtFloat
+	nbtDouble
+	nbtByteArray
+	nbtString
+	nbtList
+	nbtCompound
+	nbtIntArray
+	nbtLongArray
+)
+
+var errNBTDepth = errors.New("NBT nested too deeply")
+
+// skipNBTCompound reads the tags of a compound up to its end tag and
+// returns the types of its direct children by name.
+func skipNBTCompound(r *bufio.Reader, depth int) (map[string]byte, error) {
+	if depth > maxNBTDepth {
+		return nil, errNBTDepth
+	}
+
+	names := map[string]byte{}
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		} else if tag == nbtEnd {
+			return names, nil
+		}
+
+		name, err := readNBTString(r)
+		if err != nil {
+			return nil, err
+		}
+		names[name] = tag
+
+		err = skipNBTPayload(r, tag, depth)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+func skipNBTPayload(r *bufio.Reader, tag byte, depth int) error {
+	switch tag {
+	case nbtByte:
+		return skipBytes(r, 1)
+	case nbtShort:
+		return skipBytes(r, 2)
+	case nbtInt, nbtFloat:
+		return skipBytes(r, 4)
+	case nbtLong, nbtDouble:
+		return skipBytes(r, 8)
+	case nbtByteArray, nbtIntArray, nbtLongArray:
+		n, err := readNBTLength(r)
+		if err != nil {
+			return err
+		}
+		size := map[byte]int64{nbtByteArray: 1, nbtIntArray: 4, nbtLongArray: 8}[tag]
+		return skipBytes(r, n*size)
+	case nbtString:
+		_, err := readNBTString(r)
+		return err
+	case nbtList:
+		elem, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		n, err := readNBTLength(r)
+		if err != nil {
+			return err
+		} else if elem == nbtEnd && n > 0 {
+			return fmt.Errorf("list of end tags")
+		}
+		for i := int64(0); i < n; i++ {
+			err := skipNBTPayload(r, elem, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case nbtCompound:
+		_, err := skipNBTCompound(r, depth+1)
+		return err
+	default:
+		return fmt.Errorf("unknown tag type %d", tag)
+	}
+}
+
+func readNBTLength(r *bufio.Reader) (int64, error) {
+	var n int32
+	err := binary.Read(r, binary.BigEndian, &n)
+	if err != nil {
+		return 0, err
+	} else if n < 0 {
+		return 0, fmt.Errorf("negative length %d", n)
+	}
+	return int64(n), nil
+}
+
+func readNBTString(r *bufio.Reader) (string, error) {
+	var n uint16
+	err := binary.Read(r, binary.BigEndian, &n)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return string(b), err
+}
+
+func skipBytes(r *bufio.Reader, n int64) error {
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
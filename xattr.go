@@ -0,0 +1,25 @@
+package main
+
+import "strings"
+
+// preservedXattrPrefixes are the extended attributes carrying POSIX ACLs and
+// SELinux and other security labels.
+var preservedXattrPrefixes = []string{"system.posix_acl_", "security."}
+
+func isPreservedXattr(name string) bool {
+	for _, prefix := range preservedXattrPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyXattrs copies the ACLs and security labels of src to dst, if the
+// profile asks for it.
+func (j *job) copyXattrs(src string, dst string) error {
+	if !j.Profile.PreserveXattrs {
+		return nil
+	}
+	return copyXattrs(src, dst)
+}